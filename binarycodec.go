@@ -0,0 +1,1075 @@
+package v8worker
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// binaryFormat is the wire format of a binary codec. Go values are converted
+// with reflection by appendValue and byteReader.decode, like encoding/json
+// does, except that []byte is a native byte string instead of base64 text.
+type binaryFormat interface {
+	Name() string
+	appendNil(b []byte) []byte
+	appendBool(b []byte, v bool) []byte
+	appendInt(b []byte, i int64) []byte
+	appendUint(b []byte, u uint64) []byte
+	appendFloat(b []byte, f float64) []byte
+	appendString(b []byte, s string) []byte
+	appendBytes(b []byte, s []byte) []byte
+	appendArrayHead(b []byte, n int) []byte
+	appendMapHead(b []byte, n int) []byte
+	// readItem reads the next item. Strings are read whole; the elements of
+	// arrays and the keys and values of maps follow their head.
+	readItem(d *byteReader) (item, error)
+}
+
+type itemKind int
+
+const (
+	itemNil itemKind = iota
+	itemBool
+	itemInt
+	itemUint
+	itemFloat
+	itemString
+	itemBytes
+	itemArray
+	itemMap
+	itemBreak // ends an indefinite length array or map
+)
+
+var itemNames = [...]string{"null", "bool", "number", "number", "number", "string", "bytes", "array", "map", "break"}
+
+// item is a value read by binaryFormat.readItem, or the head of an array or
+// map of n elements, n being -1 for indefinite length. s is a slice of the
+// data being decoded.
+type item struct {
+	kind itemKind
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	s    []byte
+	n    int
+}
+
+func (it item) int64() (int64, bool) {
+	switch it.kind {
+	case itemInt:
+		return it.i, true
+	case itemUint:
+		return int64(it.u), it.u <= math.MaxInt64
+	case itemFloat:
+		return int64(it.f), it.f == math.Trunc(it.f) && it.f >= math.MinInt64 && it.f < math.MaxInt64
+	}
+	return 0, false
+}
+
+func (it item) uint64() (uint64, bool) {
+	switch it.kind {
+	case itemInt:
+		return uint64(it.i), it.i >= 0
+	case itemUint:
+		return it.u, true
+	case itemFloat:
+		return uint64(it.f), it.f == math.Trunc(it.f) && it.f >= 0 && it.f < math.MaxUint64
+	}
+	return 0, false
+}
+
+func (it item) float64() (float64, bool) {
+	switch it.kind {
+	case itemInt:
+		return float64(it.i), true
+	case itemUint:
+		return float64(it.u), true
+	case itemFloat:
+		return it.f, true
+	}
+	return 0, false
+}
+
+// keyString converts a map key to the string keys of encoding/json.
+func (it item) keyString() (string, bool) {
+	switch it.kind {
+	case itemString, itemBytes:
+		return string(it.s), true
+	case itemInt:
+		return strconv.FormatInt(it.i, 10), true
+	case itemUint:
+		return strconv.FormatUint(it.u, 10), true
+	case itemFloat:
+		return strconv.FormatFloat(it.f, 'g', -1, 64), true
+	case itemBool:
+		return strconv.FormatBool(it.b), true
+	}
+	return "", false
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonNumberType      = reflect.TypeOf(json.Number(""))
+)
+
+// codecType caches the interfaces appendValue and decodeItem look for on
+// every value of a type. The addr fields are about a pointer to the type.
+type codecType struct {
+	marshalJSON, addrMarshalJSON     bool
+	marshalText, addrMarshalText     bool
+	unmarshalJSON, addrUnmarshalJSON bool
+	unmarshalText, addrUnmarshalText bool
+	byteSlice                        bool // encoded as a byte string
+}
+
+var codecTypes sync.Map // reflect.Type -> *codecType
+
+func codecTypeOf(t reflect.Type) *codecType {
+	if ct, ok := codecTypes.Load(t); ok {
+		return ct.(*codecType)
+	}
+	p := reflect.PtrTo(t)
+	ct := &codecType{
+		marshalJSON:       t.Kind() != reflect.Interface && t.Implements(jsonMarshalerType),
+		addrMarshalJSON:   t.Kind() != reflect.Ptr && p.Implements(jsonMarshalerType),
+		marshalText:       t.Kind() != reflect.Interface && t.Implements(textMarshalerType),
+		addrMarshalText:   t.Kind() != reflect.Ptr && p.Implements(textMarshalerType),
+		unmarshalJSON:     t.Kind() == reflect.Ptr && t.Implements(jsonUnmarshalerType),
+		addrUnmarshalJSON: t.Kind() != reflect.Ptr && p.Implements(jsonUnmarshalerType),
+		unmarshalText:     t.Kind() == reflect.Ptr && t.Implements(textUnmarshalerType),
+		addrUnmarshalText: t.Kind() != reflect.Ptr && p.Implements(textUnmarshalerType),
+	}
+	if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+		// bytes which marshal themselves make an array
+		elem := reflect.PtrTo(t.Elem())
+		ct.byteSlice = !elem.Implements(jsonMarshalerType) && !elem.Implements(textMarshalerType)
+	}
+	actual, _ := codecTypes.LoadOrStore(t, ct)
+	return actual.(*codecType)
+}
+
+// binaryBuffers holds the buffers marshalBinary encodes into.
+var binaryBuffers = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+func marshalBinary(f binaryFormat, v interface{}) ([]byte, error) {
+	buf := binaryBuffers.Get().(*[]byte)
+	b, err := appendValue(f, (*buf)[:0], reflect.ValueOf(v))
+	var data []byte
+	if err == nil {
+		data = append([]byte(nil), b...)
+	}
+	if cap(b) <= 64<<10 {
+		*buf = b
+		binaryBuffers.Put(buf)
+	}
+	return data, err
+}
+
+// appendValue encodes v like encoding/json does.
+func appendValue(f binaryFormat, b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return f.appendNil(b), nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return f.appendNil(b), nil
+	}
+	t := v.Type()
+	if t == jsonNumberType {
+		return appendNumber(f, b, v.String())
+	}
+	ct := codecTypeOf(t)
+	if m, ok := implementer(v, ct.marshalJSON, ct.addrMarshalJSON); ok {
+		data, err := m.(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var tree interface{}
+		if err := dec.Decode(&tree); err != nil {
+			return nil, err
+		}
+		return appendValue(f, b, reflect.ValueOf(tree))
+	}
+	if m, ok := implementer(v, ct.marshalText, ct.addrMarshalText); ok {
+		text, err := m.(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return f.appendString(b, string(text)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return f.appendBool(b, v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return f.appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return f.appendUint(b, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return f.appendFloat(b, v.Float()), nil
+	case reflect.String:
+		return f.appendString(b, v.String()), nil
+	case reflect.Ptr, reflect.Interface:
+		return appendValue(f, b, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return f.appendNil(b), nil
+		}
+		if ct.byteSlice {
+			return f.appendBytes(b, v.Bytes()), nil
+		}
+		fallthrough
+	case reflect.Array:
+		b = f.appendArrayHead(b, v.Len())
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendValue(f, b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return f.appendNil(b), nil
+		}
+		type entry struct {
+			key   string
+			value reflect.Value
+		}
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := mapKeyString(f, iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{key, iter.Value()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+		b = f.appendMapHead(b, len(entries))
+		var err error
+		for _, e := range entries {
+			b = f.appendString(b, e.key)
+			if b, err = appendValue(f, b, e.value); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields := structFields(t)
+		n := 0
+		for _, field := range fields {
+			if fv, ok := fieldByIndex(v, field.index); ok && !(field.omitEmpty && isEmptyValue(fv)) {
+				n++
+			}
+		}
+		b = f.appendMapHead(b, n)
+		var err error
+		for _, field := range fields {
+			fv, ok := fieldByIndex(v, field.index)
+			if !ok || field.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			b = f.appendString(b, field.name)
+			if field.quoted {
+				b, err = appendQuoted(f, b, fv)
+			} else {
+				b, err = appendValue(f, b, fv)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, errors.New(f.Name() + ": unsupported type " + t.String())
+}
+
+// appendNumber encodes a json.Number.
+func appendNumber(f binaryFormat, b []byte, s string) ([]byte, error) {
+	if s == "" {
+		return f.appendInt(b, 0), nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return f.appendInt(b, i), nil
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return f.appendUint(b, u), nil
+	}
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, errors.New(f.Name() + ": invalid number " + strconv.Quote(s))
+	}
+	return f.appendFloat(b, x), nil
+}
+
+// appendQuoted encodes a field with the ",string" option as a string.
+func appendQuoted(f binaryFormat, b []byte, v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return f.appendNil(b), nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return f.appendString(b, strconv.FormatBool(v.Bool())), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return f.appendString(b, strconv.FormatInt(v.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return f.appendString(b, strconv.FormatUint(v.Uint(), 10)), nil
+	case reflect.Float32, reflect.Float64:
+		return f.appendString(b, strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())), nil
+	}
+	data, err := json.Marshal(v.String())
+	if err != nil {
+		return nil, err
+	}
+	return f.appendString(b, string(data)), nil
+}
+
+func mapKeyString(f binaryFormat, k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	ct := codecTypeOf(k.Type())
+	if m, ok := implementer(k, ct.marshalText, ct.addrMarshalText); ok {
+		text, err := m.(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", errors.New(f.Name() + ": unsupported map key type " + k.Type().String())
+}
+
+// implementer returns v, or its address if only that implements an
+// interface, as reported by direct and addr.
+func implementer(v reflect.Value, direct, addr bool) (interface{}, bool) {
+	switch {
+	case !v.CanInterface():
+		return nil, false
+	case direct:
+		return v.Interface(), true
+	case addr && v.CanAddr():
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// codecField is a struct field as encoding/json sees it.
+type codecField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	quoted    bool // the ",string" option
+	tagged    bool
+}
+
+var codecFields sync.Map // reflect.Type -> []codecField
+
+func structFields(t reflect.Type) []codecField {
+	if fields, ok := codecFields.Load(t); ok {
+		return fields.([]codecField)
+	}
+	fields, _ := codecFields.LoadOrStore(t, typeFields(t))
+	return fields.([]codecField)
+}
+
+// typeFields lists the fields of struct type t in the order of encoding/json,
+// including the fields of embedded structs. Fields hide those of the same name
+// nested deeper; of several at the same depth only a single tagged one is
+// kept.
+func typeFields(t reflect.Type) []codecField {
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	var fields []codecField
+	hidden := make(map[string]bool)
+	visited := make(map[reflect.Type]bool)
+	for next := []embedded{{t: t}}; len(next) > 0; {
+		current := next
+		next = nil
+		var level []codecField
+		for _, e := range current {
+			if visited[e.t] {
+				continue
+			}
+			for i := 0; i < e.t.NumField(); i++ {
+				sf := e.t.Field(i)
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if !sf.IsExported() && !(sf.Anonymous && ft.Kind() == reflect.Struct) {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(append([]int(nil), e.index...), i)
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{ft, index})
+					continue
+				}
+				if !sf.IsExported() {
+					continue
+				}
+				field := codecField{name: name, index: index, tagged: name != ""}
+				if name == "" {
+					field.name = sf.Name
+				}
+				for _, opt := range strings.Split(opts, ",") {
+					switch opt {
+					case "omitempty":
+						field.omitEmpty = true
+					case "string":
+						switch ft.Kind() {
+						case reflect.Bool, reflect.String,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+							reflect.Float32, reflect.Float64:
+							field.quoted = true
+						}
+					}
+				}
+				level = append(level, field)
+			}
+		}
+		for _, e := range current {
+			visited[e.t] = true
+		}
+
+		byName := make(map[string][]codecField)
+		for _, field := range level {
+			byName[field.name] = append(byName[field.name], field)
+		}
+		for name, candidates := range byName {
+			if hidden[name] {
+				continue
+			}
+			hidden[name] = true
+			var tagged []codecField
+			for _, c := range candidates {
+				if c.tagged {
+					tagged = append(tagged, c)
+				}
+			}
+			switch {
+			case len(candidates) == 1:
+				fields = append(fields, candidates[0])
+			case len(tagged) == 1:
+				fields = append(fields, tagged[0])
+			}
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i].index, fields[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	return fields
+}
+
+// fieldByIndex is v.FieldByIndex stopping at nil embedded pointers.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func unmarshalBinary(f binaryFormat, data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New(f.Name() + ": Unmarshal needs a non-nil pointer")
+	}
+	d := &byteReader{data: data, f: f}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return errors.New(f.Name() + ": trailing data")
+	}
+	return nil
+}
+
+// byteReader decodes the binary codecs.
+type byteReader struct {
+	data []byte
+	pos  int
+	f    binaryFormat
+}
+
+func (d *byteReader) errorf(msg string) error {
+	return errors.New(d.f.Name() + ": " + msg + " at offset " + strconv.Itoa(d.pos))
+}
+
+func (d *byteReader) typeError(it item, t reflect.Type) error {
+	return d.errorf("cannot unmarshal " + itemNames[it.kind] + " into Go value of type " + t.String())
+}
+
+func (d *byteReader) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, d.errorf("unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint reads an n byte big-endian unsigned integer.
+func (d *byteReader) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// str reads a string or byte string of n bytes.
+func (d *byteReader) str(kind itemKind, n uint64) (item, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return item{}, d.errorf("unexpected end of data")
+	}
+	s, _ := d.next(int(n))
+	return item{kind: kind, s: s}, nil
+}
+
+// head returns the head of an array or map of n elements, each of which
+// takes at least a byte.
+func (d *byteReader) head(kind itemKind, n uint64) (item, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return item{}, d.errorf("unexpected end of data")
+	}
+	return item{kind: kind, n: int(n)}, nil
+}
+
+// element reads element i of the array headed by it, or the key of entry i of
+// the map, whose value the caller must read. It reports false after the last
+// one.
+func (d *byteReader) element(it item, i int) (item, bool, error) {
+	if it.n >= 0 && i >= it.n {
+		return item{}, false, nil
+	}
+	e, err := d.f.readItem(d)
+	if err != nil {
+		return item{}, false, err
+	}
+	if e.kind == itemBreak {
+		if it.n < 0 {
+			return item{}, false, nil
+		}
+		return item{}, false, d.errorf("unexpected break")
+	}
+	return e, true, nil
+}
+
+// skip reads the next item and drops it.
+func (d *byteReader) skip() error {
+	it, err := d.f.readItem(d)
+	if err != nil {
+		return err
+	}
+	return d.skipItem(it)
+}
+
+func (d *byteReader) skipItem(it item) error {
+	switch it.kind {
+	case itemArray, itemMap:
+		for i := 0; ; i++ {
+			e, ok, err := d.element(it, i)
+			if err != nil || !ok {
+				return err
+			}
+			if err := d.skipItem(e); err != nil {
+				return err
+			}
+			if it.kind == itemMap {
+				if err := d.skip(); err != nil {
+					return err
+				}
+			}
+		}
+	case itemBreak:
+		return d.errorf("unexpected break")
+	}
+	return nil
+}
+
+// decode reads the next item into v.
+func (d *byteReader) decode(v reflect.Value) error {
+	it, err := d.f.readItem(d)
+	if err != nil {
+		return err
+	}
+	return d.decodeItem(it, v)
+}
+
+// decodeItem stores it, reading the elements of arrays and maps, in v like
+// encoding/json does.
+func (d *byteReader) decodeItem(it item, v reflect.Value) error {
+	switch it.kind {
+	case itemBreak:
+		return d.errorf("unexpected break")
+	case itemNil:
+		switch v.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	ju, tu, v := indirect(v)
+	if ju != nil {
+		tree, err := d.decodeInterface(it)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(tree)
+		if err != nil {
+			return err
+		}
+		return ju.UnmarshalJSON(data)
+	}
+	if tu != nil {
+		if it.kind != itemString && it.kind != itemBytes {
+			return d.typeError(it, reflect.TypeOf(tu))
+		}
+		return tu.UnmarshalText(it.s)
+	}
+
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.typeError(it, t)
+		}
+		x, err := d.decodeInterface(it)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(x))
+		return nil
+	case reflect.Bool:
+		if it.kind != itemBool {
+			return d.typeError(it, t)
+		}
+		v.SetBool(it.b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := it.int64()
+		if !ok || v.OverflowInt(n) {
+			return d.typeError(it, t)
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := it.uint64()
+		if !ok || v.OverflowUint(n) {
+			return d.typeError(it, t)
+		}
+		v.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		x, ok := it.float64()
+		if !ok || v.OverflowFloat(x) {
+			return d.typeError(it, t)
+		}
+		v.SetFloat(x)
+		return nil
+	case reflect.String:
+		if it.kind == itemString {
+			v.SetString(string(it.s))
+			return nil
+		}
+		if t == jsonNumberType && (it.kind == itemInt || it.kind == itemUint || it.kind == itemFloat) {
+			s, _ := it.keyString()
+			v.SetString(s)
+			return nil
+		}
+		return d.typeError(it, t)
+	case reflect.Slice:
+		byteSlice := codecTypeOf(t).byteSlice
+		if byteSlice && it.kind == itemBytes {
+			v.SetBytes(append([]byte{}, it.s...))
+			return nil
+		}
+		if byteSlice && it.kind == itemString {
+			// base64 text, as encoding/json writes []byte
+			b, err := base64.StdEncoding.DecodeString(string(it.s))
+			if err != nil {
+				return d.errorf(err.Error())
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		if it.kind != itemArray {
+			return d.typeError(it, t)
+		}
+		if it.n >= 0 {
+			v.Set(reflect.MakeSlice(t, it.n, it.n))
+		} else {
+			v.Set(reflect.MakeSlice(t, 0, 0))
+		}
+		for i := 0; ; i++ {
+			e, ok, err := d.element(it, i)
+			if err != nil || !ok {
+				return err
+			}
+			if i >= v.Len() {
+				v.Set(reflect.Append(v, reflect.Zero(t.Elem())))
+			}
+			if err := d.decodeItem(e, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		if it.kind != itemArray {
+			return d.typeError(it, t)
+		}
+		i := 0
+		for ; ; i++ {
+			e, ok, err := d.element(it, i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if i < v.Len() {
+				err = d.decodeItem(e, v.Index(i))
+			} else {
+				err = d.skipItem(e)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for ; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(t.Elem()))
+		}
+		return nil
+	case reflect.Map:
+		if it.kind != itemMap {
+			return d.typeError(it, t)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		for i := 0; ; i++ {
+			k, ok, err := d.element(it, i)
+			if err != nil || !ok {
+				return err
+			}
+			key, err := d.mapKey(k, t.Key())
+			if err != nil {
+				return err
+			}
+			elem.Set(reflect.Zero(t.Elem()))
+			if err := d.decode(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		if it.kind != itemMap {
+			return d.typeError(it, t)
+		}
+		fields := structFields(t)
+		for i := 0; ; i++ {
+			k, ok, err := d.element(it, i)
+			if err != nil || !ok {
+				return err
+			}
+			field, err := d.findField(fields, k)
+			if err != nil {
+				return err
+			}
+			if field == nil {
+				err = d.skip()
+			} else {
+				var fv reflect.Value
+				if fv, err = d.fieldForSet(v, field.index); err == nil {
+					if field.quoted {
+						err = d.decodeQuoted(fv)
+					} else {
+						err = d.decode(fv)
+					}
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return d.typeError(it, t)
+}
+
+// indirect allocates the pointers v leads through, stopping early at a
+// json.Unmarshaler or encoding.TextUnmarshaler, like encoding/json does.
+func indirect(v reflect.Value) (json.Unmarshaler, encoding.TextUnmarshaler, reflect.Value) {
+	// only types declared in packages have methods
+	if v.Kind() != reflect.Ptr && v.CanAddr() && v.Type().PkgPath() != "" {
+		if ct := codecTypeOf(v.Type()); ct.addrUnmarshalJSON || ct.addrUnmarshalText {
+			v = v.Addr()
+		}
+	}
+	for {
+		if v.Kind() == reflect.Interface && !v.IsNil() {
+			if e := v.Elem(); e.Kind() == reflect.Ptr && !e.IsNil() {
+				v = e
+				continue
+			}
+		}
+		if v.Kind() != reflect.Ptr {
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Type().NumMethod() > 0 && v.CanInterface() {
+			if ct := codecTypeOf(v.Type()); ct.unmarshalJSON {
+				return v.Interface().(json.Unmarshaler), nil, reflect.Value{}
+			} else if ct.unmarshalText {
+				return nil, v.Interface().(encoding.TextUnmarshaler), reflect.Value{}
+			}
+		}
+		v = v.Elem()
+	}
+	return nil, nil, v
+}
+
+// decodeInterface returns the generic value of it like encoding/json does for
+// interface{}, except that byte strings are []byte.
+func (d *byteReader) decodeInterface(it item) (interface{}, error) {
+	switch it.kind {
+	case itemNil:
+		return nil, nil
+	case itemBool:
+		return it.b, nil
+	case itemInt, itemUint, itemFloat:
+		x, _ := it.float64()
+		return x, nil
+	case itemString:
+		return string(it.s), nil
+	case itemBytes:
+		return append([]byte{}, it.s...), nil
+	case itemArray:
+		a := make([]interface{}, 0, max(it.n, 0))
+		for i := 0; ; i++ {
+			e, ok, err := d.element(it, i)
+			if err != nil || !ok {
+				return a, err
+			}
+			x, err := d.decodeInterface(e)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, x)
+		}
+	case itemMap:
+		m := make(map[string]interface{})
+		for i := 0; ; i++ {
+			k, ok, err := d.element(it, i)
+			if err != nil || !ok {
+				return m, err
+			}
+			key, ok := k.keyString()
+			if !ok {
+				return nil, d.errorf("invalid map key")
+			}
+			e, err := d.f.readItem(d)
+			if err != nil {
+				return nil, err
+			}
+			if e.kind == itemBreak {
+				return nil, d.errorf("unexpected break")
+			}
+			if m[key], err = d.decodeInterface(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, d.errorf("unexpected break")
+}
+
+// mapKey converts a map key to a key of type kt like encoding/json does.
+func (d *byteReader) mapKey(k item, kt reflect.Type) (reflect.Value, error) {
+	s, ok := k.keyString()
+	if !ok {
+		return reflect.Value{}, d.errorf("invalid map key")
+	}
+	if reflect.PtrTo(kt).Implements(textUnmarshalerType) {
+		key := reflect.New(kt)
+		if err := key.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return reflect.Value{}, err
+		}
+		return key.Elem(), nil
+	}
+	key := reflect.New(kt).Elem()
+	switch kt.Kind() {
+	case reflect.String:
+		key.SetString(s)
+		return key, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err == nil && !key.OverflowInt(n) {
+			key.SetInt(n)
+			return key, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err == nil && !key.OverflowUint(n) {
+			key.SetUint(n)
+			return key, nil
+		}
+	}
+	return reflect.Value{}, d.errorf("cannot unmarshal map key " + strconv.Quote(s) + " into Go value of type " + kt.String())
+}
+
+// findField returns the field named by the key k, or else the one matching it
+// case insensitively like encoding/json does, or nil.
+func (d *byteReader) findField(fields []codecField, k item) (*codecField, error) {
+	if k.kind != itemString && k.kind != itemBytes {
+		name, ok := k.keyString()
+		if !ok {
+			return nil, d.errorf("invalid map key")
+		}
+		k.s = []byte(name)
+	}
+	for i := range fields {
+		if fields[i].name == string(k.s) {
+			return &fields[i], nil
+		}
+	}
+	for i := range fields {
+		if bytes.EqualFold([]byte(fields[i].name), k.s) {
+			return &fields[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// fieldForSet returns the field of struct v at index, allocating nil embedded
+// pointers on the way.
+func (d *byteReader) fieldForSet(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, d.errorf("cannot set embedded pointer to unexported struct " + v.Type().Elem().String())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// decodeQuoted reads a field with the ",string" option from a string.
+func (d *byteReader) decodeQuoted(v reflect.Value) error {
+	it, err := d.f.readItem(d)
+	if err != nil {
+		return err
+	}
+	if it.kind == itemNil {
+		return d.decodeItem(it, v)
+	}
+	if it.kind != itemString {
+		return d.typeError(it, v.Type())
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	s := string(it.s)
+	invalid := d.errorf("invalid use of ,string struct tag, trying to unmarshal " + strconv.Quote(s) + " into " + v.Type().String())
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return invalid
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v.OverflowInt(n) {
+			return invalid
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || v.OverflowUint(n) {
+			return invalid
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil || v.OverflowFloat(x) {
+			return invalid
+		}
+		v.SetFloat(x)
+	default:
+		var str string
+		if err := json.Unmarshal(it.s, &str); err != nil {
+			return invalid
+		}
+		v.SetString(str)
+	}
+	return nil
+}
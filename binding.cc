@@ -4,6 +4,7 @@
 #include <string.h>
 #include <stdbool.h>
 #include <string>
+#include <pthread.h>
 #include "v8.h"
 #include "libplatform/libplatform.h"
 #include "binding.h"
@@ -28,8 +29,76 @@ struct worker_s {
   Persistent<Function> recv;
   Persistent<Context> context;
   Persistent<Function> recv_sync_handler;
+  std::string sync_response;
+  bool dead;
+  size_t stack_size;
+  int depth;
+  bool last_terminated;
 };
 
+// Tracks nested entries into V8 from golang. Once the outermost entry has
+// unwound a terminated execution, termination is cancelled so the worker can
+// run javascript again.
+class EntryScope {
+ public:
+  EntryScope(worker* w, TryCatch* try_catch) : w_(w), try_catch_(try_catch) {
+    w_->depth++;
+    w_->last_terminated = false;
+  }
+  ~EntryScope() {
+    w_->last_terminated = try_catch_->HasTerminated();
+    w_->depth--;
+    if (w_->depth == 0 && w_->isolate->IsExecutionTerminating()) {
+      w_->isolate->CancelTerminateExecution();
+    }
+  }
+
+ private:
+  worker* w_;
+  TryCatch* try_catch_;
+};
+
+// Stack kept free below the isolate stack limit for cgo and V8 itself.
+const size_t kStackReserve = 64 * 1024;
+
+// Returns the number of bytes of native stack left below sp on the calling
+// thread, or 0 if it can't be determined.
+size_t ThreadStackAvailable(uintptr_t sp) {
+  uintptr_t bottom = 0;
+#if defined(__APPLE__)
+  pthread_t self = pthread_self();
+  bottom = (uintptr_t)pthread_get_stackaddr_np(self) - pthread_get_stacksize_np(self);
+#elif defined(__linux__)
+  pthread_attr_t attr;
+  if (pthread_getattr_np(pthread_self(), &attr) != 0) {
+    return 0;
+  }
+  void* addr;
+  size_t size;
+  pthread_attr_getstack(&attr, &addr, &size);
+  pthread_attr_destroy(&attr);
+  bottom = (uintptr_t)addr;
+#endif
+  if (bottom == 0 || sp <= bottom) {
+    return 0;
+  }
+  return sp - bottom;
+}
+
+// cgo may enter V8 on any OS thread, so the stack limit set when the isolate
+// was created means nothing. It is computed again relative to the current
+// stack position on every entry, capped by what the thread actually has.
+void SetStackLimit(worker* w) {
+  char here;
+  uintptr_t sp = reinterpret_cast<uintptr_t>(&here);
+  size_t size = w->stack_size;
+  size_t available = ThreadStackAvailable(sp);
+  if (available > 0 && size + kStackReserve > available) {
+    size = available > 2 * kStackReserve ? available - kStackReserve : available / 2;
+  }
+  w->isolate->SetStackLimit(sp - size);
+}
+
 // Extracts a C string from a V8 Utf8Value.
 const char* ToCString(const String::Utf8Value& value) {
   return *value ? *value : "<string conversion failed>";
@@ -95,10 +164,104 @@ std::string ExceptionString(Isolate* isolate, TryCatch* try_catch) {
 }
 
 
+// Builds a ScriptOrigin in the current HandleScope from the fields of the
+// golang ScriptOrigin.
+ScriptOrigin NewScriptOrigin(Isolate* isolate, char* name_s, int line_offset_s, int column_offset_s, bool is_shared_cross_origin_s, int script_id_s, bool is_embedder_debug_script_s, char* source_map_url_s, bool is_opaque_s) {
+  Local<String> name = String::NewFromUtf8(isolate, name_s);
+  Local<Integer> line_offset = Integer::New(isolate, line_offset_s);
+  Local<Integer> column_offset = Integer::New(isolate, column_offset_s);
+  Local<Boolean> is_shared_cross_origin = Boolean::New(isolate, is_shared_cross_origin_s);
+  Local<Integer> script_id = Integer::New(isolate, script_id_s);
+  Local<Boolean> is_embedder_debug_script = Boolean::New(isolate, is_embedder_debug_script_s);
+  Local<String> source_map_url = String::NewFromUtf8(isolate, source_map_url_s);
+  Local<Boolean> is_opaque = Boolean::New(isolate, is_opaque_s);
+
+  return ScriptOrigin(name, line_offset, column_offset, is_shared_cross_origin, script_id, is_embedder_debug_script, source_map_url, is_opaque);
+}
+
+// Returns the JSON text of value like JSON.stringify, or an empty handle with
+// an exception pending. v8::JSON only has Parse in this V8 version, so the
+// builtin stringify is called. Values without a JSON form, like undefined,
+// give "null".
+MaybeLocal<String> JSONStringify(Isolate* isolate, Local<Context> context, Local<Value> value) {
+  Local<Object> json = context->Global()->Get(String::NewFromUtf8(isolate, "JSON"))->ToObject();
+  Local<Function> stringify = Local<Function>::Cast(json->Get(String::NewFromUtf8(isolate, "stringify")));
+  Local<Value> args[1] = {value};
+  Local<Value> result = stringify->Call(json, 1, args);
+  if (result.IsEmpty()) {
+    return MaybeLocal<String>();
+  }
+  if (!result->IsString()) {
+    return String::NewFromUtf8(isolate, "null");
+  }
+  return result->ToString();
+}
+
+// Formats of the messages passed between golang and javascript.
+enum MessageKind { kMessageString, kMessageJSON, kMessageBytes };
+
+// Returns the javascript value of the message msg of len bytes: a string, the
+// value of JSON text or an Uint8Array. On failure the handle is empty and an
+// exception is pending.
+MaybeLocal<Value> MessageValue(Isolate* isolate, const char* msg, int len, MessageKind kind) {
+  switch (kind) {
+    case kMessageJSON:
+      return JSON::Parse(isolate, String::NewFromUtf8(isolate, msg));
+    case kMessageBytes: {
+      Local<ArrayBuffer> buffer = ArrayBuffer::New(isolate, len);
+      memcpy(buffer->GetContents().Data(), msg, len);
+      return Uint8Array::New(buffer, 0, len);
+    }
+    default:
+      return String::NewFromUtf8(isolate, msg);
+  }
+}
+
+// Copies the bytes of view to out.
+void CopyBytes(Local<ArrayBufferView> view, std::string* out) {
+  out->resize(view->ByteLength());
+  if (!out->empty()) {
+    view->CopyContents(&(*out)[0], out->size());
+  }
+}
+
 extern "C" {
 
 extern void recvCb(char*, int);
+extern char* recvJSONCb(char*, int);
+extern char* recvBytesCb(char*, int, int);
 extern char* recvSyncCb(char*, int);
+extern void fatalCb(char*, char*, heap_statistics*, int);
+extern int streamReadCb(int, char*, int);
+extern int requireResolveCb(int, char*, char*, char**);
+extern int requireReadCb(int, char*, char**);
+
+// Feeds V8's background parser with chunks read from a golang io.Reader.
+class GoSourceStream : public ScriptCompiler::ExternalSourceStream {
+ public:
+  GoSourceStream(int stream_id) : stream_id_(stream_id) {}
+  virtual size_t GetMoreData(const uint8_t** src) {
+    const int chunk_size = 32 * 1024;
+    // V8 takes ownership of the chunk and releases it with delete[].
+    uint8_t* chunk = new uint8_t[chunk_size];
+    int n = streamReadCb(stream_id_, (char*)chunk, chunk_size);
+    if (n <= 0) {
+      delete[] chunk;
+      *src = NULL;
+      return 0;
+    }
+    *src = chunk;
+    return n;
+  }
+
+ private:
+  int stream_id_;
+};
+
+struct stream_s {
+  ScriptCompiler::StreamedSource* source;
+  ScriptCompiler::ScriptStreamingTask* task;
+};
 
 const char* worker_version() {
   return V8::GetVersion();
@@ -108,27 +271,29 @@ const char* worker_last_exception(worker* w) {
   return w->last_exception.c_str();
 }
 
+bool worker_is_dead(worker* w) {
+  return w->dead || w->isolate->IsDead();
+}
+
 int worker_load(worker* w, char* source_s, char* name_s, int line_offset_s, int column_offset_s, bool is_shared_cross_origin_s, int script_id_s, bool is_embedder_debug_script_s, char* source_map_url_s, bool is_opaque_s) {
+  if (worker_is_dead(w)) {
+    w->last_exception = "worker is dead";
+    return 3;
+  }
+
   Locker locker(w->isolate);
   Isolate::Scope isolate_scope(w->isolate);
+  SetStackLimit(w);
   HandleScope handle_scope(w->isolate);
 
   Local<Context> context = Local<Context>::New(w->isolate, w->context);
   Context::Scope context_scope(context);
 
   TryCatch try_catch;
+  EntryScope entry_scope(w, &try_catch);
 
-  Local<String> name = String::NewFromUtf8(w->isolate, name_s);
   Local<String> source = String::NewFromUtf8(w->isolate, source_s);
-  Local<Integer> line_offset = Integer::New(w->isolate, line_offset_s);
-  Local<Integer> column_offset = Integer::New(w->isolate, column_offset_s);
-  Local<Boolean> is_shared_cross_origin = Boolean::New(w->isolate, is_shared_cross_origin_s);
-  Local<Integer> script_id = Integer::New(w->isolate, script_id_s);
-  Local<Boolean> is_embedder_debug_script = Boolean::New(w->isolate, is_embedder_debug_script_s);
-  Local<String> source_map_url = String::NewFromUtf8(w->isolate, source_map_url_s);
-  Local<Boolean> is_opaque = Boolean::New(w->isolate, is_opaque_s);
-
-  ScriptOrigin origin(name, line_offset, column_offset, is_shared_cross_origin, script_id, is_embedder_debug_script, source_map_url, is_opaque);
+  ScriptOrigin origin = NewScriptOrigin(w->isolate, name_s, line_offset_s, column_offset_s, is_shared_cross_origin_s, script_id_s, is_embedder_debug_script_s, source_map_url_s, is_opaque_s);
 
   Local<Script> script = Script::Compile(source, &origin);
 
@@ -149,6 +314,72 @@ int worker_load(worker* w, char* source_s, char* name_s, int line_offset_s, int
   return 0;
 }
 
+stream* worker_stream_new(worker* w, int stream_id) {
+  Locker locker(w->isolate);
+  Isolate::Scope isolate_scope(w->isolate);
+  HandleScope handle_scope(w->isolate);
+
+  stream* s = new(stream);
+  s->source = new ScriptCompiler::StreamedSource(new GoSourceStream(stream_id), ScriptCompiler::StreamedSource::UTF8);
+  s->task = ScriptCompiler::StartStreamingScript(w->isolate, s->source);
+  return s;
+}
+
+// Parses the stream as it arrives. Must be called off the isolate thread and
+// without holding its Locker.
+void stream_run(stream* s) {
+  s->task->Run();
+}
+
+void stream_dispose(stream* s) {
+  delete s->task;
+  delete s->source;
+  delete(s);
+}
+
+// Finishes compilation of a stream once stream_run returned and runs the
+// script. source_s must be the full source read from the stream.
+// returns nonzero on error
+int worker_load_stream(worker* w, stream* s, char* source_s, char* name_s, int line_offset_s, int column_offset_s, bool is_shared_cross_origin_s, int script_id_s, bool is_embedder_debug_script_s, char* source_map_url_s, bool is_opaque_s) {
+  if (worker_is_dead(w)) {
+    w->last_exception = "worker is dead";
+    return 3;
+  }
+
+  Locker locker(w->isolate);
+  Isolate::Scope isolate_scope(w->isolate);
+  SetStackLimit(w);
+  HandleScope handle_scope(w->isolate);
+
+  Local<Context> context = Local<Context>::New(w->isolate, w->context);
+  Context::Scope context_scope(context);
+
+  TryCatch try_catch;
+  EntryScope entry_scope(w, &try_catch);
+
+  Local<String> source = String::NewFromUtf8(w->isolate, source_s);
+  ScriptOrigin origin = NewScriptOrigin(w->isolate, name_s, line_offset_s, column_offset_s, is_shared_cross_origin_s, script_id_s, is_embedder_debug_script_s, source_map_url_s, is_opaque_s);
+
+  MaybeLocal<Script> maybe_script = ScriptCompiler::Compile(context, s->source, source, origin);
+
+  Local<Script> script;
+  if (!maybe_script.ToLocal(&script)) {
+    assert(try_catch.HasCaught());
+    w->last_exception = ExceptionString(w->isolate, &try_catch);
+    return 1;
+  }
+
+  Handle<Value> result = script->Run();
+
+  if (result.IsEmpty()) {
+    assert(try_catch.HasCaught());
+    w->last_exception = ExceptionString(w->isolate, &try_catch);
+    return 2;
+  }
+
+  return 0;
+}
+
 void worker_low_memory_notification(worker* w) {
   Locker locker(w->isolate);
   w->isolate->LowMemoryNotification();
@@ -210,9 +441,11 @@ void RecvSync(const FunctionCallbackInfo<Value>& args) {
   w->recv_sync_handler.Reset(isolate, func);
 }
 
-// Called from javascript. Must route message to golang.
+// Called from javascript. Must route message to golang. Typed arrays are sent
+// as bytes, see Worker.OnValue, and other values as JSON, see Worker.OnJSON.
 void Send(const FunctionCallbackInfo<Value>& args) {
   std::string msg;
+  MessageKind kind = kMessageString;
   worker* w = NULL;
   {
     Isolate* isolate = args.GetIsolate();
@@ -226,14 +459,43 @@ void Send(const FunctionCallbackInfo<Value>& args) {
     Context::Scope context_scope(context);
 
     Local<Value> v = args[0];
-    assert(v->IsString());
+    if (v->IsArrayBufferView()) {
+      CopyBytes(v.As<ArrayBufferView>(), &msg);
+      kind = kMessageBytes;
+    } else if (!v->IsString()) {
+      Local<String> json;
+      if (!JSONStringify(isolate, context, v).ToLocal(&json)) {
+        // the exception of stringify propagates
+        return;
+      }
+      v = json;
+      kind = kMessageJSON;
+    }
 
-    String::Utf8Value str(v);
-    msg = ToCString(str);
+    if (kind != kMessageBytes) {
+      String::Utf8Value str(v);
+      msg = ToCString(str);
+    }
   }
 
   // XXX should we use Unlocker?
-  recvCb((char*)msg.c_str(), w->id);
+  char* err = NULL;
+  switch (kind) {
+    case kMessageString:
+      recvCb((char*)msg.c_str(), w->id);
+      return;
+    case kMessageJSON:
+      err = recvJSONCb((char*)msg.c_str(), w->id);
+      break;
+    case kMessageBytes:
+      err = recvBytesCb((char*)msg.data(), msg.size(), w->id);
+      break;
+  }
+  if (err != NULL) {
+    Isolate* isolate = args.GetIsolate();
+    isolate->ThrowException(Exception::TypeError(String::NewFromUtf8(isolate, err)));
+    free(err);
+  }
 }
 
 // Called from javascript using $request.
@@ -264,17 +526,110 @@ void SendSync(const FunctionCallbackInfo<Value>& args) {
   free(returnMsg);
 }
 
-// Called from golang. Must route message to javascript lang.
-// non-zero return value indicates error. check worker_last_exception().
-int worker_send(worker* w, const char* msg) {
+// Called from javascript as $requireResolve(specifier[, parent]). Resolves the
+// filename of a CommonJS module in golang. Without parent the specifier is
+// resolved relative to the calling script.
+void RequireResolve(const FunctionCallbackInfo<Value>& args) {
+  Isolate* isolate = args.GetIsolate();
+  worker* w = static_cast<worker*>(isolate->GetData(0));
+  assert(w->isolate == isolate);
+
+  HandleScope handle_scope(isolate);
+
+  String::Utf8Value specifier(args[0]);
+  std::string parent;
+  if (args.Length() > 1 && args[1]->IsString()) {
+    String::Utf8Value parent_v(args[1]);
+    parent = ToCString(parent_v);
+  } else {
+    // The innermost frames are require and require.resolve of the harness,
+    // the first frame of another script is the caller.
+    Local<StackTrace> trace = StackTrace::CurrentStackTrace(isolate, 16, StackTrace::kScriptName);
+    for (int i = 0; i < trace->GetFrameCount(); i++) {
+      String::Utf8Value parent_v(trace->GetFrame(i)->GetScriptName());
+      if (strcmp(ToCString(parent_v), "v8worker:require.js") != 0) {
+        parent = ToCString(parent_v);
+        break;
+      }
+    }
+  }
+
+  char* result = NULL;
+  int r = requireResolveCb(w->id, (char*)ToCString(specifier), (char*)parent.c_str(), &result);
+  Local<String> result_v = String::NewFromUtf8(isolate, result);
+  free(result);
+  if (r != 0) {
+    isolate->ThrowException(Exception::Error(result_v));
+    return;
+  }
+  args.GetReturnValue().Set(result_v);
+}
+
+// Called from javascript as $requireLoad(filename). Returns the parsed value of
+// a .json file, or the CommonJS wrapper function of any other module compiled
+// with filename as its script origin.
+void RequireLoad(const FunctionCallbackInfo<Value>& args) {
+  Isolate* isolate = args.GetIsolate();
+  worker* w = static_cast<worker*>(isolate->GetData(0));
+  assert(w->isolate == isolate);
+
+  HandleScope handle_scope(isolate);
+  Local<Context> context = isolate->GetCurrentContext();
+
+  String::Utf8Value filename_v(args[0]);
+  std::string filename = ToCString(filename_v);
+
+  char* result = NULL;
+  int r = requireReadCb(w->id, (char*)filename.c_str(), &result);
+  Local<String> result_v = String::NewFromUtf8(isolate, result);
+  free(result);
+  if (r != 0) {
+    isolate->ThrowException(Exception::Error(result_v));
+    return;
+  }
+
+  std::string ext = ".json";
+  if (filename.size() >= ext.size() && filename.compare(filename.size() - ext.size(), ext.size(), ext) == 0) {
+    Local<Value> value;
+    if (JSON::Parse(isolate, result_v).ToLocal(&value)) {
+      args.GetReturnValue().Set(value);
+    }
+    return;
+  }
+
+  // The wrapper takes its own line so line numbers of the module are kept.
+  Local<String> source = String::Concat(
+      String::NewFromUtf8(isolate, "(function (exports, require, module, __filename, __dirname) {\n"),
+      String::Concat(result_v, String::NewFromUtf8(isolate, "\n})")));
+  ScriptOrigin origin(String::NewFromUtf8(isolate, filename.c_str()), Integer::New(isolate, -1));
+
+  Local<Script> script;
+  if (!Script::Compile(context, source, &origin).ToLocal(&script)) {
+    return;
+  }
+  Local<Value> fn;
+  if (script->Run(context).ToLocal(&fn)) {
+    args.GetReturnValue().Set(fn);
+  }
+}
+
+// Calls the $recv callback with the message msg of len bytes, see MessageValue.
+int WorkerSend(worker* w, const char* msg, int len, MessageKind kind) {
+  if (worker_is_dead(w)) {
+    w->last_exception = "worker is dead";
+    return 3;
+  }
+
   Locker locker(w->isolate);
   Isolate::Scope isolate_scope(w->isolate);
+  SetStackLimit(w);
   HandleScope handle_scope(w->isolate);
 
   Local<Context> context = Local<Context>::New(w->isolate, w->context);
   Context::Scope context_scope(context);
 
   TryCatch try_catch;
+  EntryScope entry_scope(w, &try_catch);
 
   Local<Function> recv = Local<Function>::New(w->isolate, w->recv);
   if (recv.IsEmpty()) {
@@ -283,7 +638,10 @@ int worker_send(worker* w, const char* msg) {
   }
 
   Local<Value> args[1];
-  args[0] = String::NewFromUtf8(w->isolate, msg);
+  if (!MessageValue(w->isolate, msg, len, kind).ToLocal(&args[0])) {
+    w->last_exception = ExceptionString(w->isolate, &try_catch);
+    return 2;
+  }
 
   assert(!try_catch.HasCaught());
 
@@ -298,34 +656,123 @@ int worker_send(worker* w, const char* msg) {
 }
 
 // Called from golang. Must route message to javascript lang.
-// It will call the $recv_sync_handler callback function and return its string value.
-const char* worker_send_sync(worker* w, const char* msg) {
+// non-zero return value indicates error. check worker_last_exception().
+int worker_send(worker* w, const char* msg) {
+  return WorkerSend(w, msg, strlen(msg), kMessageString);
+}
+
+// Like worker_send, but $recv gets the value of the JSON text msg.
+int worker_send_json(worker* w, const char* msg) {
+  return WorkerSend(w, msg, strlen(msg), kMessageJSON);
+}
+
+// Like worker_send, but $recv gets an Uint8Array of the len bytes of data.
+int worker_send_bytes(worker* w, const char* data, int len) {
+  return WorkerSend(w, data, len, kMessageBytes);
+}
+
+// Calls the $recvSync callback with the message msg of len bytes, see
+// MessageValue, and returns its result: a string, JSON text or the bytes of a
+// typed array, whose length is stored in out_len. Errors start with "err: ",
+// out_len is -1 for them.
+const char* WorkerSendSync(worker* w, const char* msg, int len, MessageKind kind, int* out_len) {
+  *out_len = -1;
+  if (worker_is_dead(w)) {
+    return "err: worker is dead";
+  }
+
   Locker locker(w->isolate);
   Isolate::Scope isolate_scope(w->isolate);
+  SetStackLimit(w);
   HandleScope handle_scope(w->isolate);
 
   Local<Context> context = Local<Context>::New(w->isolate, w->context);
   Context::Scope context_scope(context);
 
+  TryCatch try_catch;
+  EntryScope entry_scope(w, &try_catch);
+
   Local<Function> recv_sync_handler = Local<Function>::New(w->isolate, w->recv_sync_handler);
   if (recv_sync_handler.IsEmpty()) {
     return "err: $recvSync not called";
   }
 
   Local<Value> args[1];
-  args[0] = String::NewFromUtf8(w->isolate, msg);
+  if (!MessageValue(w->isolate, msg, len, kind).ToLocal(&args[0])) {
+    w->sync_response = "err: " + ExceptionString(w->isolate, &try_catch);
+    return w->sync_response.c_str();
+  }
   Local<Value> response_value = recv_sync_handler->Call(context->Global(), 1, args);
 
+  if (!try_catch.HasCaught() && kind == kMessageJSON) {
+    Local<String> json;
+    if (JSONStringify(w->isolate, context, response_value).ToLocal(&json)) {
+      response_value = json;
+    }
+  }
+
+  if (try_catch.HasCaught()) {
+    w->sync_response = "err: " + ExceptionString(w->isolate, &try_catch);
+    return w->sync_response.c_str();
+  }
+
+  if (kind == kMessageBytes) {
+    if (!response_value->IsArrayBufferView()) {
+      return "err: return value is not a typed array";
+    }
+    CopyBytes(response_value.As<ArrayBufferView>(), &w->sync_response);
+    *out_len = w->sync_response.size();
+    return w->sync_response.data();
+  }
+
   if (response_value->IsString()) {
     String::Utf8Value response(response_value->ToString());
-    std::string out;
-    out.append(*response);
-    return out.c_str();
+    w->sync_response = *response;
+    *out_len = w->sync_response.size();
+    return w->sync_response.c_str();
   }
 
   return "err: non-string return value";
 }
 
+// Called from golang. Must route message to javascript lang.
+// It will call the $recv_sync_handler callback function and return its string value.
+const char* worker_send_sync(worker* w, const char* msg) {
+  int out_len;
+  return WorkerSendSync(w, msg, strlen(msg), kMessageString, &out_len);
+}
+
+// Like worker_send_sync, but $recvSync gets the value of the JSON text msg and
+// its result is returned as JSON.
+const char* worker_send_sync_json(worker* w, const char* msg) {
+  int out_len;
+  return WorkerSendSync(w, msg, strlen(msg), kMessageJSON, &out_len);
+}
+
+// Like worker_send_sync, but $recvSync gets an Uint8Array of the len bytes of
+// data and must return a typed array. The length of the result is stored in
+// out_len, which is -1 if the result is an error starting with "err: ".
+const char* worker_send_sync_bytes(worker* w, const char* data, int len, int* out_len) {
+  return WorkerSendSync(w, data, len, kMessageBytes, out_len);
+}
+
+// Called by V8 on fatal errors, including running out of memory: V8 5.0 has
+// no separate OOM handler to install. V8 aborts the process when this returns,
+// except for API misuse after which the isolate is dead but usable for
+// reporting.
+void FatalErrorHandler(const char* location, const char* message) {
+  Isolate* isolate = Isolate::GetCurrent();
+  if (isolate == NULL) {
+    return;
+  }
+  worker* w = static_cast<worker*>(isolate->GetData(0));
+  w->dead = true;
+
+  heap_statistics hs;
+  worker_get_heap_statistics(w, &hs);
+  fatalCb((char*)(location ? location : ""), (char*)(message ? message : ""), &hs, w->id);
+}
+
 void v8_init() {
   V8::InitializeICU();
   Platform* platform = platform::CreateDefaultPlatform();
@@ -333,11 +780,18 @@ void v8_init() {
   V8::Initialize();
 }
 
-worker* worker_new(int worker_id) {
+void v8_set_flags(const char* flags) {
+  V8::SetFlagsFromString(flags, strlen(flags));
+}
+
+worker* worker_new(int worker_id, int stack_size, int max_old_space_mb) {
   worker* w = new(worker);
 
   Isolate::CreateParams create_params;
   create_params.array_buffer_allocator = &w->allocator;
+  if (max_old_space_mb > 0) {
+    create_params.constraints.set_max_old_space_size(max_old_space_mb);
+  }
   Isolate* isolate = Isolate::New(create_params);
   Locker locker(isolate);
   Isolate::Scope isolate_scope(isolate);
@@ -346,7 +800,12 @@ worker* worker_new(int worker_id) {
   w->isolate = isolate;
   w->isolate->SetCaptureStackTraceForUncaughtExceptions(true);
   w->isolate->SetData(0, w);
+  w->isolate->SetFatalErrorHandler(FatalErrorHandler);
   w->id = worker_id;
+  w->dead = false;
+  w->stack_size = stack_size;
+  w->depth = 0;
+  w->last_terminated = false;
 
   Local<ObjectTemplate> global = ObjectTemplate::New(w->isolate);
 
@@ -365,6 +824,12 @@ worker* worker_new(int worker_id) {
   global->Set(String::NewFromUtf8(w->isolate, "$recvSync"),
               FunctionTemplate::New(w->isolate, RecvSync));
 
+  global->Set(String::NewFromUtf8(w->isolate, "$requireResolve"),
+              FunctionTemplate::New(w->isolate, RequireResolve));
+
+  global->Set(String::NewFromUtf8(w->isolate, "$requireLoad"),
+              FunctionTemplate::New(w->isolate, RequireLoad));
+
   Local<Context> context = Context::New(w->isolate, NULL, global);
   w->context.Reset(w->isolate, context);
   //context->Enter();
@@ -381,6 +846,22 @@ void worker_terminate_execution(worker* w) {
   w->isolate->TerminateExecution();
 }
 
+bool worker_is_execution_terminating(worker* w) {
+  return w->isolate->IsExecutionTerminating();
+}
+
+void worker_cancel_terminate_execution(worker* w) {
+  w->isolate->CancelTerminateExecution();
+}
+
+bool worker_last_terminated(worker* w) {
+  return w->last_terminated;
+}
+
+bool worker_is_locked(worker* w) {
+  return Locker::IsLocked(w->isolate);
+}
+
 void worker_get_heap_statistics(worker* w, heap_statistics* hs) {
   HeapStatistics heap_statistics;
   w->isolate->GetHeapStatistics(&heap_statistics);
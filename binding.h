@@ -1,3 +1,6 @@
+#ifndef V8WORKER_BINDING_H
+#define V8WORKER_BINDING_H
+
 #ifdef __cplusplus
 extern "C" {
 #endif
@@ -19,23 +22,44 @@ typedef struct heap_statistics_s heap_statistics;
 struct worker_s;
 typedef struct worker_s worker;
 
+struct stream_s;
+typedef struct stream_s stream;
+
 const char* worker_version();
 
 void v8_init();
+void v8_set_flags(const char* flags);
 
-worker* worker_new(int worker_id);
+worker* worker_new(int worker_id, int stack_size, int max_old_space_mb);
 
 // returns nonzero on error
 // get error from worker_last_exception
 int worker_load(worker* w, char* source_s, char* name_s, int line_offset_s, int column_offset_s, bool is_shared_cross_origin_s, int script_id_s, bool is_embedder_debug_script_s, char* source_map_url_s, bool is_opaque_s);
 
+stream* worker_stream_new(worker* w, int stream_id);
+void stream_run(stream* s);
+void stream_dispose(stream* s);
+// returns nonzero on error
+int worker_load_stream(worker* w, stream* s, char* source_s, char* name_s, int line_offset_s, int column_offset_s, bool is_shared_cross_origin_s, int script_id_s, bool is_embedder_debug_script_s, char* source_map_url_s, bool is_opaque_s);
+
 const char* worker_last_exception(worker* w);
+bool worker_is_dead(worker* w);
 
 int worker_send(worker* w, const char* msg);
 const char* worker_send_sync(worker* w, const char* msg);
+int worker_send_json(worker* w, const char* msg);
+const char* worker_send_sync_json(worker* w, const char* msg);
+int worker_send_bytes(worker* w, const char* data, int len);
+const char* worker_send_sync_bytes(worker* w, const char* data, int len, int* out_len);
 
 void worker_dispose(worker* w);
 void worker_terminate_execution(worker* w);
+bool worker_is_execution_terminating(worker* w);
+void worker_cancel_terminate_execution(worker* w);
+bool worker_last_terminated(worker* w);
+// reports whether the calling thread holds the isolate Locker, i.e. is inside
+// a callback of the worker
+bool worker_is_locked(worker* w);
 void worker_low_memory_notification(worker* w);
 bool worker_idle_notification_deadline(worker* w, double deadline_in_seconds);
 void worker_get_heap_statistics(worker* w, heap_statistics* hs);
@@ -43,3 +67,5 @@ void worker_get_heap_statistics(worker* w, heap_statistics* hs);
 #ifdef __cplusplus
 } // extern "C"
 #endif
+
+#endif // V8WORKER_BINDING_H
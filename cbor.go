@@ -0,0 +1,328 @@
+package v8worker
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// cborCodec implements CBOR, see RFC 8949. Tags are skipped when decoding.
+type cborCodec struct{}
+
+func (cborCodec) Name() string { return "cbor" }
+
+func (c cborCodec) Marshal(v interface{}) ([]byte, error) { return marshalBinary(c, v) }
+
+func (c cborCodec) Unmarshal(data []byte, v interface{}) error { return unmarshalBinary(c, data, v) }
+
+// appendCBORHead appends the initial bytes of an item of major type major with
+// argument n.
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), n)
+}
+
+func (cborCodec) appendNil(b []byte) []byte { return append(b, 0xf6) }
+
+func (cborCodec) appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xf5)
+	}
+	return append(b, 0xf4)
+}
+
+func (cborCodec) appendInt(b []byte, i int64) []byte {
+	if i < 0 {
+		return appendCBORHead(b, 1, uint64(-1-i))
+	}
+	return appendCBORHead(b, 0, uint64(i))
+}
+
+func (cborCodec) appendUint(b []byte, u uint64) []byte { return appendCBORHead(b, 0, u) }
+
+func (cborCodec) appendFloat(b []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(f))
+}
+
+func (cborCodec) appendString(b []byte, s string) []byte {
+	return append(appendCBORHead(b, 3, uint64(len(s))), s...)
+}
+
+func (cborCodec) appendBytes(b []byte, s []byte) []byte {
+	return append(appendCBORHead(b, 2, uint64(len(s))), s...)
+}
+
+func (cborCodec) appendArrayHead(b []byte, n int) []byte { return appendCBORHead(b, 4, uint64(n)) }
+
+func (cborCodec) appendMapHead(b []byte, n int) []byte { return appendCBORHead(b, 5, uint64(n)) }
+
+func (c cborCodec) readItem(d *byteReader) (item, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return item{}, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return item{kind: itemBool, b: false}, nil
+		case 21:
+			return item{kind: itemBool, b: true}, nil
+		case 22, 23: // null, undefined
+			return item{kind: itemNil}, nil
+		case 25:
+			h, err := d.uint(2)
+			return item{kind: itemFloat, f: float16(uint16(h))}, err
+		case 26:
+			f, err := d.uint(4)
+			return item{kind: itemFloat, f: float64(math.Float32frombits(uint32(f)))}, err
+		case 27:
+			f, err := d.uint(8)
+			return item{kind: itemFloat, f: math.Float64frombits(f)}, err
+		case 31:
+			return item{kind: itemBreak}, nil
+		}
+		d.pos--
+		return item{}, d.errorf("unsupported simple value " + strconv.Itoa(int(info)))
+	}
+
+	var n uint64
+	indefinite := false
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		if n, err = d.uint(1 << (info - 24)); err != nil {
+			return item{}, err
+		}
+	case info == 31 && major >= 2 && major <= 5:
+		indefinite = true
+	default:
+		d.pos--
+		return item{}, d.errorf("invalid additional information " + strconv.Itoa(int(info)))
+	}
+
+	switch major {
+	case 0:
+		return item{kind: itemUint, u: n}, nil
+	case 1:
+		if n > math.MaxInt64 {
+			return item{kind: itemFloat, f: -1 - float64(n)}, nil
+		}
+		return item{kind: itemInt, i: -1 - int64(n)}, nil
+	case 2, 3:
+		kind := itemBytes
+		if major == 3 {
+			kind = itemString
+		}
+		if !indefinite {
+			return d.str(kind, n)
+		}
+		s := []byte{}
+		for {
+			chunk, err := c.readItem(d)
+			if err != nil {
+				return item{}, err
+			}
+			if chunk.kind == itemBreak {
+				break
+			}
+			if chunk.kind != itemBytes && chunk.kind != itemString {
+				return item{}, d.errorf("invalid indefinite length string chunk")
+			}
+			s = append(s, chunk.s...)
+		}
+		return item{kind: kind, s: s}, nil
+	case 4, 5:
+		kind := itemArray
+		if major == 5 {
+			kind = itemMap
+		}
+		if indefinite {
+			return item{kind: kind, n: -1}, nil
+		}
+		return d.head(kind, n)
+	}
+	// tags only annotate the item following them
+	return c.readItem(d)
+}
+
+// float16 converts an IEEE 754 half precision float.
+func float16(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp, frac := int(h>>10&0x1f), float64(h&0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 31:
+		if frac != 0 {
+			return math.NaN()
+		}
+		return math.Inf(int(sign))
+	}
+	return sign * math.Ldexp(1024+frac, exp-25)
+}
+
+func (cborCodec) Script() string {
+	return `(function () {
+		function head(w, major, n) {
+			major <<= 5;
+			if (n < 24) return w.u8(major | n);
+			if (n < 0x100) { w.u8(major | 24); return w.u8(n); }
+			if (n < 0x10000) { w.u8(major | 25); return w.u16(n); }
+			if (n < 0x100000000) { w.u8(major | 26); return w.u32(n); }
+			w.u8(major | 27); w.u32(Math.floor(n / 0x100000000)); w.u32(n >>> 0);
+		}
+
+		function encode(w, v) {
+			if (v === null || v === undefined) return w.u8(0xf6);
+			switch (typeof v) {
+			case "boolean":
+				return w.u8(v ? 0xf5 : 0xf4);
+			case "number":
+				if (Math.floor(v) === v && Math.abs(v) <= 9007199254740991) {
+					return v >= 0 ? head(w, 0, v) : head(w, 1, -1 - v);
+				}
+				w.u8(0xfb);
+				return w.f64(v);
+			case "string":
+				var s = utf8Encode(v);
+				head(w, 3, s.length);
+				return w.bytes(s);
+			case "object":
+				if (v instanceof Uint8Array) {
+					head(w, 2, v.length);
+					return w.bytes(v);
+				}
+				if (typeof v.toJSON === "function") return encode(w, v.toJSON());
+				if (Array.isArray(v)) {
+					head(w, 4, v.length);
+					for (var i = 0; i < v.length; i++) encode(w, v[i]);
+					return;
+				}
+				var keys = Object.keys(v).filter(function (k) {
+					return v[k] !== undefined && typeof v[k] !== "function";
+				});
+				head(w, 5, keys.length);
+				keys.forEach(function (k) {
+					encode(w, k);
+					encode(w, v[k]);
+				});
+				return;
+			}
+			w.u8(0xf6);
+		}
+
+		function half(h) {
+			var sign = h & 0x8000 ? -1 : 1, exp = h >> 10 & 0x1f, frac = h & 0x3ff;
+			if (exp === 0) return sign * frac * Math.pow(2, -24);
+			if (exp === 31) return frac ? NaN : sign * Infinity;
+			return sign * (1024 + frac) * Math.pow(2, exp - 25);
+		}
+
+		var BREAK = {};
+
+		function concat(chunks) {
+			var n = 0;
+			chunks.forEach(function (c) { n += c.length; });
+			var b = new Uint8Array(n);
+			n = 0;
+			chunks.forEach(function (c) { b.set(c, n); n += c.length; });
+			return b;
+		}
+
+		function decode(r) {
+			var c = r.u8(), major = c >> 5, info = c & 0x1f;
+			if (major === 7) {
+				switch (info) {
+				case 20: return false;
+				case 21: return true;
+				case 22: return null;
+				case 23: return undefined;
+				case 25: return half(r.u16());
+				case 26: return r.f32();
+				case 27: return r.f64();
+				case 31: return BREAK;
+				}
+				throw new Error("cbor: unsupported simple value " + info);
+			}
+			var n, indefinite = false;
+			if (info < 24) n = info;
+			else if (info === 24) n = r.u8();
+			else if (info === 25) n = r.u16();
+			else if (info === 26) n = r.u32();
+			else if (info === 27) n = r.u32() * 0x100000000 + r.u32();
+			else if (info === 31 && major >= 2 && major <= 5) indefinite = true;
+			else throw new Error("cbor: invalid additional information " + info);
+
+			switch (major) {
+			case 0:
+				return n;
+			case 1:
+				return -1 - n;
+			case 2:
+			case 3:
+				var bytes;
+				if (indefinite) {
+					var chunks = [];
+					for (var chunk = decode(r); chunk !== BREAK; chunk = decode(r)) {
+						chunks.push(typeof chunk === "string" ? utf8Encode(chunk) : chunk);
+					}
+					bytes = concat(chunks);
+				} else {
+					bytes = new Uint8Array(r.bytes(n));
+				}
+				return major === 2 ? bytes : utf8Decode(bytes);
+			case 4:
+				var a = [];
+				for (var i = 0; indefinite || i < n; i++) {
+					var e = decode(r);
+					if (e === BREAK && indefinite) break;
+					if (e === BREAK) throw new Error("cbor: unexpected break");
+					a.push(e);
+				}
+				return a;
+			case 5:
+				var m = {};
+				for (var j = 0; indefinite || j < n; j++) {
+					var k = decode(r);
+					if (k === BREAK && indefinite) break;
+					if (k === BREAK) throw new Error("cbor: unexpected break");
+					m[k] = decode(r);
+				}
+				return m;
+			}
+			// tags only annotate the item following them
+			return decode(r);
+		}
+
+		return {
+			encode: function (v) {
+				var w = new Writer();
+				encode(w, v);
+				return w.result();
+			},
+			decode: function (b) {
+				var r = new Reader(b);
+				var v = decode(r);
+				if (v === BREAK) throw new Error("cbor: unexpected break");
+				if (r.pos !== b.length) throw new Error("cbor: trailing data");
+				return v;
+			}
+		};
+	})()`
+}
@@ -0,0 +1,273 @@
+package v8worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrClientClosed is returned by Client calls after the connection is closed.
+var ErrClientClosed = errors.New("v8worker: client closed")
+
+// Client is a connection to a worker served by ServeWorker. It is safe for
+// concurrent use; requests are multiplexed over the connection.
+type Client struct {
+	conn net.Conn
+	out  *frameWriter
+
+	pendingLocker sync.Mutex
+	requestId     uint32
+	pending       map[uint32]chan frame
+
+	subsLocker sync.RWMutex
+	subId      int
+	subs       map[int]ReceiveMessageCallback
+	syncCB     ReceiveSyncMessageCallback
+
+	// messages received for the subscribers, see dispatchLoop
+	recvLocker sync.Mutex
+	recvCond   *sync.Cond
+	recvQueue  []string
+	recvClosed bool
+
+	done chan struct{}
+}
+
+// Dial connects to a worker served on the tcp address addr.
+func Dial(addr string) (*Client, error) {
+	return DialToken(addr, "")
+}
+
+// DialToken connects to a worker served on the tcp address addr and
+// authenticates with token.
+func DialToken(addr string, token string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(conn, token)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient creates a client on an established connection, e.g. one end of a
+// net.Pipe. token may be empty if the server doesn't require one.
+func NewClient(conn net.Conn, token string) (*Client, error) {
+	r := bufio.NewReader(conn)
+	c := &Client{
+		conn:    conn,
+		out:     &frameWriter{w: bufio.NewWriter(conn)},
+		pending: make(map[uint32]chan frame),
+		subs:    make(map[int]ReceiveMessageCallback),
+		done:    make(chan struct{}),
+	}
+	c.recvCond = sync.NewCond(&c.recvLocker)
+	if token != "" {
+		if err := c.out.write(frame{kind: frameAuth, payload: []byte(token)}); err != nil {
+			return nil, err
+		}
+		f, err := readFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		if f.kind != frameResult {
+			return nil, ErrUnauthorized
+		}
+	}
+	go c.readLoop(r)
+	go c.dispatchLoop()
+	return c, nil
+}
+
+// Subscribe registers cb to be called with every message the worker sends with
+// $send. Callbacks are called in order on a goroutine of their own, so they may
+// make requests on the client. Call the returned function to unsubscribe.
+func (c *Client) Subscribe(cb ReceiveMessageCallback) func() {
+	c.subsLocker.Lock()
+	id := c.subId
+	c.subId++
+	c.subs[id] = cb
+	c.subsLocker.Unlock()
+	return func() {
+		c.subsLocker.Lock()
+		delete(c.subs, id)
+		c.subsLocker.Unlock()
+	}
+}
+
+// HandleSync sets the callback answering $sendSync calls of the worker.
+func (c *Client) HandleSync(syncCB ReceiveSyncMessageCallback) {
+	c.subsLocker.Lock()
+	c.syncCB = syncCB
+	c.subsLocker.Unlock()
+}
+
+// Load loads and executes a javascript file with the filename specified by
+// scriptName and the contents of the file specified by the param code.
+func (c *Client) Load(scriptName string, code string) error {
+	return c.LoadWithOptions(&ScriptOrigin{ScriptName: scriptName}, code)
+}
+
+// LoadWithOptions loads and executes a javascript file with the ScriptOrigin specified by
+// origin and the contents of the file specified by the param code.
+func (c *Client) LoadWithOptions(origin *ScriptOrigin, code string) error {
+	payload, err := json.Marshal(loadRequest{Origin: origin, Code: code})
+	if err != nil {
+		return err
+	}
+	_, err = c.call(frameLoad, payload)
+	return err
+}
+
+// Send sends a message to the worker. The $recv callback in js will be called.
+func (c *Client) Send(msg string) error {
+	_, err := c.call(frameSend, []byte(msg))
+	return err
+}
+
+// SendSync sends a message to the worker. The $recvSync callback in js will be called.
+// Connection errors are returned as a string starting with "err: ".
+func (c *Client) SendSync(msg string) string {
+	res, err := c.call(frameSendSync, []byte(msg))
+	if err != nil {
+		return "err: " + err.Error()
+	}
+	return string(res)
+}
+
+// TerminateExecution terminates execution of javascript in the served worker.
+func (c *Client) TerminateExecution() {
+	c.out.write(frame{kind: frameTerminate})
+}
+
+// GetHeapStatistics returns statistics about the V8 isolate heap memory usage
+// of the served worker, or nil on connection errors.
+func (c *Client) GetHeapStatistics() *HeapStatistics {
+	res, err := c.call(frameHeapStatistics, nil)
+	if err != nil {
+		return nil
+	}
+	hs := new(HeapStatistics)
+	if err := json.Unmarshal(res, hs); err != nil {
+		return nil
+	}
+	return hs
+}
+
+// Close closes the connection. Requests in flight return ErrClientClosed.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+func (c *Client) call(kind byte, payload []byte) ([]byte, error) {
+	ch := make(chan frame, 1)
+	c.pendingLocker.Lock()
+	if c.pending == nil {
+		c.pendingLocker.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.requestId++
+	id := c.requestId
+	c.pending[id] = ch
+	c.pendingLocker.Unlock()
+
+	if err := c.out.write(frame{kind: kind, id: id, payload: payload}); err != nil {
+		c.pendingLocker.Lock()
+		if c.pending != nil {
+			delete(c.pending, id)
+		}
+		c.pendingLocker.Unlock()
+		return nil, err
+	}
+	f, ok := <-ch
+	if !ok {
+		return nil, ErrClientClosed
+	}
+	if f.kind == frameError {
+		return nil, errors.New(string(f.payload))
+	}
+	return f.payload, nil
+}
+
+func (c *Client) readLoop(r *bufio.Reader) {
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			break
+		}
+		switch f.kind {
+		case frameRecv:
+			c.recvLocker.Lock()
+			c.recvQueue = append(c.recvQueue, string(f.payload))
+			c.recvLocker.Unlock()
+			c.recvCond.Signal()
+		case frameRecvSync:
+			c.subsLocker.RLock()
+			syncCB := c.syncCB
+			c.subsLocker.RUnlock()
+			go func(f frame) {
+				var res string
+				if syncCB != nil {
+					res = syncCB(string(f.payload))
+				}
+				c.out.write(frame{kind: frameResult, id: f.id, payload: []byte(res)})
+			}(f)
+		case frameResult, frameError:
+			c.pendingLocker.Lock()
+			ch := c.pending[f.id]
+			delete(c.pending, f.id)
+			c.pendingLocker.Unlock()
+			if ch != nil {
+				ch <- f
+			}
+		}
+	}
+
+	c.pendingLocker.Lock()
+	for _, ch := range c.pending {
+		close(ch)
+	}
+	c.pending = nil
+	c.pendingLocker.Unlock()
+	c.recvLocker.Lock()
+	c.recvClosed = true
+	c.recvLocker.Unlock()
+	c.recvCond.Signal()
+	close(c.done)
+}
+
+// dispatchLoop calls the subscribers with the messages queued by readLoop, so
+// that a callback waiting on a reply doesn't block reading it. Messages still
+// queued when the connection closes are delivered.
+func (c *Client) dispatchLoop() {
+	for {
+		c.recvLocker.Lock()
+		for len(c.recvQueue) == 0 && !c.recvClosed {
+			c.recvCond.Wait()
+		}
+		if len(c.recvQueue) == 0 {
+			c.recvLocker.Unlock()
+			return
+		}
+		msg := c.recvQueue[0]
+		c.recvQueue = c.recvQueue[1:]
+		c.recvLocker.Unlock()
+
+		c.subsLocker.RLock()
+		subs := make([]ReceiveMessageCallback, 0, len(c.subs))
+		for _, cb := range c.subs {
+			subs = append(subs, cb)
+		}
+		c.subsLocker.RUnlock()
+		for _, cb := range subs {
+			cb(msg)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor reads lines with basic emacs-style editing and history when the
+// input is a terminal, and plain lines otherwise.
+type lineEditor struct {
+	in          *os.File
+	r           *bufio.Reader
+	out         io.Writer
+	historyFile string
+	history     []string
+}
+
+func newLineEditor(in *os.File, out io.Writer, historyFile string) *lineEditor {
+	e := &lineEditor{
+		in:          in,
+		r:           bufio.NewReader(in),
+		out:         out,
+		historyFile: historyFile,
+	}
+	if data, err := os.ReadFile(historyFile); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				e.history = append(e.history, strings.Replace(line, `\n`, "\n", -1))
+			}
+		}
+	}
+	return e
+}
+
+// AddHistory appends line to the history unless it repeats the last entry.
+func (e *lineEditor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+// Close saves the history.
+func (e *lineEditor) Close() error {
+	history := e.history
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	var b strings.Builder
+	for _, line := range history {
+		b.WriteString(strings.Replace(line, "\n", `\n`, -1))
+		b.WriteString("\n")
+	}
+	return os.WriteFile(e.historyFile, []byte(b.String()), 0600)
+}
+
+// ReadLine prints prompt and reads a line. It returns errInterrupted on
+// Ctrl-C and io.EOF on Ctrl-D or at the end of the input.
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	restore, err := makeRaw(e.in.Fd())
+	if err != nil {
+		fmt.Fprint(e.out, prompt)
+		line, err := e.r.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	defer restore()
+
+	var buf []rune
+	pos := 0
+	hist := len(e.history)
+	saved := ""
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if n := len(buf) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	recall := func(i int) {
+		if i < 0 || i > len(e.history) {
+			return
+		}
+		if hist == len(e.history) {
+			saved = string(buf)
+		}
+		hist = i
+		if i == len(e.history) {
+			buf = []rune(saved)
+		} else {
+			buf = []rune(e.history[i])
+		}
+		pos = len(buf)
+		redraw()
+	}
+
+	redraw()
+	for {
+		r, err := e.readKey()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+			fallthrough
+		case keyDelete:
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = buf[pos:]
+			pos = 0
+		case 16: // Ctrl-P
+			recall(hist - 1)
+		case 14: // Ctrl-N
+			recall(hist + 1)
+		default:
+			if r >= ' ' {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		redraw()
+	}
+}
+
+// keyDelete is returned by readKey for the Delete key.
+const keyDelete = -1
+
+// readKey reads a key, translating the escape sequences of arrow and
+// home/end keys to the equivalent emacs control keys.
+func (e *lineEditor) readKey() (rune, error) {
+	r, _, err := e.r.ReadRune()
+	if err != nil || r != 27 {
+		return r, err
+	}
+	if r, _, err = e.r.ReadRune(); err != nil {
+		return 0, err
+	}
+	if r != '[' && r != 'O' {
+		return 0, nil
+	}
+	if r, _, err = e.r.ReadRune(); err != nil {
+		return 0, err
+	}
+	switch r {
+	case 'A':
+		return 16, nil
+	case 'B':
+		return 14, nil
+	case 'C':
+		return 6, nil
+	case 'D':
+		return 2, nil
+	case 'H':
+		return 1, nil
+	case 'F':
+		return 5, nil
+	case '3':
+		if r, _, err = e.r.ReadRune(); err == nil && r == '~' {
+			return keyDelete, nil
+		}
+	}
+	return 0, err
+}
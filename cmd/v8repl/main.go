@@ -0,0 +1,185 @@
+// Command v8repl is an interactive javascript prompt running in a
+// v8worker.Worker.
+//
+//	v8repl [file.js ...]
+//
+// Files given on the command line are loaded before the prompt starts.
+// Messages sent with $send are printed; $sendSync calls are printed and
+// answered with an empty string.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/getblank/v8worker"
+)
+
+// resultPrefix marks the $send messages carrying the result of an evaluation.
+const resultPrefix = "\x00v8repl\x00"
+
+// replScript evaluates input in the global scope and sends back a printable
+// form of the result.
+const replScript = `var $v8repl = (function (global) {
+	function inspect(v, depth) {
+		if (typeof v === "string") return depth ? JSON.stringify(v) : v;
+		if (typeof v === "function") return "[Function" + (v.name ? ": " + v.name : "") + "]";
+		if (v === null || typeof v !== "object") return String(v);
+		if (v instanceof Error) return v.stack || String(v);
+		if (depth > 2) return Array.isArray(v) ? "[Array]" : "[Object]";
+		if (Array.isArray(v)) {
+			return "[ " + v.map(function (e) { return inspect(e, depth + 1); }).join(", ") + " ]";
+		}
+		var keys = Object.keys(v);
+		if (keys.length === 0) return "{}";
+		return "{ " + keys.map(function (k) { return k + ": " + inspect(v[k], depth + 1); }).join(", ") + " }";
+	}
+	return {
+		eval: function (src) {
+			var result = (0, eval)(src);
+			global._ = result;
+			$send("` + resultPrefix + `" + inspect(result, 0));
+		}
+	};
+})(this);
+`
+
+const help = `.load FILE  load and run a javascript file
+.heap       print heap statistics
+.gc         notify V8 of low memory so it collects garbage
+.help       print this help
+.exit       exit (or Ctrl-D)
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: v8repl [file.js ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	worker := v8worker.New(func(msg string) {
+		if strings.HasPrefix(msg, resultPrefix) {
+			fmt.Println(msg[len(resultPrefix):])
+			return
+		}
+		fmt.Println("$send:", msg)
+	}, func(msg string) string {
+		fmt.Println("$sendSync:", msg)
+		return ""
+	})
+	if err := worker.Load("v8repl.js", replScript); err != nil {
+		fatal(err)
+	}
+	for _, filename := range flag.Args() {
+		if err := worker.LoadFile(filename); err != nil {
+			fatal(err)
+		}
+	}
+
+	home, _ := os.UserHomeDir()
+	editor := newLineEditor(os.Stdin, os.Stdout, filepath.Join(home, ".v8repl_history"))
+	defer editor.Close()
+
+	fmt.Println("V8", v8worker.Version(), "- type .help for help")
+	var input []string
+	for {
+		prompt := "> "
+		if len(input) > 0 {
+			prompt = "... "
+		}
+		line, err := editor.ReadLine(prompt)
+		if err == errInterrupted {
+			input = nil
+			continue
+		}
+		if err == io.EOF {
+			fmt.Println()
+			return
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+		if len(input) == 0 && strings.HasPrefix(strings.TrimSpace(line), ".") {
+			editor.AddHistory(line)
+			if !command(worker, strings.TrimSpace(line)) {
+				return
+			}
+			continue
+		}
+
+		input = append(input, line)
+		src := strings.Join(input, "\n")
+		if strings.TrimSpace(src) == "" {
+			input = nil
+			continue
+		}
+		code, _ := json.Marshal(src)
+		err = worker.Load("repl", "$v8repl.eval("+string(code)+")")
+		if err != nil && incomplete(err) {
+			continue
+		}
+		editor.AddHistory(src)
+		input = nil
+		if err != nil {
+			fmt.Print(err)
+		}
+	}
+}
+
+// incomplete reports whether err is a syntax error caused by input which
+// continues on the next line.
+func incomplete(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "SyntaxError: Unexpected end of input") ||
+		strings.Contains(msg, "SyntaxError: Unterminated template literal")
+}
+
+// command runs a dot command and reports whether the REPL should go on.
+func command(worker *v8worker.Worker, line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ".load":
+		if len(fields) != 2 {
+			fmt.Println("usage: .load FILE")
+			break
+		}
+		if err := worker.LoadFile(fields[1]); err != nil {
+			fmt.Print(err)
+		}
+	case ".heap":
+		hs := worker.GetHeapStatistics()
+		fmt.Printf("total heap size:            %d\n", hs.TotalHeapSize)
+		fmt.Printf("total heap size executable: %d\n", hs.TotalHeapSizeExecutable)
+		fmt.Printf("total physical size:        %d\n", hs.TotalPhysicalSize)
+		fmt.Printf("total available size:       %d\n", hs.TotalAvailableSize)
+		fmt.Printf("used heap size:             %d\n", hs.UsedHeapSize)
+		fmt.Printf("heap size limit:            %d\n", hs.HeapSizeLimit)
+	case ".gc":
+		before := worker.GetHeapStatistics().UsedHeapSize
+		worker.LowMemoryNotification()
+		after := worker.GetHeapStatistics().UsedHeapSize
+		fmt.Printf("used heap size: %d -> %d\n", before, after)
+	case ".help":
+		fmt.Print(help)
+	case ".exit":
+		return false
+	default:
+		fmt.Println("unknown command", fields[0], "- type .help for help")
+	}
+	return true
+}
+
+func fatal(err error) {
+	fmt.Fprint(os.Stderr, err)
+	if !strings.HasSuffix(err.Error(), "\n") {
+		fmt.Fprintln(os.Stderr)
+	}
+	os.Exit(1)
+}
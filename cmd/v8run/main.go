@@ -0,0 +1,139 @@
+// Command v8run runs a javascript file in a v8worker.Worker.
+//
+//	v8run [flags] file.js [args ...]
+//
+// The arguments after the file are available to the script as $args. Messages
+// sent with $send are written to stdout, one per line: messages which are
+// valid JSON as they are, others as JSON strings. Every line read from stdin
+// is passed to the $recv callback. $sendSync calls are answered with the
+// contents of -sync-file, or with the output of -sync-cmd, a shell command
+// which gets the message on stdin.
+//
+// v8run exits with status 1 if the script throws an uncaught exception, runs
+// longer than -timeout or runs out of heap.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/getblank/v8worker"
+)
+
+var (
+	timeout   = flag.Duration("timeout", 0, "terminate each call into javascript running longer than `duration`")
+	heapLimit = flag.Int("heap-limit", 0, "limit the V8 heap to `MB` megabytes")
+	v8Flags   = flag.String("flags", "", "V8 `flags`, e.g. \"--harmony\"")
+	syncFile  = flag.String("sync-file", "", "answer $sendSync with the contents of `file`")
+	syncCmd   = flag.String("sync-cmd", "", "answer $sendSync with the output of shell `command`, which gets the message on stdin")
+	noStdin   = flag.Bool("no-stdin", false, "don't pass stdin lines to $recv")
+)
+
+// stdoutLocker keeps the lines of concurrent $send calls apart.
+var stdoutLocker sync.Mutex
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: v8run [flags] file.js [args ...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *syncFile != "" && *syncCmd != "" {
+		fmt.Fprintln(os.Stderr, "v8run: -sync-file and -sync-cmd are exclusive")
+		os.Exit(2)
+	}
+	if *v8Flags != "" {
+		v8worker.SetFlags(*v8Flags)
+	}
+
+	var syncResponse string
+	if *syncFile != "" {
+		data, err := os.ReadFile(*syncFile)
+		if err != nil {
+			fatal(err)
+		}
+		syncResponse = string(data)
+	}
+
+	worker := v8worker.NewWithConfig(send, func(msg string) string {
+		if *syncCmd == "" {
+			return syncResponse
+		}
+		return runSyncCmd(*syncCmd, msg)
+	}, &v8worker.Config{
+		Timeout:     *timeout,
+		MaxHeapSize: *heapLimit << 20,
+	})
+	worker.OnFatal(func(err *v8worker.FatalError) {
+		fatal(err)
+	})
+
+	args, _ := json.Marshal(flag.Args()[1:])
+	if err := worker.Load("v8run:args.js", "var $args = "+string(args)+";"); err != nil {
+		fatal(err)
+	}
+	if err := worker.LoadFile(flag.Arg(0)); err != nil {
+		fatal(err)
+	}
+
+	if *noStdin {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		if err := worker.Send(scanner.Text()); err != nil {
+			fatal(err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fatal(err)
+	}
+}
+
+// send writes a $send message to stdout as a JSON line.
+func send(msg string) {
+	var line bytes.Buffer
+	if json.Compact(&line, []byte(msg)) != nil {
+		line.Reset()
+		data, _ := json.Marshal(msg)
+		line.Write(data)
+	}
+	line.WriteByte('\n')
+	stdoutLocker.Lock()
+	os.Stdout.Write(line.Bytes())
+	stdoutLocker.Unlock()
+}
+
+// runSyncCmd runs command with msg on stdin and returns its output without
+// the trailing newline. Failures are answered with "err: " and the error, like
+// the exceptions of $recvSync.
+func runSyncCmd(command string, msg string) string {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stdin = strings.NewReader(msg)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "err: " + command + ": " + err.Error()
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+func fatal(err error) {
+	fmt.Fprint(os.Stderr, err)
+	if !strings.HasSuffix(err.Error(), "\n") {
+		fmt.Fprintln(os.Stderr)
+	}
+	os.Exit(1)
+}
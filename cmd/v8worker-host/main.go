@@ -0,0 +1,17 @@
+// Command v8worker-host runs a single v8worker.Worker driven over stdin and
+// stdout. It is started by v8worker.NewRemote and is not meant to be run by
+// hand.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/getblank/v8worker"
+)
+
+func main() {
+	if err := v8worker.ServeHost(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,286 @@
+package v8worker
+
+/*
+#include <stdlib.h>
+#include "binding.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Codec is a wire format for the typed values exchanged with SendValue,
+// RequestValue and OnValue. Messages are passed as bytes, which js sees as an
+// Uint8Array.
+type Codec interface {
+	// Name identifies the format, e.g. "msgpack". It is $codec.name in js.
+	Name() string
+	// Marshal encodes v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into v.
+	Unmarshal(data []byte, v interface{}) error
+	// Script returns a javascript expression evaluating to an object with
+	// encode(value) and decode(bytes) functions, bytes being an Uint8Array.
+	// It is evaluated in a scope defining utf8Encode(string),
+	// utf8Decode(bytes), and the Writer and Reader byte buffers of
+	// codecPrelude.
+	Script() string
+}
+
+// The built-in codecs. MessagePackCodec and CBORCodec convert Go values like
+// encoding/json does, honoring json struct tags, json.Marshaler and
+// encoding.TextMarshaler, except that []byte is a native byte string instead
+// of base64 text. Byte strings decode into interface{} as []byte.
+var (
+	JSONCodec        Codec = jsonCodec{}
+	MessagePackCodec Codec = msgpackCodec{}
+	CBORCodec        Codec = cborCodec{}
+)
+
+// ErrNoCodec is returned by SendValue and RequestValue on workers without a
+// codec.
+var ErrNoCodec = errors.New("v8worker: no codec set, see Worker.SetCodec")
+
+// codecPrelude defines the helpers available to Codec scripts and the js side
+// of the typed messaging: $codec, $sendValue, $recvValue and $recvSyncValue.
+// %s is replaced with the name and script of the codec.
+const codecPrelude = `(function (global) {
+	function utf8Encode(s) {
+		var w = new Writer();
+		for (var i = 0; i < s.length; i++) {
+			var c = s.charCodeAt(i);
+			if (c >= 0xd800 && c < 0xdc00 && i + 1 < s.length) {
+				var d = s.charCodeAt(i + 1);
+				if (d >= 0xdc00 && d < 0xe000) {
+					c = 0x10000 + ((c - 0xd800) << 10) + (d - 0xdc00);
+					i++;
+				}
+			}
+			if (c < 0x80) {
+				w.u8(c);
+			} else if (c < 0x800) {
+				w.u8(0xc0 | c >> 6); w.u8(0x80 | c & 0x3f);
+			} else if (c < 0x10000) {
+				w.u8(0xe0 | c >> 12); w.u8(0x80 | c >> 6 & 0x3f); w.u8(0x80 | c & 0x3f);
+			} else {
+				w.u8(0xf0 | c >> 18); w.u8(0x80 | c >> 12 & 0x3f); w.u8(0x80 | c >> 6 & 0x3f); w.u8(0x80 | c & 0x3f);
+			}
+		}
+		return w.result();
+	}
+
+	function utf8Decode(b) {
+		var s = "";
+		for (var i = 0; i < b.length;) {
+			var c = b[i++];
+			if (c >= 0xf0) {
+				c = (c & 0x07) << 18 | (b[i++] & 0x3f) << 12 | (b[i++] & 0x3f) << 6 | b[i++] & 0x3f;
+			} else if (c >= 0xe0) {
+				c = (c & 0x0f) << 12 | (b[i++] & 0x3f) << 6 | b[i++] & 0x3f;
+			} else if (c >= 0xc0) {
+				c = (c & 0x1f) << 6 | b[i++] & 0x3f;
+			}
+			if (c >= 0x10000) {
+				c -= 0x10000;
+				s += String.fromCharCode(0xd800 + (c >> 10), 0xdc00 + (c & 0x3ff));
+			} else {
+				s += String.fromCharCode(c);
+			}
+		}
+		return s;
+	}
+
+	// Writer is a growing big-endian byte buffer.
+	function Writer() {
+		this.buf = new Uint8Array(64);
+		this.view = new DataView(this.buf.buffer);
+		this.len = 0;
+	}
+	Writer.prototype.ensure = function (n) {
+		if (this.len + n > this.buf.length) {
+			var buf = new Uint8Array(Math.max(this.buf.length * 2, this.len + n));
+			buf.set(this.buf);
+			this.buf = buf;
+			this.view = new DataView(buf.buffer);
+		}
+	};
+	Writer.prototype.u8 = function (v) { this.ensure(1); this.buf[this.len++] = v; };
+	Writer.prototype.u16 = function (v) { this.ensure(2); this.view.setUint16(this.len, v); this.len += 2; };
+	Writer.prototype.u32 = function (v) { this.ensure(4); this.view.setUint32(this.len, v); this.len += 4; };
+	Writer.prototype.i32 = function (v) { this.ensure(4); this.view.setInt32(this.len, v); this.len += 4; };
+	Writer.prototype.f64 = function (v) { this.ensure(8); this.view.setFloat64(this.len, v); this.len += 8; };
+	Writer.prototype.bytes = function (b) { this.ensure(b.length); this.buf.set(b, this.len); this.len += b.length; };
+	Writer.prototype.result = function () { return this.buf.subarray(0, this.len); };
+
+	// Reader reads big-endian values from an Uint8Array.
+	function Reader(b) {
+		this.buf = b;
+		this.view = new DataView(b.buffer, b.byteOffset, b.byteLength);
+		this.pos = 0;
+	}
+	Reader.prototype.check = function (n) {
+		if (this.pos + n > this.buf.length) throw new Error($codec.name + ": unexpected end of data");
+	};
+	Reader.prototype.u8 = function () { this.check(1); return this.buf[this.pos++]; };
+	Reader.prototype.u16 = function () { this.check(2); var v = this.view.getUint16(this.pos); this.pos += 2; return v; };
+	Reader.prototype.u32 = function () { this.check(4); var v = this.view.getUint32(this.pos); this.pos += 4; return v; };
+	Reader.prototype.i8 = function () { this.check(1); var v = this.view.getInt8(this.pos); this.pos += 1; return v; };
+	Reader.prototype.i16 = function () { this.check(2); var v = this.view.getInt16(this.pos); this.pos += 2; return v; };
+	Reader.prototype.i32 = function () { this.check(4); var v = this.view.getInt32(this.pos); this.pos += 4; return v; };
+	Reader.prototype.f32 = function () { this.check(4); var v = this.view.getFloat32(this.pos); this.pos += 4; return v; };
+	Reader.prototype.f64 = function () { this.check(8); var v = this.view.getFloat64(this.pos); this.pos += 8; return v; };
+	Reader.prototype.bytes = function (n) { this.check(n); var b = this.buf.subarray(this.pos, this.pos + n); this.pos += n; return b; };
+
+	var codec = (%s);
+	global.$codec = { name: %s, encode: codec.encode, decode: codec.decode };
+	global.$sendValue = function (v) {
+		$send(codec.encode(v));
+	};
+	global.$recvValue = function (fn) {
+		$recv(function (msg) {
+			return fn(msg instanceof Uint8Array ? codec.decode(msg) : msg);
+		});
+	};
+	global.$recvSyncValue = function (fn) {
+		$recvSync(function (msg) {
+			return msg instanceof Uint8Array ? codec.encode(fn(codec.decode(msg))) : fn(msg);
+		});
+	};
+})(this);
+`
+
+// SetCodec makes c the wire format of the typed messaging with the worker and
+// defines its js side: $codec.encode and $codec.decode, $sendValue(value) and
+// $recvValue(fn) and $recvSyncValue(fn), which register $recv and $recvSync
+// handlers getting decoded values.
+func (w *Worker) SetCodec(c Codec) error {
+	name, _ := json.Marshal(c.Name())
+	err := w.Load("v8worker:codec.js", fmt.Sprintf(codecPrelude, c.Script(), name))
+	if err != nil {
+		return err
+	}
+	callbacksMapLocker.Lock()
+	callbacksMap[w.id].codec = c
+	callbacksMapLocker.Unlock()
+	return nil
+}
+
+func (w *Worker) lookupCodec() Codec {
+	callbacksMapLocker.RLock()
+	defer callbacksMapLocker.RUnlock()
+	return callbacksMap[w.id].codec
+}
+
+// SendValue sends v encoded with the codec of the worker. The handler
+// registered with $recvValue in js gets the decoded value.
+func (w *Worker) SendValue(v interface{}) error {
+	c := w.lookupCodec()
+	if c == nil {
+		return ErrNoCodec
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data_s := C.CBytes(data)
+	defer C.free(data_s)
+
+	call := w.startCall("SendValue")
+	defer w.endCall(call)
+	r := C.worker_send_bytes(w.cWorker, (*C.char)(data_s), C.int(len(data)))
+	return w.loadError(r)
+}
+
+// RequestValue sends in encoded with the codec of the worker to the handler
+// registered with $recvSyncValue in js and decodes its result into out, which
+// may be nil to ignore it. Exceptions are returned as errors.
+func (w *Worker) RequestValue(in, out interface{}) error {
+	c := w.lookupCodec()
+	if c == nil {
+		return ErrNoCodec
+	}
+	data, err := c.Marshal(in)
+	if err != nil {
+		return err
+	}
+	data_s := C.CBytes(data)
+	defer C.free(data_s)
+
+	var n C.int
+	call := w.startCall("RequestValue")
+	defer w.endCall(call)
+	res := C.worker_send_sync_bytes(w.cWorker, (*C.char)(data_s), C.int(len(data)), &n)
+	if C.worker_last_terminated(w.cWorker) {
+		return w.terminatedError()
+	}
+	if w.IsDead() {
+		return ErrWorkerDead
+	}
+	if n < 0 {
+		return errors.New(strings.TrimPrefix(C.GoString(res), "err: "))
+	}
+	result := C.GoBytes(unsafe.Pointer(res), n)
+	if out == nil {
+		return nil
+	}
+	return c.Unmarshal(result, out)
+}
+
+// OnValue registers fn to receive the values js sends with $sendValue, or
+// typed arrays sent with $send. fn must be a func(T) or func(T) error, the
+// bytes are decoded into a new T with the codec of the worker. If decoding
+// fails or fn returns an error, $send throws a TypeError. Without a fn, such
+// messages are passed to the ReceiveMessageCallback as a string of the bytes.
+func (w *Worker) OnValue(fn interface{}) error {
+	h, err := newHandler("OnValue", fn)
+	if err != nil {
+		return err
+	}
+	callbacksMapLocker.Lock()
+	callbacksMap[w.id].value = h
+	callbacksMapLocker.Unlock()
+	return nil
+}
+
+//export recvBytesCb
+func recvBytesCb(data_s *C.char, n C.int, workerId int) *C.char {
+	data := C.GoBytes(unsafe.Pointer(data_s), n)
+	callbacksMapLocker.RLock()
+	cbs := callbacksMap[workerId]
+	callbacksMapLocker.RUnlock()
+
+	if !cbs.value.IsValid() {
+		cbs.cb(string(data))
+		return nil
+	}
+	if cbs.codec == nil {
+		return C.CString(ErrNoCodec.Error())
+	}
+	return callHandler(cbs.value, func(v interface{}) error {
+		return cbs.codec.Unmarshal(data, v)
+	})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) Script() string {
+	return `{
+		encode: function (v) {
+			var s = JSON.stringify(v);
+			return utf8Encode(s === undefined ? "null" : s);
+		},
+		decode: function (b) {
+			return JSON.parse(utf8Decode(b));
+		}
+	}`
+}
@@ -0,0 +1,152 @@
+package v8worker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type codecValue struct {
+	Name  string          `json:"name"`
+	Count int64           `json:"count"`
+	Ratio float64         `json:"ratio"`
+	Tags  []string        `json:"tags"`
+	Flags map[string]bool `json:"flags"`
+	Next  *codecValue     `json:"next,omitempty"`
+}
+
+var codecs = []Codec{JSONCodec, MessagePackCodec, CBORCodec}
+
+func testCodecValue() codecValue {
+	return codecValue{
+		Name:  "héllo 😀 " + strings.Repeat("x", 300),
+		Count: -1 << 40,
+		Ratio: 1.5,
+		Tags:  []string{"a", "b"},
+		Flags: map[string]bool{"on": true, "off": false},
+		Next:  &codecValue{Count: 70000, Tags: []string{}},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, c := range codecs {
+		in := testCodecValue()
+		data, err := c.Marshal(in)
+		if err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		var out codecValue
+		if err := c.Unmarshal(data, &out); err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatal(c.Name(), "round trip changed value", in, out)
+		}
+		if err := c.Unmarshal(data[:len(data)-1], &out); err == nil {
+			t.Fatal(c.Name(), "expected error for truncated data")
+		}
+	}
+}
+
+func TestSendValue(t *testing.T) {
+	for _, c := range codecs {
+		var got []codecValue
+		worker := New(func(msg string) {}, DiscardSendSync)
+		if err := worker.SendValue(1); err != ErrNoCodec {
+			t.Fatal("expected ErrNoCodec", err)
+		}
+		if err := worker.SetCodec(c); err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		err := worker.OnValue(func(v codecValue) {
+			got = append(got, v)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = worker.Load("code.js", `
+			$recvValue(function(v) {
+				v.count++;
+				$sendValue(v);
+			});
+			$recvSyncValue(function(v) {
+				if (v.count < 0) throw new Error("negative count");
+				return { name: $codec.name, count: v.count * 2 };
+			});
+		`)
+		if err != nil {
+			t.Fatal(c.Name(), err)
+		}
+
+		in := testCodecValue()
+		if err := worker.SendValue(in); err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		in.Count++
+		if len(got) != 1 || !reflect.DeepEqual(got[0], in) {
+			t.Fatal(c.Name(), "bad value", got)
+		}
+
+		var out codecValue
+		if err := worker.RequestValue(codecValue{Count: 21}, &out); err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if out.Name != c.Name() || out.Count != 42 {
+			t.Fatal(c.Name(), "bad response", out)
+		}
+		err = worker.RequestValue(codecValue{Count: -1}, &out)
+		if err == nil || !strings.Contains(err.Error(), "negative count") {
+			t.Fatal(c.Name(), "expected exception", err)
+		}
+	}
+}
+
+func TestCodecBytes(t *testing.T) {
+	in := []byte{0, 1, 2, 255}
+	for _, c := range []Codec{MessagePackCodec, CBORCodec} {
+		data, err := c.Marshal(in)
+		if err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		// a bin 8 of msgpack, a byte string of CBOR (major type 2)
+		if c == MessagePackCodec && (data[0] != 0xc4 || data[1] != byte(len(in))) ||
+			c == CBORCodec && data[0] != 2<<5|byte(len(in)) {
+			t.Fatalf("%s: []byte not encoded as a byte string: % x", c.Name(), data)
+		}
+		var out []byte
+		if err := c.Unmarshal(data, &out); err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Fatal(c.Name(), "round trip changed value", out)
+		}
+		var generic interface{}
+		if err := c.Unmarshal(data, &generic); err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if !reflect.DeepEqual(generic, in) {
+			t.Fatal(c.Name(), "expected []byte", generic)
+		}
+
+		// js sees an Uint8Array
+		worker := New(func(msg string) {}, DiscardSendSync)
+		if err := worker.SetCodec(c); err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		err = worker.Load("code.js", `
+			$recvSyncValue(function(v) {
+				if (!(v instanceof Uint8Array)) throw new Error("not an Uint8Array");
+				return new Uint8Array([v.length, v[3]]);
+			});
+		`)
+		if err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if err := worker.RequestValue(in, &out); err != nil {
+			t.Fatal(c.Name(), err)
+		}
+		if !reflect.DeepEqual(out, []byte{4, 255}) {
+			t.Fatal(c.Name(), "bad response", out)
+		}
+	}
+}
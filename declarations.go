@@ -0,0 +1,250 @@
+package v8worker
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// builtinDeclarations declares the globals every worker defines.
+const builtinDeclarations = `declare function $print(...args: any[]): void;
+declare function $send(msg: string | ArrayBufferView | object | number | boolean | null): void;
+declare function $recv(callback: (msg: any) => void): void;
+declare function $sendSync(msg: string): string;
+declare function $recvSync(callback: (msg: any) => any): void;
+`
+
+// rpcDeclarations declares $rpc, see EnableRPC.
+const rpcDeclarations = `declare namespace $rpc {
+	class Error {
+		constructor(code: number, message: string, data?: any);
+		name: string;
+		code: number;
+		message: string;
+		data?: any;
+		stack?: string;
+	}
+	function register(method: string, fn: (params: any) => any): void;
+	function call(method: string, params?: any): Promise<any>;
+	function notify(method: string, params?: any): void;
+	function callSync(method: string, params?: any): any;
+	function batch(calls: { method: string; params?: any; notification?: boolean }[]): Promise<any[]>;
+}
+`
+
+// TypeScriptDeclarations returns a .d.ts file declaring the built-in globals,
+// $rpc if EnableRPC was called, and the services exposed with Expose. Go
+// types are declared as they are encoded by encoding/json: named structs
+// become interfaces, pointers may be null and json tags are honored.
+func (w *Worker) TypeScriptDeclarations() string {
+	p := w.lookupRPC()
+	if p == nil {
+		return declarations(false, nil)
+	}
+	p.locker.Lock()
+	services := append([]*service(nil), p.services...)
+	p.locker.Unlock()
+	return declarations(true, services)
+}
+
+func declarations(rpc bool, services []*service) string {
+	var b strings.Builder
+	b.WriteString("// Code generated by v8worker. DO NOT EDIT.\n\n")
+	b.WriteString(builtinDeclarations)
+	if rpc {
+		b.WriteString("\n" + rpcDeclarations)
+	}
+
+	sort.Slice(services, func(i, j int) bool { return services[i].name < services[j].name })
+	g := &tsGenerator{names: make(map[reflect.Type]string), used: make(map[string]bool)}
+	var decls strings.Builder
+	for _, s := range services {
+		decls.WriteString("\ndeclare const " + s.name + ": {\n")
+		for _, m := range s.methods {
+			var params []string
+			types := m.params()
+			for i, t := range types {
+				if m.fn.Type().IsVariadic() && i == len(types)-1 {
+					params = append(params, "..."+paramName(i)+": "+g.typeOf(t.Elem(), true)+"[]")
+					continue
+				}
+				params = append(params, paramName(i)+": "+g.typeOf(t, false))
+			}
+			result := "void"
+			if t := m.result(); t != nil {
+				result = g.typeOf(t, false)
+			}
+			decls.WriteString("\t" + m.name + "(" + strings.Join(params, ", ") + "): " + result + ";\n")
+		}
+		decls.WriteString("};\n")
+	}
+	for _, iface := range g.interfaces {
+		b.WriteString("\n" + iface)
+	}
+	b.WriteString(decls.String())
+	return b.String()
+}
+
+func paramName(i int) string {
+	return "arg" + strconv.Itoa(i)
+}
+
+// tsGenerator converts Go types to TypeScript, collecting the interfaces of
+// named structs.
+type tsGenerator struct {
+	names      map[reflect.Type]string
+	used       map[string]bool
+	interfaces []string
+}
+
+// typeOf returns the TypeScript type of the JSON encoding of t. elem is set for
+// types used in arrays, which need parentheses around unions.
+func (g *tsGenerator) typeOf(t reflect.Type, elem bool) string {
+	if t == timeType {
+		return "string"
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return "any"
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Ptr:
+		s := g.typeOf(t.Elem(), false) + " | null"
+		if elem {
+			return "(" + s + ")"
+		}
+		return s
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string" // base64
+		}
+		s := g.typeOf(t.Elem(), true) + "[] | null"
+		if elem {
+			return "(" + s + ")"
+		}
+		return s
+	case reflect.Array:
+		return g.typeOf(t.Elem(), true) + "[]"
+	case reflect.Map:
+		s := "{ [key: string]: " + g.typeOf(t.Elem(), false) + " } | null"
+		if elem {
+			return "(" + s + ")"
+		}
+		return s
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t, false)
+		}
+		return g.named(t)
+	}
+	return "any"
+}
+
+// named returns the name of the interface of the named struct t, declaring it
+// on first use.
+func (g *tsGenerator) named(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	base := t.Name()
+	if i := strings.IndexByte(base, '['); i >= 0 {
+		base = base[:i] // generic instance
+	}
+	name := base
+	for i := 2; g.used[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	g.names[t] = name
+	g.used[name] = true
+	// reserve the slot before generating the fields, which may refer to t
+	n := len(g.interfaces)
+	g.interfaces = append(g.interfaces, "")
+	g.interfaces[n] = "interface " + name + " " + g.object(t, true) + "\n"
+	return name
+}
+
+// object returns the TypeScript object type of the fields of the struct t,
+// on several lines for interfaces.
+func (g *tsGenerator) object(t reflect.Type, multiline bool) string {
+	var fields []string
+	g.fields(t, &fields, make(map[string]bool))
+	switch {
+	case len(fields) == 0:
+		return "{}"
+	case multiline:
+		return "{\n\t" + strings.Join(fields, "\n\t") + "\n}"
+	}
+	return "{ " + strings.Join(fields, " ") + " }"
+}
+
+// fields appends the fields of the struct t as encoding/json encodes them.
+// Fields of untagged embedded structs are promoted unless t has a field of the
+// same name.
+func (g *tsGenerator) fields(t reflect.Type, fields *[]string, seen map[string]bool) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded = append(embedded, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		key := name
+		if !identifierRE.MatchString(key) {
+			key = jsString(key)
+		}
+		optional := ""
+		if strings.Contains(","+opts+",", ",omitempty,") {
+			optional = "?"
+		}
+		typ := g.typeOf(ft, false)
+		if strings.Contains(","+opts+",", ",string,") {
+			typ = "string"
+		}
+		*fields = append(*fields, key+optional+": "+typ+";")
+	}
+	for _, et := range embedded {
+		g.fields(et, fields, seen)
+	}
+}
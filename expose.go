@@ -0,0 +1,217 @@
+package v8worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+var identifierRE = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// service is a Go value exposed to js with Expose.
+type service struct {
+	name    string
+	methods []*serviceMethod
+}
+
+type serviceMethod struct {
+	name string // js name
+	fn   reflect.Value
+	ctx  bool // fn takes a context.Context first
+}
+
+// Expose defines a global js object name whose methods call the exported
+// methods of impl, named in lower camel case: GetUser becomes getUser.
+// Arguments and results are passed as JSON. Methods may take a
+// context.Context first and must return nothing, a value, an error or a value
+// and an error. Errors are thrown as $rpc.Error. The stubs block until the
+// method returns, which runs like a HandleRPC handler of $rpc.callSync and
+// may call the worker again; the methods are also available to $rpc.call as
+// "name.getUser". Expose needs EnableRPC to have been called. See
+// TypeScriptDeclarations for the types of exposed services.
+func (w *Worker) Expose(name string, impl interface{}) error {
+	p := w.lookupRPC()
+	if p == nil {
+		return ErrRPCDisabled
+	}
+	if !identifierRE.MatchString(name) {
+		return errors.New("v8worker: Expose: invalid service name " + name)
+	}
+	s, err := newService(name, impl)
+	if err != nil {
+		return err
+	}
+
+	var script strings.Builder
+	script.WriteString("this[" + jsString(name) + "] = (function () {\n")
+	script.WriteString("\tfunction stub(method) {\n")
+	script.WriteString("\t\treturn function () {\n")
+	script.WriteString("\t\t\treturn $rpc.callSync(method, Array.prototype.slice.call(arguments));\n")
+	script.WriteString("\t\t};\n\t}\n\treturn {\n")
+	for i, m := range s.methods {
+		sep := ","
+		if i == len(s.methods)-1 {
+			sep = ""
+		}
+		script.WriteString("\t\t" + m.name + ": stub(" + jsString(name+"."+m.name) + ")" + sep + "\n")
+	}
+	script.WriteString("\t};\n})();\n")
+	if err := w.Load("v8worker:expose/"+name+".js", script.String()); err != nil {
+		return err
+	}
+
+	p.locker.Lock()
+	for i, old := range p.services {
+		if old.name == name {
+			// methods the new impl lacks must not stay callable
+			for _, m := range old.methods {
+				delete(p.methods, old.name+"."+m.name)
+			}
+			p.services = append(p.services[:i], p.services[i+1:]...)
+			break
+		}
+	}
+	for _, m := range s.methods {
+		p.methods[name+"."+m.name] = m.handler()
+	}
+	p.services = append(p.services, s)
+	p.locker.Unlock()
+	return nil
+}
+
+func newService(name string, impl interface{}) (*service, error) {
+	v := reflect.ValueOf(impl)
+	if !v.IsValid() {
+		return nil, errors.New("v8worker: Expose: nil service " + name)
+	}
+	s := &service{name: name}
+	t := v.Type()
+	for i := 0; i < t.NumMethod(); i++ {
+		method := t.Method(i)
+		if method.PkgPath != "" {
+			continue
+		}
+		m := &serviceMethod{name: jsName(method.Name), fn: v.Method(i)}
+		ft := m.fn.Type()
+		m.ctx = ft.NumIn() > 0 && ft.In(0) == contextType
+		switch {
+		case ft.NumOut() > 2,
+			ft.NumOut() == 2 && ft.Out(1) != errorType:
+			return nil, errors.New("v8worker: Expose: " + name + "." + method.Name +
+				" must return nothing, a value, an error or a value and an error")
+		}
+		s.methods = append(s.methods, m)
+	}
+	if len(s.methods) == 0 {
+		return nil, errors.New("v8worker: Expose: " + t.String() + " has no exported methods")
+	}
+	return s, nil
+}
+
+// params returns the types of the js arguments of m.
+func (m *serviceMethod) params() []reflect.Type {
+	ft := m.fn.Type()
+	var in []reflect.Type
+	for i := 0; i < ft.NumIn(); i++ {
+		if i == 0 && m.ctx {
+			continue
+		}
+		in = append(in, ft.In(i))
+	}
+	return in
+}
+
+// result returns the type of the value returned by m, nil if there is none.
+func (m *serviceMethod) result() reflect.Type {
+	ft := m.fn.Type()
+	if ft.NumOut() == 0 || (ft.NumOut() == 1 && ft.Out(0) == errorType) {
+		return nil
+	}
+	return ft.Out(0)
+}
+
+// handler adapts m to the RPC: params is the array of the arguments. Missing
+// arguments are zero values.
+func (m *serviceMethod) handler() RPCHandler {
+	return func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var raw []json.RawMessage
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &raw); err != nil {
+				return nil, &RPCError{Code: RPCInvalidParams, Message: "params must be an array"}
+			}
+		}
+
+		types := m.params()
+		variadic := m.fn.Type().IsVariadic()
+		if len(raw) > len(types) && !variadic {
+			return nil, &RPCError{Code: RPCInvalidParams, Message: "too many arguments"}
+		}
+		var args []reflect.Value
+		if m.ctx {
+			args = append(args, reflect.ValueOf(ctx))
+		}
+		for i, t := range types {
+			if variadic && i == len(types)-1 {
+				rest := reflect.MakeSlice(t, 0, 0)
+				for j := i; j < len(raw); j++ {
+					e := reflect.New(t.Elem())
+					if err := json.Unmarshal(raw[j], e.Interface()); err != nil {
+						return nil, &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+					}
+					rest = reflect.Append(rest, e.Elem())
+				}
+				args = append(args, rest)
+				break
+			}
+			arg := reflect.New(t)
+			if i < len(raw) {
+				if err := json.Unmarshal(raw[i], arg.Interface()); err != nil {
+					return nil, &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+				}
+			}
+			args = append(args, arg.Elem())
+		}
+
+		var out []reflect.Value
+		if variadic {
+			out = m.fn.CallSlice(args)
+		} else {
+			out = m.fn.Call(args)
+		}
+		if len(out) > 0 && out[len(out)-1].Type() == errorType {
+			if err := out[len(out)-1]; !err.IsNil() {
+				return nil, err.Interface().(error)
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return nil, nil
+		}
+		return out[0].Interface(), nil
+	}
+}
+
+// jsName converts the name of a Go method to lower camel case, keeping
+// initialisms together: HTTPGet becomes httpGet.
+func jsName(name string) string {
+	runes := []rune(name)
+	for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+// jsString quotes s as a js string literal.
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
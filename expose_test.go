@@ -0,0 +1,127 @@
+package v8worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testPoint struct {
+	X     int    `json:"x"`
+	Y     int    `json:"y"`
+	Label string `json:"label,omitempty"`
+}
+
+type testGeometry struct{}
+
+func (testGeometry) Add(a, b testPoint) testPoint {
+	return testPoint{X: a.X + b.X, Y: a.Y + b.Y}
+}
+
+func (testGeometry) Sum(ctx context.Context, xs ...int) (int, error) {
+	if len(xs) == 0 {
+		return 0, errors.New("nothing to sum")
+	}
+	n := 0
+	for _, x := range xs {
+		n += x
+	}
+	return n, nil
+}
+
+func (testGeometry) HTTPStatus() int {
+	return 200
+}
+
+func TestExpose(t *testing.T) {
+	var results []string
+	worker := newRPCWorker(t, func(msg string) {
+		results = append(results, msg)
+	})
+	if err := worker.Expose("geo", testGeometry{}); err != nil {
+		t.Fatal(err)
+	}
+	err := worker.Load("code.js", `
+		var p = geo.add({x: 1, y: 2}, {x: 3, y: 4});
+		$send(p.x + "," + p.y);
+		$send(String(geo.sum(1, 2, 3)));
+		$send(String(geo.httpStatus()));
+		try {
+			geo.sum();
+		} catch (e) {
+			$send(e.code + " " + e.message);
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"4,6", "6", "200", "-32000 nothing to sum"}
+	if strings.Join(results, "|") != strings.Join(expected, "|") {
+		t.Fatal("bad results", results)
+	}
+
+	// exposing the name again drops the methods of the old impl
+	results = nil
+	if err := worker.Expose("geo", testStatus{}); err != nil {
+		t.Fatal(err)
+	}
+	err = worker.Load("code2.js", `
+		$send(String(geo.httpStatus()));
+		try {
+			$rpc.callSync("geo.sum", [1, 2]);
+		} catch (e) {
+			$send(String(e.code));
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(results, "|") != "404|-32601" {
+		t.Fatal("bad results", results)
+	}
+}
+
+type testStatus struct{}
+
+func (testStatus) HTTPStatus() int {
+	return 404
+}
+
+func TestExposeErrors(t *testing.T) {
+	worker := New(func(msg string) {}, DiscardSendSync)
+	if err := worker.Expose("geo", testGeometry{}); err != ErrRPCDisabled {
+		t.Fatal("expected ErrRPCDisabled", err)
+	}
+	worker.EnableRPC()
+	if err := worker.Expose("not valid", testGeometry{}); err == nil {
+		t.Fatal("expected invalid name error")
+	}
+	if err := worker.Expose("empty", struct{}{}); err == nil {
+		t.Fatal("expected no methods error")
+	}
+}
+
+func TestTypeScriptDeclarations(t *testing.T) {
+	worker := newRPCWorker(t, func(msg string) {})
+	if err := worker.Expose("geo", testGeometry{}); err != nil {
+		t.Fatal(err)
+	}
+	dts := worker.TypeScriptDeclarations()
+	for _, want := range []string{
+		"declare function $send(",
+		"declare function $recv(",
+		"declare function $print(",
+		"declare namespace $rpc {",
+		"interface testPoint {\n\tx: number;\n\ty: number;\n\tlabel?: string;\n}",
+		"declare const geo: {\n" +
+			"\tadd(arg0: testPoint, arg1: testPoint): testPoint;\n" +
+			"\thttpStatus(): number;\n" +
+			"\tsum(...arg0: number[]): number;\n" +
+			"};",
+	} {
+		if !strings.Contains(dts, want) {
+			t.Fatalf("declarations lack %q:\n%s", want, dts)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package v8worker
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Frames are the unit of the binary protocol spoken between a RemoteWorker and
+// its host process, and between a Client and a Server. Each frame is a 9 byte
+// header (kind, request id and payload length, big endian) followed by the
+// payload.
+const (
+	frameHeaderSize = 9
+	maxFramePayload = 64 << 20
+)
+
+const (
+	frameLoad byte = iota + 1
+	frameSend
+	frameSendSync
+	frameTerminate
+	frameHeapStatistics
+	frameResult
+	frameError
+	frameRecv
+	frameRecvSync
+	frameAuth
+)
+
+var errFrameTooLarge = errors.New("v8worker: frame payload too large")
+
+type frame struct {
+	kind    byte
+	id      uint32
+	payload []byte
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	if len(f.payload) > maxFramePayload {
+		return errFrameTooLarge
+	}
+	buf := make([]byte, frameHeaderSize+len(f.payload))
+	buf[0] = f.kind
+	binary.BigEndian.PutUint32(buf[1:5], f.id)
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(f.payload)))
+	copy(buf[frameHeaderSize:], f.payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		kind: hdr[0],
+		id:   binary.BigEndian.Uint32(hdr[1:5]),
+	}
+	n := binary.BigEndian.Uint32(hdr[5:9])
+	if n > maxFramePayload {
+		return frame{}, errFrameTooLarge
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return frame{}, err
+	}
+	return f, nil
+}
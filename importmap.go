@@ -0,0 +1,196 @@
+package v8worker
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ImportMap remaps module specifiers like a WICG import map
+// (https://github.com/WICG/import-maps) before they are resolved, e.g. to let
+// require("lodash") load vendor/lodash-4.17.21/lodash.js. See Config.ImportMap.
+//
+// The map is a JSON document with "imports" and "scopes". URLs are relative to
+// the root of the file system given to EnableRequire, which is file:///, and
+// the URL of a script is file:/// followed by its path, so "/lib/", "./lib/"
+// and "file:///lib/" all name the lib directory.
+type ImportMap struct {
+	imports specifierMap
+	scopes  []scope // longest prefix first
+}
+
+type scope struct {
+	prefix  string
+	imports specifierMap
+}
+
+// specifierMap holds the normalized entries of "imports" or of a scope,
+// longest key first.
+type specifierMap []specifierEntry
+
+type specifierEntry struct {
+	key     string
+	address *url.URL // nil if the specifier is blocked by a null address
+}
+
+// importMapBase is the URL of the root of the file system.
+var importMapBase = &url.URL{Scheme: "file", Path: "/"}
+
+// ParseImportMap parses the import map JSON document data. Unlike browsers,
+// which skip invalid entries with a warning, it fails on them.
+func ParseImportMap(data []byte) (*ImportMap, error) {
+	m := new(ImportMap)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, so that a Config can be read
+// from JSON.
+func (m *ImportMap) UnmarshalJSON(data []byte) error {
+	var doc struct {
+		Imports map[string]*string            `json:"imports"`
+		Scopes  map[string]map[string]*string `json:"scopes"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return errors.New("import map: " + err.Error())
+	}
+	imports, err := normalizeSpecifierMap(doc.Imports)
+	if err != nil {
+		return err
+	}
+	var scopes []scope
+	for prefix, scopeImports := range doc.Scopes {
+		prefixURL, err := importMapBase.Parse(prefix)
+		if err != nil {
+			return errors.New("import map: invalid scope '" + prefix + "'")
+		}
+		normalized, err := normalizeSpecifierMap(scopeImports)
+		if err != nil {
+			return err
+		}
+		scopes = append(scopes, scope{prefix: prefixURL.String(), imports: normalized})
+	}
+	sort.Slice(scopes, func(i, j int) bool { return scopes[i].prefix > scopes[j].prefix })
+	m.imports, m.scopes = imports, scopes
+	return nil
+}
+
+func normalizeSpecifierMap(entries map[string]*string) (specifierMap, error) {
+	var normalized specifierMap
+	for specifier, address := range entries {
+		if specifier == "" {
+			return nil, errors.New("import map: empty specifier")
+		}
+		key := specifier
+		if u := parseURLLikeSpecifier(specifier, importMapBase); u != nil {
+			key = u.String()
+		}
+		entry := specifierEntry{key: key}
+		if address != nil {
+			u := parseURLLikeSpecifier(*address, importMapBase)
+			if u == nil {
+				return nil, errors.New("import map: invalid address '" + *address + "' of '" + specifier + "'")
+			}
+			if strings.HasSuffix(specifier, "/") && !strings.HasSuffix(u.String(), "/") {
+				return nil, errors.New("import map: address '" + *address + "' of '" + specifier + "' must end with /")
+			}
+			entry.address = u
+		}
+		normalized = append(normalized, entry)
+	}
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i].key > normalized[j].key })
+	return normalized, nil
+}
+
+// parseURLLikeSpecifier returns the URL of a specifier which is an absolute
+// URL or a path starting with /, ./ or ../, relative to base, or nil for bare
+// specifiers.
+func parseURLLikeSpecifier(specifier string, base *url.URL) *url.URL {
+	if strings.HasPrefix(specifier, "/") || strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") {
+		u, err := base.Parse(specifier)
+		if err != nil {
+			return nil
+		}
+		return u
+	}
+	u, err := url.Parse(specifier)
+	if err != nil || !u.IsAbs() {
+		return nil
+	}
+	return u
+}
+
+// specialSchemes are the schemes of hierarchical URLs, whose paths may be
+// remapped by prefix.
+var specialSchemes = map[string]bool{"ftp": true, "file": true, "http": true, "https": true, "ws": true, "wss": true}
+
+// resolve applies the map to specifier, imported from the script named
+// referrer. It reports whether the map has an entry for specifier and if so
+// returns the path in the file system it maps to.
+func (m *ImportMap) resolve(specifier, referrer string) (string, bool, error) {
+	referrerURL := *importMapBase
+	if p := path.Clean(strings.TrimPrefix(referrer, "/")); fs.ValidPath(p) && p != "." {
+		referrerURL.Path = "/" + p
+	}
+	asURL := parseURLLikeSpecifier(specifier, &referrerURL)
+	normalized := specifier
+	if asURL != nil {
+		normalized = asURL.String()
+	}
+
+	var target *url.URL
+	var ok bool
+	var err error
+	for _, s := range m.scopes {
+		if s.prefix == referrerURL.String() || strings.HasSuffix(s.prefix, "/") && strings.HasPrefix(referrerURL.String(), s.prefix) {
+			if target, ok, err = s.imports.resolve(normalized, asURL); ok || err != nil {
+				break
+			}
+		}
+	}
+	if !ok && err == nil {
+		target, ok, err = m.imports.resolve(normalized, asURL)
+	}
+	if !ok || err != nil {
+		return "", ok, err
+	}
+	if target.Scheme != "file" || target.Host != "" {
+		return "", true, errors.New("Cannot find module '" + specifier + "': the import map maps it to '" + target.String() + "', which is not a file")
+	}
+	p := path.Clean(strings.TrimPrefix(target.Path, "/"))
+	if !fs.ValidPath(p) {
+		return "", true, errors.New("Cannot find module '" + specifier + "': the import map maps it to '" + target.String() + "'")
+	}
+	return p, true, nil
+}
+
+// resolve returns the URL normalized is mapped to by an exact match or the
+// longest matching prefix ending with /.
+func (sm specifierMap) resolve(normalized string, asURL *url.URL) (*url.URL, bool, error) {
+	for _, e := range sm {
+		if e.key == normalized {
+			if e.address == nil {
+				return nil, true, errors.New("Cannot find module '" + normalized + "': blocked by the import map")
+			}
+			return e.address, true, nil
+		}
+		if !strings.HasSuffix(e.key, "/") || !strings.HasPrefix(normalized, e.key) || asURL != nil && !specialSchemes[asURL.Scheme] {
+			continue
+		}
+		if e.address == nil {
+			return nil, true, errors.New("Cannot find module '" + normalized + "': blocked by the import map")
+		}
+		u, err := e.address.Parse(normalized[len(e.key):])
+		if err != nil || !strings.HasPrefix(u.String(), e.address.String()) {
+			return nil, true, errors.New("Cannot find module '" + normalized + "': the import map entry '" + e.key + "' does not map it into '" + e.address.String() + "'")
+		}
+		return u, true, nil
+	}
+	return nil, false, nil
+}
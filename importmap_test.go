@@ -0,0 +1,141 @@
+package v8worker
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const testImportMap = `{
+	"imports": {
+		"lodash": "/vendor/lodash-4/lodash.js",
+		"lodash/": "/vendor/lodash-4/",
+		"app/": "./src/",
+		"/lib/old.js": "/lib/new.js",
+		"blocked": null,
+		"remote": "https://example.com/remote.js"
+	},
+	"scopes": {
+		"/legacy/": {
+			"lodash": "/vendor/lodash-3/lodash.js"
+		},
+		"/legacy/modern/": {
+			"lodash": "/vendor/lodash-5/lodash.js"
+		},
+		"/legacy/pinned.js": {
+			"lodash/": "/vendor/lodash-2/"
+		}
+	}
+}`
+
+func TestImportMapResolve(t *testing.T) {
+	m, err := ParseImportMap([]byte(testImportMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		specifier, referrer, expected string
+	}{
+		{"lodash", "main.js", "vendor/lodash-4/lodash.js"},
+		{"lodash/fp.js", "main.js", "vendor/lodash-4/fp.js"},
+		{"app/util.js", "main.js", "src/util.js"},
+		{"./old.js", "lib/main.js", "lib/new.js"},
+		{"/lib/old.js", "main.js", "lib/new.js"},
+		// the most specific scope wins, then less specific ones, then imports
+		{"lodash", "legacy/a.js", "vendor/lodash-3/lodash.js"},
+		{"lodash", "legacy/modern/a.js", "vendor/lodash-5/lodash.js"},
+		{"lodash", "/legacy/modern/deep/a.js", "vendor/lodash-5/lodash.js"},
+		{"lodash/fp.js", "legacy/a.js", "vendor/lodash-4/fp.js"},
+		// a scope without a trailing slash only matches that script
+		{"lodash/fp.js", "legacy/pinned.js", "vendor/lodash-2/fp.js"},
+		{"lodash", "legacy/pinned.js", "vendor/lodash-3/lodash.js"},
+		{"lodash/fp.js", "legacy/pinned.js.bak", "vendor/lodash-4/fp.js"},
+	}
+	for _, test := range tests {
+		p, ok, err := m.resolve(test.specifier, test.referrer)
+		if err != nil || !ok || p != test.expected {
+			t.Errorf("%s from %s: got %q, %v, %v want %q", test.specifier, test.referrer, p, ok, err, test.expected)
+		}
+	}
+
+	for _, specifier := range []string{"underscore", "./lib/a.js", "/lib/a.js"} {
+		if _, ok, err := m.resolve(specifier, "main.js"); ok || err != nil {
+			t.Error("Expected", specifier, "to be unmapped", err)
+		}
+	}
+	for _, specifier := range []string{"blocked", "remote", "lodash/../../secret.js"} {
+		if _, ok, err := m.resolve(specifier, "main.js"); !ok || err == nil {
+			t.Error("Expected error for", specifier)
+		}
+	}
+}
+
+func TestParseImportMapErrors(t *testing.T) {
+	for _, doc := range []string{
+		`[]`,
+		`{"imports": {"a": 1}}`,
+		`{"imports": {"a/": "/b"}}`,
+		`{"imports": {"a": "b"}}`,
+		`{"imports": {"": "/a.js"}}`,
+	} {
+		if _, err := ParseImportMap([]byte(doc)); err == nil {
+			t.Error("Expected error for", doc)
+		}
+	}
+}
+
+func TestRequireImportMap(t *testing.T) {
+	fsys := fstest.MapFS{
+		"vendor/lodash-4/lodash.js":      {Data: []byte(`exports.version = "4";`)},
+		"vendor/lodash-3/lodash.js":      {Data: []byte(`exports.version = "3";`)},
+		"vendor/lodash-5/lodash.js":      {Data: []byte(`exports.version = "5";`)},
+		"legacy/plugin.js":               {Data: []byte(`module.exports = require("lodash").version;`)},
+		"node_modules/left-pad/index.js": {Data: []byte(`module.exports = "left-pad";`)},
+	}
+	m, err := ParseImportMap([]byte(testImportMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var caught []string
+	worker := NewWithConfig(func(msg string) {
+		caught = append(caught, msg)
+	}, DiscardSendSync, &Config{ImportMap: m})
+	if err := worker.EnableRequire(fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	err = worker.Load("main.js", `
+		$send(require("lodash").version);
+		$send(require("./legacy/plugin"));
+		$send(require("left-pad"));
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caught, ",") != "4,3,left-pad" {
+		t.Fatal("bad msgs", caught)
+	}
+
+	// scopes apply to top-level scripts by their ScriptName
+	caught = nil
+	err = worker.Load("legacy/main.js", `$send(require("lodash").version);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = worker.Load("/legacy/modern/main.js", `$send(require("lodash").version);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caught, ",") != "3,5" {
+		t.Fatal("bad msgs", caught)
+	}
+
+	err = worker.Load("main2.js", `require("underscore");`)
+	if err == nil || !strings.Contains(err.Error(), "not mapped by the import map") {
+		t.Fatal("Expected error for unmapped bare specifier", err)
+	}
+	err = worker.Load("main3.js", `require("blocked");`)
+	if err == nil || !strings.Contains(err.Error(), "blocked by the import map") {
+		t.Fatal("Expected error for blocked specifier", err)
+	}
+}
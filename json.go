@@ -0,0 +1,121 @@
+package v8worker
+
+/*
+#include <stdlib.h>
+#include "binding.h"
+*/
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"unsafe"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// SendJSON sends v encoded as JSON to the worker. The $recv callback in js is
+// called with the decoded value instead of a string.
+func (w *Worker) SendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	msg_s := C.CString(string(data))
+	defer C.free(unsafe.Pointer(msg_s))
+
+	call := w.startCall("SendJSON")
+	defer w.endCall(call)
+	r := C.worker_send_json(w.cWorker, msg_s)
+	return w.loadError(r)
+}
+
+// RequestJSON calls the $recvSync callback in js with in, encoded as JSON and
+// decoded in js, and decodes the value it returns into out, which may be nil
+// to ignore it. Exceptions are returned as errors.
+func (w *Worker) RequestJSON(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	msg_s := C.CString(string(data))
+	defer C.free(unsafe.Pointer(msg_s))
+
+	call := w.startCall("RequestJSON")
+	defer w.endCall(call)
+	svalue := C.worker_send_sync_json(w.cWorker, msg_s)
+	if C.worker_last_terminated(w.cWorker) {
+		return w.terminatedError()
+	}
+	if w.IsDead() {
+		return ErrWorkerDead
+	}
+	res := C.GoString(svalue)
+	if strings.HasPrefix(res, "err: ") {
+		return errors.New(strings.TrimPrefix(res, "err: "))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(res), out)
+}
+
+// OnJSON registers fn to receive the values other than strings that js sends
+// with $send, e.g. $send({id: 1}). fn must be a func(T) or func(T) error, the
+// JSON text of the value is decoded into a new T. If decoding fails or fn
+// returns an error, $send throws a TypeError. Without a fn, such values are
+// passed to the ReceiveMessageCallback as JSON text.
+func (w *Worker) OnJSON(fn interface{}) error {
+	h, err := newHandler("OnJSON", fn)
+	if err != nil {
+		return err
+	}
+	callbacksMapLocker.Lock()
+	callbacksMap[w.id].json = h
+	callbacksMapLocker.Unlock()
+	return nil
+}
+
+// newHandler checks that fn, given to method, is a func(T) or func(T) error.
+func newHandler(method string, fn interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(fn)
+	if !v.IsValid() {
+		return v, errors.New("v8worker: " + method + " wants a func(T) or func(T) error, got nil")
+	}
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() != 1 || t.NumOut() > 1 || (t.NumOut() == 1 && t.Out(0) != errorType) {
+		return v, errors.New("v8worker: " + method + " wants a func(T) or func(T) error, got " + t.String())
+	}
+	return v, nil
+}
+
+// callHandler calls the handler h with a new T filled by decode. The error of
+// decode or h is returned as a C string to be thrown in js, or nil.
+func callHandler(h reflect.Value, decode func(v interface{}) error) *C.char {
+	arg := reflect.New(h.Type().In(0))
+	if err := decode(arg.Interface()); err != nil {
+		return C.CString("$send: " + err.Error())
+	}
+	out := h.Call([]reflect.Value{arg.Elem()})
+	if len(out) == 1 && !out[0].IsNil() {
+		return C.CString(out[0].Interface().(error).Error())
+	}
+	return nil
+}
+
+//export recvJSONCb
+func recvJSONCb(msg_s *C.char, workerId int) *C.char {
+	msg := C.GoString(msg_s)
+	callbacksMapLocker.RLock()
+	cbs := callbacksMap[workerId]
+	callbacksMapLocker.RUnlock()
+
+	if !cbs.json.IsValid() {
+		cbs.cb(msg)
+		return nil
+	}
+	return callHandler(cbs.json, func(v interface{}) error {
+		return json.Unmarshal([]byte(msg), v)
+	})
+}
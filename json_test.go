@@ -0,0 +1,107 @@
+package v8worker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+func TestSendJSON(t *testing.T) {
+	var got []string
+	worker := New(func(msg string) {
+		got = append(got, msg)
+	}, DiscardSendSync)
+	err := worker.Load("code.js", `
+		$recv(function(p) {
+			if (typeof p !== "object") throw new Error("got " + typeof p);
+			$send(String(p.x + p.y));
+		});
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.SendJSON(point{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "3" {
+		t.Fatal("bad messages", got)
+	}
+}
+
+func TestRequestJSON(t *testing.T) {
+	worker := New(func(msg string) {}, DiscardSendSync)
+	err := worker.Load("code.js", `
+		$recvSync(function(p) {
+			if (p.x < 0) throw new Error("negative");
+			return { x: p.y, y: p.x };
+		});
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out point
+	if err := worker.RequestJSON(point{X: 1, Y: 2}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != (point{X: 2, Y: 1}) {
+		t.Fatal("bad response", out)
+	}
+	err = worker.RequestJSON(point{X: -1}, &out)
+	if err == nil || !strings.Contains(err.Error(), "negative") {
+		t.Fatal("expected exception", err)
+	}
+}
+
+func TestOnJSON(t *testing.T) {
+	var texts []string
+	worker := New(func(msg string) {
+		texts = append(texts, msg)
+	}, DiscardSendSync)
+
+	// without a handler values arrive as JSON text
+	if err := worker.Load("code.js", `$send({x: 1, y: 2}); $send("plain");`); err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || texts[0] != `{"x":1,"y":2}` || texts[1] != "plain" {
+		t.Fatal("bad messages", texts)
+	}
+
+	if err := worker.OnJSON("not a func"); err == nil {
+		t.Fatal("expected error")
+	}
+	var points []point
+	err := worker.OnJSON(func(p point) error {
+		if p.X < 0 {
+			return errors.New("negative x")
+		}
+		points = append(points, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.Load("code2.js", `$send({x: 3, y: 4});`); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0] != (point{X: 3, Y: 4}) {
+		t.Fatal("bad points", points)
+	}
+	err = worker.Load("code3.js", `
+		try {
+			$send({x: -1});
+		} catch (e) {
+			$send("caught " + e.message);
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts[len(texts)-1] != "caught negative x" {
+		t.Fatal("expected TypeError in js", texts)
+	}
+}
@@ -0,0 +1,170 @@
+package v8worker
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// loadedFile is a successful call of LoadFile (fsys is nil, name is the
+// filename) or of LoadFS (name is the pattern).
+type loadedFile struct {
+	fsys fs.FS
+	name string
+}
+
+// sum hashes the files f loads. For LoadFS the names matching the pattern are
+// hashed too, so that added and removed files count as changes. Unreadable
+// files hash to zero.
+func (f loadedFile) sum() [sha256.Size]byte {
+	if f.fsys == nil {
+		data, err := os.ReadFile(f.name)
+		if err != nil {
+			return [sha256.Size]byte{}
+		}
+		return sha256.Sum256(data)
+	}
+	names, err := fs.Glob(f.fsys, f.name)
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	h := sha256.New()
+	for _, name := range names {
+		data, err := fs.ReadFile(f.fsys, name)
+		if err != nil {
+			return [sha256.Size]byte{}
+		}
+		fileSum := sha256.Sum256(data)
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write(fileSum[:])
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// LoadFile loads and executes the javascript file at filename. ScriptName is
+// set to filename and SourceMapURL to its source map, see SourceMapURL.
+// TypeScript and JSX files are transpiled if Config.TypeScript is set.
+func (w *Worker) LoadFile(filename string) error {
+	code, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if w.isTypeScript(filename) {
+		err = w.LoadTypeScript(&ScriptOrigin{ScriptName: filename}, string(code))
+	} else {
+		origin := &ScriptOrigin{
+			ScriptName:   filename,
+			SourceMapURL: SourceMapURL(string(code), filename, fileExists),
+		}
+		err = w.LoadWithOptions(origin, string(code))
+	}
+	if err != nil {
+		return err
+	}
+	w.addLoadedFile(loadedFile{name: filename})
+	return nil
+}
+
+// LoadFS loads and executes the files of fsys matching pattern (see fs.Glob)
+// in lexical order, stopping at the first error. ScriptName is set to the path
+// in fsys. Scripts can be shipped inside a binary with an embed.FS.
+func (w *Worker) LoadFS(fsys fs.FS, pattern string) error {
+	names, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.New("v8worker: no files match " + pattern)
+	}
+	for _, name := range names {
+		if err := w.loadFSFile(fsys, name); err != nil {
+			return err
+		}
+	}
+	w.addLoadedFile(loadedFile{fsys: fsys, name: pattern})
+	return nil
+}
+
+func (w *Worker) loadFSFile(fsys fs.FS, name string) error {
+	code, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if w.isTypeScript(name) {
+		return w.LoadTypeScript(&ScriptOrigin{ScriptName: name}, string(code))
+	}
+	exists := func(name string) bool {
+		info, err := fs.Stat(fsys, name)
+		return err == nil && !info.IsDir()
+	}
+	origin := &ScriptOrigin{
+		ScriptName:   name,
+		SourceMapURL: SourceMapURL(string(code), name, exists),
+	}
+	return w.LoadWithOptions(origin, string(code))
+}
+
+// load repeats the call of LoadFile or LoadFS into w. LoadFS matches the
+// pattern again.
+func (f loadedFile) load(w *Worker) error {
+	if f.fsys == nil {
+		return w.LoadFile(f.name)
+	}
+	return w.LoadFS(f.fsys, f.name)
+}
+
+// SourceMapURL returns the source map of the script named filename: the URL of
+// its last sourceMappingURL comment, which may be an inline data: URL, or else
+// filename + ".map" if exists reports that file exists. It returns "" if the
+// script has no source map.
+func SourceMapURL(code string, filename string, exists func(string) bool) string {
+	for end := len(code); end > 0; {
+		start := strings.LastIndex(code[:end], "\n") + 1
+		line := strings.TrimSpace(code[start:end])
+		end = start - 1
+		if line == "" {
+			continue
+		}
+		for _, prefix := range []string{"//# sourceMappingURL=", "//@ sourceMappingURL="} {
+			if strings.HasPrefix(line, prefix) {
+				return strings.TrimSpace(line[len(prefix):])
+			}
+		}
+		// only trailing comments may hold the source map
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+	}
+	if exists != nil && exists(filename+".map") {
+		// relative to the script
+		return path.Base(filepath.ToSlash(filename)) + ".map"
+	}
+	return ""
+}
+
+func (w *Worker) addLoadedFile(f loadedFile) {
+	w.filesLocker.Lock()
+	w.files = append(w.files, f)
+	w.filesLocker.Unlock()
+}
+
+// loadedFiles returns the successful calls of LoadFile and LoadFS, in order.
+func (w *Worker) loadedFiles() []loadedFile {
+	w.filesLocker.Lock()
+	defer w.filesLocker.Unlock()
+	files := make([]loadedFile, len(w.files))
+	copy(files, w.files)
+	return files
+}
+
+func fileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,60 @@
+package v8worker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "error.js")
+	if err := os.WriteFile(filename, []byte(`throw new Error("Error")`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	worker := New(func(msg string) {}, DiscardSendSync)
+	err := worker.LoadFile(filename)
+	if err == nil || !strings.Contains(err.Error(), filename+":1") {
+		t.Fatal("Expected error naming the file", err)
+	}
+}
+
+func TestLoadFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"scripts/b.js": {Data: []byte(`$send("b");`)},
+		"scripts/a.js": {Data: []byte(`$send("a");`)},
+		"scripts/c.md": {Data: []byte(`not javascript`)},
+	}
+	var caught []string
+	worker := New(func(msg string) {
+		caught = append(caught, msg)
+	}, DiscardSendSync)
+	if err := worker.LoadFS(fsys, "scripts/*.js"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caught, ",") != "a,b" {
+		t.Fatal("bad load order", caught)
+	}
+	if err := worker.LoadFS(fsys, "nothing/*.js"); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestSourceMapURL(t *testing.T) {
+	exists := func(name string) bool { return name == "lib/app.js.map" }
+	tests := []struct {
+		code, filename, expected string
+	}{
+		{"var a;\n//# sourceMappingURL=app.min.js.map\n", "lib/app.js", "app.min.js.map"},
+		{"var a;\n//@ sourceMappingURL=data:application/json;base64,e30=", "x.js", "data:application/json;base64,e30="},
+		{"var a;\n", "lib/app.js", "app.js.map"},
+		{"//# sourceMappingURL=early.map\nvar a;\n", "x.js", ""},
+	}
+	for _, test := range tests {
+		if got := SourceMapURL(test.code, test.filename, exists); got != test.expected {
+			t.Errorf("got %q want %q", got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package v8worker
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// msgpackCodec implements the MessagePack format, see
+// https://github.com/msgpack/msgpack/blob/master/spec.md. Extension types
+// are not supported.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (c msgpackCodec) Marshal(v interface{}) ([]byte, error) { return marshalBinary(c, v) }
+
+func (c msgpackCodec) Unmarshal(data []byte, v interface{}) error { return unmarshalBinary(c, data, v) }
+
+func (msgpackCodec) appendNil(b []byte) []byte { return append(b, 0xc0) }
+
+func (msgpackCodec) appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func (msgpackCodec) appendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(b, byte(i))
+	case i >= -32 && i < 0:
+		return append(b, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(b, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(i))
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+func (c msgpackCodec) appendUint(b []byte, u uint64) []byte {
+	if u <= math.MaxInt64 {
+		return c.appendInt(b, int64(u))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), u)
+}
+
+func (msgpackCodec) appendFloat(b []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+}
+
+func (msgpackCodec) appendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func (msgpackCodec) appendBytes(b []byte, s []byte) []byte {
+	n := len(s)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func (msgpackCodec) appendArrayHead(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func (msgpackCodec) appendMapHead(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+// msgpackSizes are the sizes of the length or value following the types which
+// have one.
+var msgpackSizes = map[byte]int{
+	0xc4: 1, 0xc5: 2, 0xc6: 4, // bin
+	0xca: 4, 0xcb: 8, // float
+	0xcc: 1, 0xcd: 2, 0xce: 4, 0xcf: 8, // uint
+	0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8, // int
+	0xd9: 1, 0xda: 2, 0xdb: 4, // str
+	0xdc: 2, 0xdd: 4, // array
+	0xde: 2, 0xdf: 4, // map
+}
+
+func (msgpackCodec) readItem(d *byteReader) (item, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return item{}, err
+	}
+	c := b[0]
+	switch {
+	case c < 0x80:
+		return item{kind: itemInt, i: int64(c)}, nil
+	case c < 0x90:
+		return d.head(itemMap, uint64(c&0x0f))
+	case c < 0xa0:
+		return d.head(itemArray, uint64(c&0x0f))
+	case c < 0xc0:
+		return d.str(itemString, uint64(c&0x1f))
+	case c >= 0xe0:
+		return item{kind: itemInt, i: int64(int8(c))}, nil
+	}
+
+	switch c {
+	case 0xc0:
+		return item{kind: itemNil}, nil
+	case 0xc2:
+		return item{kind: itemBool, b: false}, nil
+	case 0xc3:
+		return item{kind: itemBool, b: true}, nil
+	}
+	size, ok := msgpackSizes[c]
+	if !ok {
+		d.pos--
+		return item{}, d.errorf("unsupported type 0x" + strconv.FormatUint(uint64(c), 16))
+	}
+	n, err := d.uint(size)
+	if err != nil {
+		return item{}, err
+	}
+	switch c {
+	case 0xc4, 0xc5, 0xc6:
+		return d.str(itemBytes, n)
+	case 0xca:
+		return item{kind: itemFloat, f: float64(math.Float32frombits(uint32(n)))}, nil
+	case 0xcb:
+		return item{kind: itemFloat, f: math.Float64frombits(n)}, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return item{kind: itemUint, u: n}, nil
+	case 0xd0:
+		return item{kind: itemInt, i: int64(int8(n))}, nil
+	case 0xd1:
+		return item{kind: itemInt, i: int64(int16(n))}, nil
+	case 0xd2:
+		return item{kind: itemInt, i: int64(int32(n))}, nil
+	case 0xd3:
+		return item{kind: itemInt, i: int64(n)}, nil
+	case 0xd9, 0xda, 0xdb:
+		return d.str(itemString, n)
+	case 0xdc, 0xdd:
+		return d.head(itemArray, n)
+	}
+	return d.head(itemMap, n)
+}
+
+func (msgpackCodec) Script() string {
+	return `(function () {
+		function encode(w, v) {
+			if (v === null || v === undefined) return w.u8(0xc0);
+			switch (typeof v) {
+			case "boolean":
+				return w.u8(v ? 0xc3 : 0xc2);
+			case "number":
+				if (Math.floor(v) === v && Math.abs(v) <= 9007199254740991) {
+					if (v >= 0) {
+						if (v < 0x80) return w.u8(v);
+						if (v < 0x100) { w.u8(0xcc); return w.u8(v); }
+						if (v < 0x10000) { w.u8(0xcd); return w.u16(v); }
+						if (v < 0x100000000) { w.u8(0xce); return w.u32(v); }
+						w.u8(0xcf); w.u32(Math.floor(v / 0x100000000)); return w.u32(v >>> 0);
+					}
+					if (v >= -32) return w.u8(v & 0xff);
+					if (v >= -0x80) { w.u8(0xd0); return w.u8(v & 0xff); }
+					if (v >= -0x8000) { w.u8(0xd1); return w.u16(v & 0xffff); }
+					if (v >= -0x80000000) { w.u8(0xd2); return w.i32(v); }
+					w.u8(0xd3); w.i32(Math.floor(v / 0x100000000)); return w.u32(v >>> 0);
+				}
+				w.u8(0xcb);
+				return w.f64(v);
+			case "string":
+				var s = utf8Encode(v);
+				if (s.length < 32) w.u8(0xa0 | s.length);
+				else if (s.length < 0x100) { w.u8(0xd9); w.u8(s.length); }
+				else if (s.length < 0x10000) { w.u8(0xda); w.u16(s.length); }
+				else { w.u8(0xdb); w.u32(s.length); }
+				return w.bytes(s);
+			case "object":
+				if (v instanceof Uint8Array) {
+					if (v.length < 0x100) { w.u8(0xc4); w.u8(v.length); }
+					else if (v.length < 0x10000) { w.u8(0xc5); w.u16(v.length); }
+					else { w.u8(0xc6); w.u32(v.length); }
+					return w.bytes(v);
+				}
+				if (typeof v.toJSON === "function") return encode(w, v.toJSON());
+				if (Array.isArray(v)) {
+					if (v.length < 16) w.u8(0x90 | v.length);
+					else if (v.length < 0x10000) { w.u8(0xdc); w.u16(v.length); }
+					else { w.u8(0xdd); w.u32(v.length); }
+					for (var i = 0; i < v.length; i++) encode(w, v[i]);
+					return;
+				}
+				var keys = Object.keys(v).filter(function (k) {
+					return v[k] !== undefined && typeof v[k] !== "function";
+				});
+				if (keys.length < 16) w.u8(0x80 | keys.length);
+				else if (keys.length < 0x10000) { w.u8(0xde); w.u16(keys.length); }
+				else { w.u8(0xdf); w.u32(keys.length); }
+				keys.forEach(function (k) {
+					encode(w, k);
+					encode(w, v[k]);
+				});
+				return;
+			}
+			w.u8(0xc0);
+		}
+
+		function array(r, n) {
+			var a = new Array(n);
+			for (var i = 0; i < n; i++) a[i] = decode(r);
+			return a;
+		}
+
+		function map(r, n) {
+			var m = {};
+			for (var i = 0; i < n; i++) {
+				var k = decode(r);
+				m[k] = decode(r);
+			}
+			return m;
+		}
+
+		function decode(r) {
+			var c = r.u8();
+			if (c < 0x80) return c;
+			if (c < 0x90) return map(r, c & 0x0f);
+			if (c < 0xa0) return array(r, c & 0x0f);
+			if (c < 0xc0) return utf8Decode(r.bytes(c & 0x1f));
+			if (c >= 0xe0) return c - 0x100;
+			switch (c) {
+			case 0xc0: return null;
+			case 0xc2: return false;
+			case 0xc3: return true;
+			case 0xc4: return new Uint8Array(r.bytes(r.u8()));
+			case 0xc5: return new Uint8Array(r.bytes(r.u16()));
+			case 0xc6: return new Uint8Array(r.bytes(r.u32()));
+			case 0xca: return r.f32();
+			case 0xcb: return r.f64();
+			case 0xcc: return r.u8();
+			case 0xcd: return r.u16();
+			case 0xce: return r.u32();
+			case 0xcf: return r.u32() * 0x100000000 + r.u32();
+			case 0xd0: return r.i8();
+			case 0xd1: return r.i16();
+			case 0xd2: return r.i32();
+			case 0xd3: return r.i32() * 0x100000000 + r.u32();
+			case 0xd9: return utf8Decode(r.bytes(r.u8()));
+			case 0xda: return utf8Decode(r.bytes(r.u16()));
+			case 0xdb: return utf8Decode(r.bytes(r.u32()));
+			case 0xdc: return array(r, r.u16());
+			case 0xdd: return array(r, r.u32());
+			case 0xde: return map(r, r.u16());
+			case 0xdf: return map(r, r.u32());
+			}
+			throw new Error("msgpack: unsupported type 0x" + c.toString(16));
+		}
+
+		return {
+			encode: function (v) {
+				var w = new Writer();
+				encode(w, v);
+				return w.result();
+			},
+			decode: function (b) {
+				var r = new Reader(b);
+				var v = decode(r);
+				if (r.pos !== b.length) throw new Error("msgpack: trailing data");
+				return v;
+			}
+		};
+	})()`
+}
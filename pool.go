@@ -0,0 +1,317 @@
+package v8worker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Script is a javascript source and its ScriptOrigin.
+type Script struct {
+	Origin ScriptOrigin
+	Code   string
+}
+
+// Pool is a fixed size set of workers running the same scripts. Each call
+// runs on an idle worker, waiting for one if all of them are busy.
+type Pool struct {
+	// HealthCheck verifies a worker built by Deploy before it is swapped in.
+	// If nil, a worker is healthy once its scripts are loaded.
+	HealthCheck func(w *Worker) error
+
+	newWorker func() *Worker
+	size      int
+	idle      chan *poolSlot
+
+	deployLocker sync.Mutex
+	version      string
+}
+
+type poolSlot struct {
+	index   int
+	worker  *Worker
+	version string
+}
+
+// PoolError collects the errors of a call made on several workers of a pool.
+type PoolError struct {
+	// Errors is indexed like the workers of the pool (or the inputs of Map),
+	// nil entries succeeded.
+	Errors []error
+}
+
+func (e *PoolError) Error() string {
+	var msgs []string
+	for i, err := range e.Errors {
+		if err != nil {
+			msgs = append(msgs, strconv.Itoa(i)+": "+err.Error())
+		}
+	}
+	return "v8worker: " + strconv.Itoa(len(msgs)) + " of " + strconv.Itoa(len(e.Errors)) + " calls failed: " + strings.Join(msgs, "; ")
+}
+
+// NewPool creates a pool of size workers returned by newWorker, which must
+// set the callbacks used by every worker of the pool.
+func NewPool(size int, newWorker func() *Worker) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	p := &Pool{
+		newWorker: newWorker,
+		size:      size,
+		idle:      make(chan *poolSlot, size),
+	}
+	for i := 0; i < size; i++ {
+		p.idle <- &poolSlot{index: i, worker: newWorker()}
+	}
+	return p
+}
+
+// Size returns the number of workers in the pool.
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Version returns the version of the scripts last deployed.
+func (p *Pool) Version() string {
+	p.deployLocker.Lock()
+	defer p.deployLocker.Unlock()
+	return p.version
+}
+
+// Do runs fn with an idle worker, which is reserved until fn returns.
+func (p *Pool) Do(fn func(w *Worker) error) error {
+	return p.DoContext(context.Background(), fn)
+}
+
+// DoContext runs fn with an idle worker like Do, giving up with ctx.Err() if
+// ctx is done before a worker is idle.
+func (p *Pool) DoContext(ctx context.Context, fn func(w *Worker) error) error {
+	var s *poolSlot
+	select {
+	case s = <-p.idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { p.idle <- s }()
+	return fn(s.worker)
+}
+
+// Broadcast sends msg to every worker of the pool with Send, as they become
+// idle. It returns a *PoolError if any of them failed. Since it waits for
+// every worker, it must not be called from a Do callback, or it never returns.
+func (p *Pool) Broadcast(msg string) error {
+	errs := make([]error, p.size)
+	failed := false
+	p.eachSlot(func(s *poolSlot) bool {
+		if err := s.worker.Send(msg); err != nil {
+			errs[s.index] = err
+			failed = true
+		}
+		return true
+	})
+	if failed {
+		return &PoolError{Errors: errs}
+	}
+	return nil
+}
+
+// Map sends every input with SendSync to the workers of the pool in parallel
+// and returns the responses in the order of inputs. Responses starting with
+// "err: " are errors. Once ctx is done or a call failed, no more inputs are
+// sent and the javascript still running is terminated; the error is ctx.Err()
+// or a *PoolError.
+func (p *Pool) Map(ctx context.Context, inputs []string) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]string, len(inputs))
+	errs := make([]error, len(inputs))
+	var errsLocker sync.Mutex
+	failed := false
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range inputs {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for n := 0; n < p.size && n < len(inputs); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := p.DoContext(ctx, func(w *Worker) error {
+					stop := terminateOnDone(ctx, w)
+					res := w.SendSync(inputs[i])
+					stop()
+					if strings.HasPrefix(res, "err: ") {
+						return errors.New(res)
+					}
+					results[i] = res
+					return nil
+				})
+				if err != nil && ctx.Err() == nil {
+					errsLocker.Lock()
+					errs[i] = err
+					failed = true
+					errsLocker.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed {
+		return nil, &PoolError{Errors: errs}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// terminateOnDone terminates the javascript running on w once ctx is done.
+// The returned function stops watching and must be called when the call on w
+// has returned.
+func terminateOnDone(ctx context.Context, w *Worker) func() {
+	done := make(chan struct{})
+	fired := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.Terminate(ctx.Err())
+			fired <- true
+		case <-done:
+			fired <- false
+		}
+	}()
+	return func() {
+		close(done)
+		if <-fired && !w.lastTerminated() {
+			w.cancelTermination()
+		}
+	}
+}
+
+// Send sends a message to an idle worker. The $recv callback in js will be called.
+func (p *Pool) Send(msg string) error {
+	return p.Do(func(w *Worker) error {
+		return w.Send(msg)
+	})
+}
+
+// SendSync sends a message to an idle worker. The $recvSync callback in js will be called.
+func (p *Pool) SendSync(msg string) string {
+	var res string
+	p.Do(func(w *Worker) error {
+		res = w.SendSync(msg)
+		return nil
+	})
+	return res
+}
+
+// Deploy replaces the workers of the pool, one at a time, with new workers
+// running scripts. Each new worker is verified with HealthCheck before it is
+// swapped in; calls in flight on the old worker finish first. If loading or
+// checking a new worker fails, the workers already swapped are rolled back to
+// the previous version and the error is returned. Like Broadcast, it waits for
+// every worker and must not be called from a Do callback.
+func (p *Pool) Deploy(version string, scripts []Script) error {
+	p.deployLocker.Lock()
+	defer p.deployLocker.Unlock()
+
+	swapped := make(map[*poolSlot]poolSlot)
+	var err error
+	p.eachSlot(func(s *poolSlot) bool {
+		var w *Worker
+		w, err = p.build(scripts)
+		if err != nil {
+			return false
+		}
+		swapped[s] = *s
+		s.worker, s.version = w, version
+		return true
+	})
+	if err != nil {
+		p.eachSlot(func(s *poolSlot) bool {
+			if old, ok := swapped[s]; ok {
+				*s = old
+			}
+			return true
+		})
+		return errors.New("v8worker: deploy of version " + version + " failed: " + err.Error())
+	}
+
+	p.version = version
+	return nil
+}
+
+// eachSlot calls fn with every slot of the pool once, as they become idle,
+// until fn returns false. It blocks forever if the caller holds a slot.
+func (p *Pool) eachSlot(fn func(s *poolSlot) bool) {
+	seen := make(map[*poolSlot]bool)
+	var held []*poolSlot
+	defer func() {
+		for _, s := range held {
+			p.idle <- s
+		}
+	}()
+	for len(seen) < p.size {
+		s := <-p.idle
+		if seen[s] {
+			// keep it until an unseen slot is idle instead of spinning on it
+			held = append(held, s)
+			continue
+		}
+		seen[s] = true
+		ok := fn(s)
+		p.idle <- s
+		for _, h := range held {
+			p.idle <- h
+		}
+		held = held[:0]
+		if !ok {
+			return
+		}
+	}
+}
+
+// build creates a worker running scripts and checks its health.
+func (p *Pool) build(scripts []Script) (*Worker, error) {
+	w := p.newWorker()
+	for i := range scripts {
+		origin := scripts[i].Origin
+		if err := w.LoadWithOptions(&origin, scripts[i].Code); err != nil {
+			return nil, err
+		}
+	}
+	if p.HealthCheck != nil {
+		if err := p.HealthCheck(w); err != nil {
+			return nil, errors.New("health check: " + err.Error())
+		}
+	}
+	return w, nil
+}
+
+// SendSyncHealthCheck returns a HealthCheck which sends msg with SendSync and
+// expects a response not starting with "err: ".
+func SendSyncHealthCheck(msg string) func(w *Worker) error {
+	return func(w *Worker) error {
+		res := w.SendSync(msg)
+		if strings.HasPrefix(res, "err: ") {
+			return errors.New(res)
+		}
+		return nil
+	}
+}
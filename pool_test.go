@@ -0,0 +1,126 @@
+package v8worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolDeploy(t *testing.T) {
+	pool := NewPool(4, func() *Worker {
+		return New(func(msg string) {}, DiscardSendSync)
+	})
+	pool.HealthCheck = SendSyncHealthCheck("health")
+
+	v1 := []Script{{Origin: ScriptOrigin{ScriptName: "app.js"}, Code: `$recvSync(function(msg) { return "v1"; });`}}
+	if err := pool.Deploy("1", v1); err != nil {
+		t.Fatal(err)
+	}
+
+	// calls keep being served while v2 is deployed
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if res := pool.SendSync("version"); res != "v1" && res != "v2" {
+				t.Error("bad response during deploy", res)
+				return
+			}
+		}
+	}()
+	v2 := []Script{{Origin: ScriptOrigin{ScriptName: "app.js"}, Code: `$recvSync(function(msg) { return "v2"; });`}}
+	if err := pool.Deploy("2", v2); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	wg.Wait()
+	for i := 0; i < pool.Size(); i++ {
+		if got, want := pool.SendSync("version"), "v2"; got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+
+	// a failing health check rolls back
+	pool.HealthCheck = func(w *Worker) error {
+		if w.SendSync("version") != "v3" {
+			return errors.New("unhealthy")
+		}
+		return nil
+	}
+	v3 := []Script{{Origin: ScriptOrigin{ScriptName: "app.js"}, Code: `$recvSync(function(msg) { return "broken"; });`}}
+	if err := pool.Deploy("3", v3); err == nil {
+		t.Fatal("Expected deploy error")
+	}
+	if got, want := pool.Version(), "2"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	for i := 0; i < pool.Size(); i++ {
+		if got, want := pool.SendSync("version"), "v2"; got != want {
+			t.Errorf("got %q want %q", got, want)
+		}
+	}
+}
+
+func TestPoolBroadcastAndMap(t *testing.T) {
+	var recvLocker sync.Mutex
+	recvCount := 0
+	pool := NewPool(3, func() *Worker {
+		return New(func(msg string) {
+			recvLocker.Lock()
+			recvCount++
+			recvLocker.Unlock()
+		}, DiscardSendSync)
+	})
+	scripts := []Script{{Origin: ScriptOrigin{ScriptName: "app.js"}, Code: `
+		$recv(function(msg) { $send("invalidated " + msg); });
+		$recvSync(function(msg) {
+			if (msg === "fail") throw new Error("failed");
+			if (msg === "spin") while (true) { ; }
+			return msg.toUpperCase();
+		});
+	`}}
+	if err := pool.Deploy("1", scripts); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Broadcast("cache"); err != nil {
+		t.Fatal(err)
+	}
+	if recvCount != 3 {
+		t.Fatal("bad recvCount", recvCount)
+	}
+
+	results, err := pool.Map(context.Background(), []string{"a", "b", "c", "d", "e"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(results, "") != "ABCDE" {
+		t.Fatal("bad results", results)
+	}
+
+	_, err = pool.Map(context.Background(), []string{"a", "fail", "c"})
+	var poolErr *PoolError
+	if !errors.As(err, &poolErr) || poolErr.Errors[1] == nil {
+		t.Fatal("Expected PoolError for input 1", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Map(ctx, []string{"spin", "spin", "spin", "spin"}); err != context.DeadlineExceeded {
+		t.Fatal("Expected context.DeadlineExceeded", err)
+	}
+	// workers are usable after cancellation
+	if got, want := pool.SendSync("ok"), "OK"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package v8worker
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// Reloader is a development helper which keeps a worker running the files it
+// loaded with LoadFile and LoadFS, and replaces it with a fresh worker loading
+// the same files again whenever one of them changes. Files are polled, so it
+// works on any OS and any fs.FS. LoadFS patterns are matched again on every
+// poll, so files added or removed count as changes.
+//
+// Only successful LoadFile and LoadFS calls are replayed, in their order.
+// Everything else the worker was set up with, such as scripts loaded with Load
+// or LoadWithOptions, EnableRequire, SetCodec and EnableRPC, is up to the
+// newWorker function given to NewReloader.
+//
+// Scripts registering $recv and $recvSync handlers register them again in the
+// new worker. If the new worker fails to load, the error is reported and the
+// old worker is kept.
+type Reloader struct {
+	newWorker func() *Worker
+	onError   func(err error)
+
+	locker sync.Mutex
+	worker *Worker
+	files  []loadedFile
+	sums   [][sha256.Size]byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReloader watches the files loaded so far by w. newWorker must return a
+// new worker set up like w was before its first LoadFile or LoadFS call: with
+// the same callbacks and the same bootstrap, e.g. by being the function which
+// created w. The files are loaded into it on every change. onError, which may
+// be nil, is called with load errors.
+func NewReloader(w *Worker, newWorker func() *Worker, onError func(err error)) *Reloader {
+	r := &Reloader{
+		newWorker: newWorker,
+		onError:   onError,
+		worker:    w,
+		files:     w.loadedFiles(),
+	}
+	r.sums = r.checksums()
+	return r
+}
+
+// Worker returns the current worker. Callers should ask for it on every use
+// instead of keeping it.
+func (r *Reloader) Worker() *Worker {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return r.worker
+}
+
+// Check polls the files once and rebuilds the worker if one of them changed.
+// It reports whether the worker was replaced.
+func (r *Reloader) Check() (bool, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+
+	sums := r.checksums()
+	changed := false
+	for i := range sums {
+		if sums[i] != r.sums[i] {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	// don't report the same broken version again
+	r.sums = sums
+
+	w := r.newWorker()
+	for _, f := range r.files {
+		if err := f.load(w); err != nil {
+			if r.onError != nil {
+				r.onError(err)
+			}
+			return false, err
+		}
+	}
+	r.worker = w
+	return true, nil
+}
+
+// Watch polls the files every interval until Stop is called.
+func (r *Reloader) Watch(interval time.Duration) {
+	r.locker.Lock()
+	if r.stop != nil {
+		r.locker.Unlock()
+		return
+	}
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	stop, done := r.stop, r.done
+	r.locker.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Check()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops watching.
+func (r *Reloader) Stop() {
+	r.locker.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.locker.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// checksums hashes the files. Unreadable files hash to zero, so they are
+// reloaded once readable again.
+func (r *Reloader) checksums() [][sha256.Size]byte {
+	sums := make([][sha256.Size]byte, len(r.files))
+	for i, f := range r.files {
+		sums[i] = f.sum()
+	}
+	return sums
+}
@@ -0,0 +1,100 @@
+package v8worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "handler.js")
+	write := func(code string) {
+		if err := os.WriteFile(filename, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newWorker := func() *Worker {
+		return New(func(msg string) {}, DiscardSendSync)
+	}
+
+	write(`$recvSync(function(msg) { return "v1"; });`)
+	worker := newWorker()
+	if err := worker.LoadFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	var loadErr error
+	r := NewReloader(worker, newWorker, func(err error) { loadErr = err })
+	if reloaded, _ := r.Check(); reloaded {
+		t.Fatal("Expected no reload without changes")
+	}
+
+	write(`$recvSync(function(msg) { return "v2"; });`)
+	if reloaded, err := r.Check(); !reloaded || err != nil {
+		t.Fatal("Expected reload", err)
+	}
+	if got, want := r.Worker().SendSync("version"), "v2"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+
+	// a broken version keeps the old worker
+	write(`$recvSync(function(msg) { return "v3" `)
+	if reloaded, err := r.Check(); reloaded || err == nil || loadErr == nil {
+		t.Fatal("Expected load error", err)
+	}
+	if got, want := r.Worker().SendSync("version"), "v2"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestReloaderLoadFS(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, code string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// bootstrap outside LoadFile and LoadFS is redone by newWorker
+	newWorker := func() *Worker {
+		w := New(func(msg string) {}, DiscardSendSync)
+		if err := w.Load("bootstrap.js", `var parts = [];`); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	write("a.js", `parts.push("a");`)
+	write("z.js", `$recvSync(function(msg) { return parts.join(","); });`)
+	worker := newWorker()
+	// a failed load isn't replayed
+	if err := worker.LoadFile(filepath.Join(dir, "missing.js")); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err := worker.LoadFS(os.DirFS(dir), "*.js"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReloader(worker, newWorker, nil)
+	if reloaded, _ := r.Check(); reloaded {
+		t.Fatal("Expected no reload without changes")
+	}
+
+	// a new file matching the pattern is picked up
+	write("b.js", `parts.push("b");`)
+	if reloaded, err := r.Check(); !reloaded || err != nil {
+		t.Fatal("Expected reload", err)
+	}
+	if got, want := r.Worker().SendSync(""), "a,b"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.js")); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := r.Check(); !reloaded || err != nil {
+		t.Fatal("Expected reload", err)
+	}
+	if got, want := r.Worker().SendSync(""), "b"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
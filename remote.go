@@ -0,0 +1,360 @@
+package v8worker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// DefaultHostPath is the name of the host binary started by NewRemote when no
+// path is given. It is looked up in $PATH.
+const DefaultHostPath = "v8worker-host"
+
+// ErrWorkerCrashed is returned by RemoteWorker calls which were in flight when
+// the host process died.
+var ErrWorkerCrashed = errors.New("v8worker: remote worker crashed")
+
+// CrashReport describes the death of a RemoteWorker host process.
+type CrashReport struct {
+	Time     time.Time
+	Err      error  // exit status of the host process
+	Stderr   string // last bytes written to stderr, usually the V8 fatal error
+	Restarts int    // number of times the host has been restarted so far
+}
+
+// To be notified when a remote worker host process dies.
+type CrashCallback func(report *CrashReport)
+
+// RemoteWorker has the same methods as Worker but runs the V8 isolate in a
+// child process, so a fatal error or OOM in V8 only kills the child. The child
+// is restarted on the next call and every script successfully loaded before
+// the crash is loaded again.
+type RemoteWorker struct {
+	hostPath string
+	cb       ReceiveMessageCallback
+	syncCB   ReceiveSyncMessageCallback
+	crashCB  CrashCallback
+
+	callLocker sync.Mutex // serializes calls, like the Locker of an isolate
+	scripts    []loadRequest
+	requestId  uint32
+
+	procLocker sync.Mutex
+	proc       *remoteProcess
+	restarts   int
+	closed     bool
+}
+
+type loadRequest struct {
+	Origin *ScriptOrigin
+	Code   string
+}
+
+type remoteProcess struct {
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	writeLocker sync.Mutex
+	stderr      *tailBuffer
+	replies     chan frame
+	done        chan struct{}
+	err         error
+}
+
+// NewRemote creates a new worker running in a child process started from the
+// binary at hostPath (see cmd/v8worker-host). crashCB may be nil.
+func NewRemote(hostPath string, cb ReceiveMessageCallback, syncCB ReceiveSyncMessageCallback, crashCB CrashCallback) (*RemoteWorker, error) {
+	if hostPath == "" {
+		hostPath = DefaultHostPath
+	}
+	w := &RemoteWorker{
+		hostPath: hostPath,
+		cb:       cb,
+		syncCB:   syncCB,
+		crashCB:  crashCB,
+	}
+	if _, err := w.process(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Load loads and executes a javascript file with the filename specified by
+// scriptName and the contents of the file specified by the param code.
+func (w *RemoteWorker) Load(scriptName string, code string) error {
+	return w.LoadWithOptions(&ScriptOrigin{ScriptName: scriptName}, code)
+}
+
+// LoadWithOptions loads and executes a javascript file with the ScriptOrigin specified by
+// origin and the contents of the file specified by the param code.
+func (w *RemoteWorker) LoadWithOptions(origin *ScriptOrigin, code string) error {
+	if origin == nil {
+		origin = new(ScriptOrigin)
+	}
+	if origin.ScriptName == "" {
+		origin.ScriptName = nextScriptName()
+	}
+	req := loadRequest{Origin: origin, Code: code}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	w.callLocker.Lock()
+	defer w.callLocker.Unlock()
+	if _, err := w.call(frameLoad, payload); err != nil {
+		return err
+	}
+	w.scripts = append(w.scripts, req)
+	return nil
+}
+
+// Send sends a message to a worker. The $recv callback in js will be called.
+func (w *RemoteWorker) Send(msg string) error {
+	w.callLocker.Lock()
+	defer w.callLocker.Unlock()
+	_, err := w.call(frameSend, []byte(msg))
+	return err
+}
+
+// SendSync sends a message to a worker. The $recvSync callback in js will be called.
+// If the host process fails the returned string starts with "err: ", like the
+// errors reported by Worker.SendSync.
+func (w *RemoteWorker) SendSync(msg string) string {
+	w.callLocker.Lock()
+	defer w.callLocker.Unlock()
+	res, err := w.call(frameSendSync, []byte(msg))
+	if err != nil {
+		return "err: " + err.Error()
+	}
+	return string(res)
+}
+
+// TerminateExecution terminates execution of javascript in the host process.
+func (w *RemoteWorker) TerminateExecution() {
+	w.procLocker.Lock()
+	p := w.proc
+	w.procLocker.Unlock()
+	if p != nil {
+		p.write(frame{kind: frameTerminate})
+	}
+}
+
+// GetHeapStatistics returns statistics about the V8 isolate heap memory usage
+// of the host process, or nil if the host could not be reached.
+func (w *RemoteWorker) GetHeapStatistics() *HeapStatistics {
+	w.callLocker.Lock()
+	defer w.callLocker.Unlock()
+	res, err := w.call(frameHeapStatistics, nil)
+	if err != nil {
+		return nil
+	}
+	hs := new(HeapStatistics)
+	if err := json.Unmarshal(res, hs); err != nil {
+		return nil
+	}
+	return hs
+}
+
+// Close kills the host process. The worker can't be used afterwards.
+func (w *RemoteWorker) Close() error {
+	w.procLocker.Lock()
+	w.closed = true
+	p := w.proc
+	w.proc = nil
+	w.procLocker.Unlock()
+	if p != nil {
+		p.kill()
+	}
+	return nil
+}
+
+// call must be called with callLocker held.
+func (w *RemoteWorker) call(kind byte, payload []byte) ([]byte, error) {
+	p, err := w.process()
+	if err != nil {
+		return nil, err
+	}
+	return w.roundTrip(p, kind, payload)
+}
+
+// roundTrip sends a request to p and waits for its reply. It must be called
+// with callLocker held.
+func (w *RemoteWorker) roundTrip(p *remoteProcess, kind byte, payload []byte) ([]byte, error) {
+	w.requestId++
+	id := w.requestId
+	if err := p.write(frame{kind: kind, id: id, payload: payload}); err != nil {
+		<-p.done
+		return nil, ErrWorkerCrashed
+	}
+	for {
+		select {
+		case f := <-p.replies:
+			if f.id != id {
+				continue
+			}
+			if f.kind == frameError {
+				return nil, errors.New(string(f.payload))
+			}
+			return f.payload, nil
+		case <-p.done:
+			return nil, ErrWorkerCrashed
+		}
+	}
+}
+
+// process returns the running host process, starting a new one and replaying
+// loaded scripts if the previous one died. If a script fails to load again the
+// new process is killed, so that it never serves calls half loaded, and the
+// error is returned; the next call starts over. It must be called with
+// callLocker held, except from NewRemote.
+func (w *RemoteWorker) process() (*remoteProcess, error) {
+	w.procLocker.Lock()
+	if w.closed {
+		w.procLocker.Unlock()
+		return nil, errors.New("v8worker: remote worker closed")
+	}
+	p := w.proc
+	if p != nil {
+		select {
+		case <-p.done:
+			p = nil
+			w.restarts++
+		default:
+		}
+	}
+	if p != nil {
+		w.procLocker.Unlock()
+		return p, nil
+	}
+	p, err := w.start()
+	if err != nil {
+		w.procLocker.Unlock()
+		return nil, err
+	}
+	w.proc = p
+	w.procLocker.Unlock()
+
+	for _, req := range w.scripts {
+		payload, err := json.Marshal(req)
+		if err == nil {
+			_, err = w.roundTrip(p, frameLoad, payload)
+		}
+		if err != nil {
+			w.procLocker.Lock()
+			if w.proc == p {
+				w.proc = nil
+			}
+			w.procLocker.Unlock()
+			p.kill()
+			return nil, errors.New("v8worker: reloading " + req.Origin.ScriptName + " after a restart: " + err.Error())
+		}
+	}
+	return p, nil
+}
+
+func (w *RemoteWorker) start() (*remoteProcess, error) {
+	cmd := exec.Command(w.hostPath)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	p := &remoteProcess{
+		cmd:     cmd,
+		stdin:   stdin,
+		stderr:  &tailBuffer{max: 4096},
+		replies: make(chan frame, 1),
+		done:    make(chan struct{}),
+	}
+	cmd.Stderr = p.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go w.readLoop(p, bufio.NewReader(stdout))
+	return p, nil
+}
+
+// readLoop dispatches frames written by the host until it exits.
+func (w *RemoteWorker) readLoop(p *remoteProcess, r io.Reader) {
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			break
+		}
+		switch f.kind {
+		case frameRecv:
+			w.cb(string(f.payload))
+		case frameRecvSync:
+			res := w.syncCB(string(f.payload))
+			p.write(frame{kind: frameResult, id: f.id, payload: []byte(res)})
+		case frameResult, frameError:
+			p.replies <- f
+		}
+	}
+	p.err = p.cmd.Wait()
+	close(p.done)
+
+	w.procLocker.Lock()
+	closed := w.closed || w.proc != p
+	restarts := w.restarts
+	w.procLocker.Unlock()
+	if !closed && w.crashCB != nil {
+		w.crashCB(&CrashReport{
+			Time:     time.Now(),
+			Err:      p.err,
+			Stderr:   p.stderr.String(),
+			Restarts: restarts,
+		})
+	}
+}
+
+func (p *remoteProcess) write(f frame) error {
+	p.writeLocker.Lock()
+	defer p.writeLocker.Unlock()
+	return writeFrame(p.stdin, f)
+}
+
+func (p *remoteProcess) kill() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	<-p.done
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	b.Unlock()
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return string(b.buf)
+}
+
+// ServeHost runs a worker driven by frames read from r and writes its replies
+// and messages to w. It is the child side of a RemoteWorker and returns when r
+// is closed.
+func ServeHost(r io.Reader, w io.Writer) error {
+	s := newFrameServer(r, w)
+	s.worker = New(s.recv, s.recvSync)
+	return s.serve()
+}
@@ -0,0 +1,148 @@
+package v8worker
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	buildHostOnce sync.Once
+	hostPath      string
+	hostBuildErr  error
+)
+
+func buildHost(t *testing.T) string {
+	buildHostOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "v8worker-host")
+		if err != nil {
+			hostBuildErr = err
+			return
+		}
+		hostPath = filepath.Join(dir, "v8worker-host")
+		out, err := exec.Command("go", "build", "-o", hostPath, "./cmd/v8worker-host").CombinedOutput()
+		if err != nil {
+			hostBuildErr = err
+			println(string(out))
+		}
+	})
+	if hostBuildErr != nil {
+		t.Fatal(hostBuildErr)
+	}
+	return hostPath
+}
+
+func TestRemoteWorker(t *testing.T) {
+	var recvMu sync.Mutex
+	var recv []string
+	worker, err := NewRemote(buildHost(t), func(msg string) {
+		recvMu.Lock()
+		recv = append(recv, msg)
+		recvMu.Unlock()
+	}, func(msg string) string {
+		return msg + " exchanged"
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer worker.Close()
+
+	err = worker.Load("code.js", `
+		$recv(function(msg) { $send("got " + msg); });
+		$recvSync(function(msg) { return $sendSync(msg); });
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.Send("hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := worker.SendSync("ping"), "ping exchanged"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+	recvMu.Lock()
+	if len(recv) != 1 || recv[0] != "got hi" {
+		t.Errorf("bad recv %v", recv)
+	}
+	recvMu.Unlock()
+
+	err = worker.Load("error.js", `throw new Error("remote")`)
+	if err == nil || !strings.Contains(err.Error(), "error.js:1") {
+		t.Fatal("Expected error", err)
+	}
+	if worker.GetHeapStatistics() == nil {
+		t.Fatal("Expected heap statistics")
+	}
+}
+
+func TestRemoteWorkerRestart(t *testing.T) {
+	crashes := make(chan *CrashReport, 1)
+	worker, err := NewRemote(buildHost(t), func(msg string) {}, DiscardSendSync, func(report *CrashReport) {
+		crashes <- report
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer worker.Close()
+
+	err = worker.Load("code.js", `$recvSync(function(msg) { return "alive"; });`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker.procLocker.Lock()
+	worker.proc.cmd.Process.Kill()
+	worker.procLocker.Unlock()
+	report := <-crashes
+	if report.Err == nil {
+		t.Fatal("Expected exit error in crash report")
+	}
+
+	// the host is restarted and code.js is loaded again
+	if got, want := worker.SendSync("ping"), "alive"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func TestRemoteWorkerReplayError(t *testing.T) {
+	crashes := make(chan *CrashReport, 1)
+	var loads int
+	worker, err := NewRemote(buildHost(t), func(msg string) {}, func(msg string) string {
+		loads++
+		if loads == 1 {
+			return "ok"
+		}
+		return "broken"
+	}, func(report *CrashReport) {
+		crashes <- report
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer worker.Close()
+
+	// code.js only loads the first time
+	err = worker.Load("code.js", `
+		if ($sendSync("load") !== "ok") throw new Error("broken dependency");
+		$recvSync(function(msg) { return "alive"; });
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker.procLocker.Lock()
+	worker.proc.cmd.Process.Kill()
+	worker.procLocker.Unlock()
+	<-crashes
+
+	// the failed replay is reported instead of serving a half loaded worker
+	for i := 0; i < 2; i++ {
+		got := worker.SendSync("ping")
+		if !strings.HasPrefix(got, "err: ") || !strings.Contains(got, "reloading code.js") || !strings.Contains(got, "broken dependency") {
+			t.Fatal("Expected replay error", got)
+		}
+	}
+}
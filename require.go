@@ -0,0 +1,410 @@
+package v8worker
+
+/*
+#include <stdlib.h>
+#include "binding.h"
+*/
+import "C"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// requireScript defines the global require on top of the $requireResolve and
+// $requireLoad natives. Modules are cached per worker by filename.
+const requireScript = `(function (global) {
+	var cache = {};
+	function makeRequire(parent) {
+		function require(specifier) {
+			var filename = parent === undefined ? $requireResolve(String(specifier)) : $requireResolve(String(specifier), parent);
+			var cached = cache[filename];
+			if (cached) {
+				return cached.exports;
+			}
+			var module = { id: filename, filename: filename, exports: {}, loaded: false };
+			cache[filename] = module;
+			try {
+				var loaded = $requireLoad(filename);
+				if (/\.json$/.test(filename)) {
+					module.exports = loaded;
+				} else {
+					var dirname = filename.lastIndexOf("/") < 0 ? "." : filename.slice(0, filename.lastIndexOf("/"));
+					loaded.call(module.exports, module.exports, makeRequire(filename), module, filename, dirname);
+				}
+			} catch (e) {
+				delete cache[filename];
+				throw e;
+			}
+			module.loaded = true;
+			return module.exports;
+		}
+		require.cache = cache;
+		require.resolve = function (specifier) {
+			return parent === undefined ? $requireResolve(String(specifier)) : $requireResolve(String(specifier), parent);
+		};
+		return require;
+	}
+	global.require = makeRequire(undefined);
+})(this);
+`
+
+// requireResolver implements Node's module resolution over an fs.FS.
+type requireResolver struct {
+	fsys      fs.FS
+	importMap *ImportMap // see Config.ImportMap
+
+	sync.Mutex
+	resolved map[string]string
+	packages map[string]*packageJSON
+}
+
+type packageJSON struct {
+	Main    string          `json:"main"`
+	Exports json.RawMessage `json:"exports"`
+}
+
+// EnableRequire defines a CommonJS require() in the worker which loads modules
+// from fsys. Specifiers are resolved with Node's algorithm: relative paths,
+// node_modules directories, package.json "main" and "exports", and .json
+// files. Scripts loaded with Load resolve relative paths against the directory
+// of their ScriptName. Config.ImportMap is applied before this resolution.
+func (w *Worker) EnableRequire(fsys fs.FS) error {
+	callbacksMapLocker.Lock()
+	callbacksMap[w.id].require = &requireResolver{
+		fsys:      fsys,
+		importMap: w.importMap,
+		resolved:  make(map[string]string),
+		packages:  make(map[string]*packageJSON),
+	}
+	callbacksMapLocker.Unlock()
+	return w.Load("v8worker:require.js", requireScript)
+}
+
+func lookupRequireResolver(workerId int) *requireResolver {
+	callbacksMapLocker.RLock()
+	defer callbacksMapLocker.RUnlock()
+	if cbs := callbacksMap[workerId]; cbs != nil {
+		return cbs.require
+	}
+	return nil
+}
+
+//export requireResolveCb
+func requireResolveCb(workerId int, specifier_s *C.char, parent_s *C.char, result **C.char) C.int {
+	r := lookupRequireResolver(workerId)
+	if r == nil {
+		*result = C.CString("require is not enabled, see Worker.EnableRequire")
+		return 1
+	}
+	filename, err := r.resolve(C.GoString(specifier_s), C.GoString(parent_s))
+	if err != nil {
+		*result = C.CString(err.Error())
+		return 1
+	}
+	*result = C.CString(filename)
+	return 0
+}
+
+//export requireReadCb
+func requireReadCb(workerId int, filename_s *C.char, result **C.char) C.int {
+	r := lookupRequireResolver(workerId)
+	if r == nil {
+		*result = C.CString("require is not enabled, see Worker.EnableRequire")
+		return 1
+	}
+	data, err := fs.ReadFile(r.fsys, C.GoString(filename_s))
+	if err != nil {
+		*result = C.CString(err.Error())
+		return 1
+	}
+	*result = (*C.char)(C.CBytes(append(data, 0)))
+	return 0
+}
+
+// resolve returns the filename in fsys of the module specifier required from
+// the script named parent.
+func (r *requireResolver) resolve(specifier, parent string) (string, error) {
+	unmapped := false
+	if r.importMap != nil {
+		mapped, ok, err := r.importMap.resolve(specifier, parent)
+		if err != nil {
+			return "", errors.New(err.Error() + " (required from '" + parent + "')")
+		}
+		if ok {
+			specifier = "/" + mapped
+		} else {
+			unmapped = parseURLLikeSpecifier(specifier, importMapBase) == nil
+		}
+	}
+	dir := parentDir(parent)
+	key := dir + "\x00" + specifier
+
+	r.Lock()
+	filename, ok := r.resolved[key]
+	r.Unlock()
+	if ok {
+		return filename, nil
+	}
+
+	filename, err := r.resolveUncached(specifier, dir)
+	if err != nil {
+		if unmapped {
+			return "", errors.New(err.Error() + ": bare specifier not mapped by the import map nor found in node_modules (required from '" + parent + "')")
+		}
+		return "", errors.New(err.Error() + " (required from '" + parent + "')")
+	}
+	r.Lock()
+	r.resolved[key] = filename
+	r.Unlock()
+	return filename, nil
+}
+
+func (r *requireResolver) resolveUncached(specifier, dir string) (string, error) {
+	if specifier == "" {
+		return "", errors.New("Cannot find module ''")
+	}
+	notFound := errors.New("Cannot find module '" + specifier + "'")
+
+	if strings.HasPrefix(specifier, "/") || specifier == "." || specifier == ".." ||
+		strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") {
+		var p string
+		if strings.HasPrefix(specifier, "/") {
+			p = path.Clean(strings.TrimPrefix(specifier, "/"))
+		} else {
+			p = path.Join(dir, specifier)
+		}
+		if p == ".." || strings.HasPrefix(p, "../") {
+			return "", notFound
+		}
+		if f, ok := r.loadAsFile(p); ok {
+			return f, nil
+		}
+		if f, ok := r.loadAsDirectory(p); ok {
+			return f, nil
+		}
+		return "", notFound
+	}
+
+	name, subpath := splitPackageSpecifier(specifier)
+	for d := dir; ; d = path.Dir(d) {
+		if path.Base(d) != "node_modules" {
+			pkgDir := path.Join(d, "node_modules", name)
+			if pkg := r.packageJSON(pkgDir); pkg != nil && len(pkg.Exports) > 0 {
+				target, err := resolveExports(pkg.Exports, subpath)
+				if err != nil {
+					return "", errors.New(err.Error() + " in package '" + name + "'")
+				}
+				p := path.Join(pkgDir, target)
+				if r.isFile(p) {
+					return p, nil
+				}
+				return "", notFound
+			}
+			p := path.Join(d, "node_modules", specifier)
+			if f, ok := r.loadAsFile(p); ok {
+				return f, nil
+			}
+			if f, ok := r.loadAsDirectory(p); ok {
+				return f, nil
+			}
+		}
+		if d == "." {
+			break
+		}
+	}
+	return "", notFound
+}
+
+func (r *requireResolver) isFile(p string) bool {
+	info, err := fs.Stat(r.fsys, p)
+	return err == nil && !info.IsDir()
+}
+
+func (r *requireResolver) loadAsFile(p string) (string, bool) {
+	for _, candidate := range []string{p, p + ".js", p + ".json"} {
+		if r.isFile(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+func (r *requireResolver) loadIndex(p string) (string, bool) {
+	for _, candidate := range []string{path.Join(p, "index.js"), path.Join(p, "index.json")} {
+		if r.isFile(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+func (r *requireResolver) loadAsDirectory(p string) (string, bool) {
+	if pkg := r.packageJSON(p); pkg != nil && pkg.Main != "" {
+		m := path.Join(p, pkg.Main)
+		if f, ok := r.loadAsFile(m); ok {
+			return f, true
+		}
+		if f, ok := r.loadIndex(m); ok {
+			return f, true
+		}
+	}
+	return r.loadIndex(p)
+}
+
+// packageJSON returns the parsed package.json of dir, or nil.
+func (r *requireResolver) packageJSON(dir string) *packageJSON {
+	r.Lock()
+	pkg, ok := r.packages[dir]
+	r.Unlock()
+	if ok {
+		return pkg
+	}
+	data, err := fs.ReadFile(r.fsys, path.Join(dir, "package.json"))
+	if err == nil {
+		pkg = new(packageJSON)
+		if json.Unmarshal(data, pkg) != nil {
+			pkg = nil
+		}
+	}
+	r.Lock()
+	r.packages[dir] = pkg
+	r.Unlock()
+	return pkg
+}
+
+// parentDir returns the directory in fsys that specifiers required from the
+// script named parent are relative to.
+func parentDir(parent string) string {
+	p := path.Clean(strings.TrimPrefix(parent, "/"))
+	if !fs.ValidPath(p) {
+		return "."
+	}
+	return path.Dir(p)
+}
+
+// splitPackageSpecifier splits a bare specifier into the package name, which
+// may be scoped, and the "exports" subpath.
+func splitPackageSpecifier(specifier string) (string, string) {
+	parts := strings.SplitN(specifier, "/", 3)
+	n := 1
+	if strings.HasPrefix(specifier, "@") && len(parts) > 1 {
+		n = 2
+	}
+	if len(parts) <= n {
+		return specifier, "."
+	}
+	name := strings.Join(parts[:n], "/")
+	return name, "." + strings.TrimPrefix(specifier, name)
+}
+
+// requireConditions are the "exports" conditions matched. Like Node, the
+// first of them in the order of the package.json wins.
+var requireConditions = map[string]bool{"require": true, "node": true, "default": true}
+
+// jsonMember is a member of a JSON object, see objectMembers.
+type jsonMember struct {
+	key   string
+	value json.RawMessage
+}
+
+// objectMembers returns the members of the JSON object data in the order of
+// the document, or false if data is not an object.
+func objectMembers(data json.RawMessage) ([]jsonMember, bool) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, false
+	}
+	var members []jsonMember
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		m := jsonMember{key: t.(string)}
+		if err := dec.Decode(&m.value); err != nil {
+			return nil, false
+		}
+		members = append(members, m)
+	}
+	return members, true
+}
+
+// resolveExports resolves subpath against the "exports" field of a package.
+func resolveExports(exports json.RawMessage, subpath string) (string, error) {
+	members, ok := objectMembers(exports)
+	// "exports": "./index.js" or "exports": {"require": ...} only export "."
+	if !ok || len(members) == 0 || !strings.HasPrefix(members[0].key, ".") {
+		if subpath != "." {
+			return "", errors.New("Package subpath '" + subpath + "' is not defined by \"exports\"")
+		}
+		return resolveExportsTarget(exports, "")
+	}
+	for _, m := range members {
+		if m.key == subpath {
+			return resolveExportsTarget(m.value, "")
+		}
+	}
+	// subpath patterns, e.g. "./lib/*": "./src/*.js"; the longest prefix
+	// before the * wins, then the longest key
+	best := -1
+	for i, m := range members {
+		star := strings.Index(m.key, "*")
+		if star < 0 || strings.Count(m.key, "*") > 1 {
+			continue
+		}
+		prefix, suffix := m.key[:star], m.key[star+1:]
+		if !strings.HasPrefix(subpath, prefix) || !strings.HasSuffix(subpath, suffix) || len(subpath) < len(prefix)+len(suffix) {
+			continue
+		}
+		if best < 0 || patternKeyLess(members[best].key, m.key) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		key := members[best].key
+		star := strings.Index(key, "*")
+		return resolveExportsTarget(members[best].value, subpath[star:len(subpath)-len(key)+star+1])
+	}
+	return "", errors.New("Package subpath '" + subpath + "' is not defined by \"exports\"")
+}
+
+// patternKeyLess reports whether the subpath pattern b is more specific than a.
+func patternKeyLess(a, b string) bool {
+	aPrefix, bPrefix := strings.Index(a, "*"), strings.Index(b, "*")
+	if aPrefix != bPrefix {
+		return bPrefix > aPrefix
+	}
+	return len(b) > len(a)
+}
+
+func resolveExportsTarget(target json.RawMessage, match string) (string, error) {
+	var s string
+	if json.Unmarshal(target, &s) == nil {
+		if !strings.HasPrefix(s, "./") {
+			return "", errors.New("Invalid \"exports\" target '" + s + "'")
+		}
+		return strings.Replace(s, "*", match, -1), nil
+	}
+	var alternatives []json.RawMessage
+	if json.Unmarshal(target, &alternatives) == nil {
+		for _, alt := range alternatives {
+			if res, err := resolveExportsTarget(alt, match); err == nil {
+				return res, nil
+			}
+		}
+	} else if members, ok := objectMembers(target); ok {
+		for _, m := range members {
+			if requireConditions[m.key] {
+				if res, err := resolveExportsTarget(m.value, match); err == nil {
+					return res, nil
+				}
+			}
+		}
+	}
+	return "", errors.New("No \"exports\" target matched the require conditions")
+}
@@ -0,0 +1,100 @@
+package v8worker
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRequire(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/math.js":                     {Data: []byte(`exports.add = function (a, b) { return a + b; };`)},
+		"lib/config.json":                 {Data: []byte(`{"name": "config"}`)},
+		"lib/counter.js":                  {Data: []byte(`var n = 0; module.exports = function () { return ++n; };`)},
+		"lib/broken.js":                   {Data: []byte(`require("./missing");`)},
+		"node_modules/greet/package.json": {Data: []byte(`{"main": "src/greet"}`)},
+		"node_modules/greet/src/greet.js": {Data: []byte(`var math = require("../../../lib/math"); module.exports = function (s) { return "hello " + s + math.add(1, 1); };`)},
+	}
+
+	var caught []string
+	worker := New(func(msg string) {
+		caught = append(caught, msg)
+	}, DiscardSendSync)
+	if err := worker.EnableRequire(fsys); err != nil {
+		t.Fatal(err)
+	}
+
+	err := worker.Load("main.js", `
+		var math = require("./lib/math");
+		var config = require("./lib/config.json");
+		var greet = require("greet");
+		$send(String(math.add(2, 3)));
+		$send(config.name);
+		$send(greet("world"));
+		// modules are cached
+		require("./lib/counter")();
+		$send(String(require("./lib/counter")()));
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"5", "config", "hello world2", "2"}
+	if strings.Join(caught, ",") != strings.Join(expected, ",") {
+		t.Fatal("bad msgs", caught)
+	}
+
+	err = worker.Load("main2.js", `require("./lib/broken");`)
+	if err == nil || !strings.Contains(err.Error(), "Cannot find module './missing'") || !strings.Contains(err.Error(), "lib/broken.js") {
+		t.Fatal("Expected error naming the requiring file", err)
+	}
+}
+
+func TestRequireFromSubdirectory(t *testing.T) {
+	fsys := fstest.MapFS{
+		"src/x.js":      {Data: []byte(`module.exports = "x";`)},
+		"src/broken.js": {Data: []byte(`require("./missing");`)},
+	}
+	var caught []string
+	worker := New(func(msg string) {
+		caught = append(caught, msg)
+	}, DiscardSendSync)
+	if err := worker.EnableRequire(fsys); err != nil {
+		t.Fatal(err)
+	}
+	err := worker.Load("src/main.js", `$send(require("./x")); $send(require.resolve("./x"));`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caught, ",") != "x,src/x.js" {
+		t.Fatal("bad msgs", caught)
+	}
+	err = worker.Load("src/main2.js", `require("./missing");`)
+	if err == nil || !strings.Contains(err.Error(), "(required from 'src/main2.js')") {
+		t.Fatal("Expected error naming the requiring script", err)
+	}
+}
+
+func TestResolveExports(t *testing.T) {
+	tests := []struct {
+		exports, subpath, expected string
+	}{
+		{`"./index.js"`, ".", "./index.js"},
+		{`{"./*": "./any/*.js", "./lib/*": "./lib/*.js", "./lib/*.js": "./js/*.js"}`, "./lib/a", "./lib/a.js"},
+		{`{"./*": "./any/*.js", "./lib/*": "./lib/*.js", "./lib/*.js": "./js/*.js"}`, "./lib/a.js", "./js/a.js"},
+		{`{"./*": "./any/*.js", "./lib/*": "./lib/*.js"}`, "./b", "./any/b.js"},
+		{`{"./lib/*.js": "./js/*.js", "./lib/*": "./lib/*.js"}`, "./lib/a.js", "./js/a.js"},
+		// conditions are tried in the order of package.json
+		{`{"default": "./d.js", "require": "./r.js"}`, ".", "./d.js"},
+		{`{"import": "./i.js", "require": "./r.js", "default": "./d.js"}`, ".", "./r.js"},
+		{`{".": [{"browser": "./b.js"}, "./main.js"]}`, ".", "./main.js"},
+	}
+	for _, test := range tests {
+		got, err := resolveExports([]byte(test.exports), test.subpath)
+		if err != nil || got != test.expected {
+			t.Errorf("%s %s: got %q, %v want %q", test.exports, test.subpath, got, err, test.expected)
+		}
+	}
+	if _, err := resolveExports([]byte(`{"./a": "./a.js"}`), "./b"); err == nil {
+		t.Error("Expected error for unexported subpath")
+	}
+}
@@ -0,0 +1,538 @@
+package v8worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes, see https://www.jsonrpc.org/specification.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	// RPCServerError is the code of errors returned by Go handlers and
+	// exceptions thrown by js methods which don't set one.
+	RPCServerError = -32000
+)
+
+// ErrRPCDisabled is returned by the RPC methods of workers on which EnableRPC
+// wasn't called.
+var ErrRPCDisabled = errors.New("v8worker: rpc is not enabled, see Worker.EnableRPC")
+
+// rpcPrefix marks the $send and $sendSync messages of the js side of the RPC.
+const rpcPrefix = "\x00jsonrpc\x00"
+
+// rpcScript defines $rpc on top of $send and $sendSync. It takes over the
+// $recv callback: JSON-RPC messages are handled and others are passed to the
+// callback registered with the $recv it redefines.
+const rpcScript = `var $rpc = (function (global) {
+	var prefix = "` + rpcPrefix + `";
+	var send = global.$send, sendSync = global.$sendSync;
+	var methods = {}, pending = {}, nextId = 1, userRecv = null;
+
+	function RPCError(code, message, data) {
+		this.name = "RPCError";
+		this.code = code;
+		this.message = message;
+		if (data !== undefined) this.data = data;
+		this.stack = new Error(message).stack;
+	}
+	RPCError.prototype = Object.create(Error.prototype);
+	RPCError.prototype.constructor = RPCError;
+
+	function toRPCError(e) {
+		if (e instanceof RPCError) return e;
+		if (e && typeof e.code === "number" && typeof e.message === "string") return new RPCError(e.code, e.message, e.data);
+		return new RPCError(-32000, e instanceof Error ? e.message : String(e));
+	}
+
+	function errorObject(e) {
+		var o = { code: e.code, message: e.message };
+		if (e.data !== undefined) o.data = e.data;
+		return o;
+	}
+
+	function isRequest(m) {
+		return m !== null && typeof m === "object" && m.jsonrpc === "2.0" && typeof m.method === "string";
+	}
+
+	function isMessage(m) {
+		if (Array.isArray(m)) return m.length > 0 && m[0] !== null && typeof m[0] === "object" && m[0].jsonrpc === "2.0";
+		return m !== null && typeof m === "object" && m.jsonrpc === "2.0";
+	}
+
+	// handle returns a promise of the response to the request m, undefined
+	// for notifications.
+	function handle(m) {
+		if (!isRequest(m)) {
+			return Promise.resolve({ jsonrpc: "2.0", id: null, error: { code: -32600, message: "Invalid Request" } });
+		}
+		var notification = !("id" in m);
+		var p = new Promise(function (resolve) {
+			var fn = methods[m.method];
+			if (typeof fn !== "function") {
+				throw new RPCError(-32601, "Method not found: " + m.method);
+			}
+			resolve(fn(m.params));
+		}).then(function (result) {
+			return { jsonrpc: "2.0", id: m.id, result: result === undefined ? null : result };
+		}, function (e) {
+			return { jsonrpc: "2.0", id: m.id, error: errorObject(toRPCError(e)) };
+		});
+		return notification ? p.then(function () {}) : p;
+	}
+
+	function settle(m) {
+		var call = pending[m.id];
+		if (!call) return;
+		delete pending[m.id];
+		if (m.error) {
+			call.reject(new RPCError(m.error.code, m.error.message, m.error.data));
+		} else {
+			call.resolve(m.result);
+		}
+	}
+
+	function dispatch(msg) {
+		var batch = Array.isArray(msg);
+		var messages = batch ? msg : [msg];
+		var responses = [];
+		messages.forEach(function (m) {
+			if (m !== null && typeof m === "object" && !("method" in m) && ("result" in m || "error" in m)) {
+				settle(m);
+			} else {
+				responses.push(handle(m));
+			}
+		});
+		if (responses.length === 0) return;
+		Promise.all(responses).then(function (rs) {
+			rs = rs.filter(function (r) { return r !== undefined; });
+			if (rs.length > 0) send(prefix + JSON.stringify(batch ? rs : rs[0]));
+		});
+	}
+
+	$recv(function (msg) {
+		if (isMessage(msg)) return dispatch(msg);
+		if (userRecv) return userRecv(msg);
+	});
+	global.$recv = function (fn) {
+		userRecv = fn;
+	};
+
+	function request(method, params, id) {
+		var m = { jsonrpc: "2.0", method: String(method) };
+		if (params !== undefined) m.params = params;
+		if (id !== undefined) m.id = id;
+		return m;
+	}
+
+	function call(method, params) {
+		var id = nextId++;
+		var p = new Promise(function (resolve, reject) {
+			pending[id] = { resolve: resolve, reject: reject };
+		});
+		send(prefix + JSON.stringify(request(method, params, id)));
+		return p;
+	}
+
+	return {
+		Error: RPCError,
+		// register makes fn callable from Go as method. fn gets the params
+		// and returns the result or a promise of it.
+		register: function (method, fn) {
+			methods[method] = fn;
+		},
+		// call calls the Go method and returns a promise of its result.
+		call: call,
+		// notify calls the Go method without waiting for a response.
+		notify: function (method, params) {
+			send(prefix + JSON.stringify(request(method, params)));
+		},
+		// callSync calls the Go method and returns its result, blocking
+		// until the handler returns.
+		callSync: function (method, params) {
+			var res = JSON.parse(sendSync(prefix + JSON.stringify(request(method, params, nextId++))));
+			if (res.error) throw new RPCError(res.error.code, res.error.message, res.error.data);
+			return res.result;
+		},
+		// batch sends calls, {method, params, notification}, as a batch and
+		// returns a promise of their results, RPCErrors for failed calls and
+		// undefined for notifications.
+		batch: function (calls) {
+			var promises = [], messages = [];
+			calls.forEach(function (c) {
+				if (c.notification) {
+					messages.push(request(c.method, c.params));
+					promises.push(undefined);
+					return;
+				}
+				var id = nextId++;
+				messages.push(request(c.method, c.params, id));
+				promises.push(new Promise(function (resolve, reject) {
+					pending[id] = { resolve: resolve, reject: reject };
+				}).then(null, function (e) { return e; }));
+			});
+			send(prefix + JSON.stringify(messages));
+			return Promise.all(promises);
+		}
+	};
+})(this);
+`
+
+// RPCError is a JSON-RPC error object. Errors thrown by js methods are
+// returned as *RPCError, Go handlers may return one to choose the code.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return "jsonrpc error " + strconv.Itoa(e.Code) + ": " + e.Message
+}
+
+// RPCHandler is a Go method callable from js. params is the JSON of the
+// params, nil if there are none. The result is encoded as JSON. Errors other
+// than *RPCError are sent with code RPCServerError.
+type RPCHandler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// RPCCall is a call of a batch, see CallBatch.
+type RPCCall struct {
+	Method string
+	Params interface{}
+	// Result, if not nil, receives the decoded result.
+	Result interface{}
+	// Notification calls get no response.
+	Notification bool
+	// Error is set by CallBatch if the call failed.
+	Error error
+}
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// rpcPeer is the Go side of the RPC of a worker.
+type rpcPeer struct {
+	w *Worker
+
+	locker  sync.Mutex
+	methods map[string]RPCHandler
+	pending map[string]chan *rpcMessage
+	lastID  int64
+
+	services []*service // see Expose
+}
+
+// EnableRPC defines $rpc in the worker, a JSON-RPC 2.0 peer: js registers
+// methods with $rpc.register(name, fn) which Go calls with Call, and calls
+// the Go methods registered with HandleRPC with $rpc.call (returning a
+// promise), $rpc.callSync, $rpc.notify and $rpc.batch. Messages travel over
+// Send and $send. $rpc takes over $recv: call EnableRPC before loading scripts
+// registering a $recv callback, which then gets the other messages.
+func (w *Worker) EnableRPC() error {
+	p := &rpcPeer{
+		w:       w,
+		methods: make(map[string]RPCHandler),
+		pending: make(map[string]chan *rpcMessage),
+	}
+	callbacksMapLocker.Lock()
+	callbacksMap[w.id].rpc = p
+	callbacksMapLocker.Unlock()
+	return w.Load("v8worker:rpc.js", rpcScript)
+}
+
+func (w *Worker) lookupRPC() *rpcPeer {
+	callbacksMapLocker.RLock()
+	defer callbacksMapLocker.RUnlock()
+	return callbacksMap[w.id].rpc
+}
+
+// HandleRPC makes h callable from js as method. h runs on the goroutine of the
+// worker call during which $rpc.callSync was called, so it may call the worker
+// again, e.g. with Send or Call. Handlers of $rpc.call, $rpc.notify and
+// $rpc.batch requests run on goroutines of their own.
+func (w *Worker) HandleRPC(method string, h RPCHandler) error {
+	p := w.lookupRPC()
+	if p == nil {
+		return ErrRPCDisabled
+	}
+	p.locker.Lock()
+	p.methods[method] = h
+	p.locker.Unlock()
+	return nil
+}
+
+// Call calls the js method registered with $rpc.register and decodes its
+// result into result, which may be nil to ignore it. Methods returning a
+// promise respond once it settles. Errors of the method are returned as
+// *RPCError.
+func (w *Worker) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	call := &RPCCall{Method: method, Params: params, Result: result}
+	if err := w.CallBatch(ctx, []*RPCCall{call}); err != nil {
+		return err
+	}
+	return call.Error
+}
+
+// Notify calls the js method without waiting for a response.
+func (w *Worker) Notify(method string, params interface{}) error {
+	return w.CallBatch(context.Background(), []*RPCCall{{Method: method, Params: params, Notification: true}})
+}
+
+// CallBatch sends calls as a JSON-RPC batch and waits for their responses,
+// stored in their Result and Error. The error returned is about the batch as a
+// whole: sending it failed or ctx is done.
+func (w *Worker) CallBatch(ctx context.Context, calls []*RPCCall) error {
+	p := w.lookupRPC()
+	if p == nil {
+		return ErrRPCDisabled
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+
+	// params are encoded before any id is registered, so that a failure
+	// leaves nothing pending
+	messages := make([]rpcMessage, len(calls))
+	for i, c := range calls {
+		params, err := json.Marshal(c.Params)
+		if err != nil {
+			return err
+		}
+		if c.Params == nil {
+			params = nil
+		}
+		messages[i] = rpcMessage{JSONRPC: "2.0", Method: c.Method, Params: params}
+	}
+	waits := make(map[string]*RPCCall)
+	chans := make(map[string]chan *rpcMessage)
+	for i, c := range calls {
+		if !c.Notification {
+			id, ch := p.newCall()
+			messages[i].ID = json.RawMessage(id)
+			waits[id] = c
+			chans[id] = ch
+		}
+	}
+	defer func() {
+		p.locker.Lock()
+		for id := range chans {
+			delete(p.pending, id)
+		}
+		p.locker.Unlock()
+	}()
+
+	var err error
+	if len(calls) == 1 {
+		err = w.SendJSON(messages[0])
+	} else {
+		err = w.SendJSON(messages)
+	}
+	if err != nil {
+		return err
+	}
+
+	for id, ch := range chans {
+		var res *rpcMessage
+		select {
+		case res = <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		c := waits[id]
+		switch {
+		case res.Error != nil:
+			c.Error = res.Error
+		case c.Result != nil:
+			c.Error = json.Unmarshal(res.Result, c.Result)
+		}
+	}
+	return nil
+}
+
+// newCall allocates the id of a call and its response channel.
+func (p *rpcPeer) newCall() (string, chan *rpcMessage) {
+	ch := make(chan *rpcMessage, 1)
+	p.locker.Lock()
+	p.lastID++
+	id := strconv.FormatInt(p.lastID, 10)
+	p.pending[id] = ch
+	p.locker.Unlock()
+	return id, ch
+}
+
+// receive handles a message sent by $rpc with $send. Requests run on their own
+// goroutine and the responses are sent back with SendJSON.
+func (p *rpcPeer) receive(data string) {
+	messages, batch, errRes := parseRPC(data)
+	if errRes != nil {
+		go p.w.SendJSON(errRes)
+		return
+	}
+	var requests []*rpcMessage
+	for _, m := range messages {
+		if m.Method == "" && m.JSONRPC == "2.0" && (m.Result != nil || m.Error != nil) {
+			p.settle(m)
+		} else {
+			requests = append(requests, m)
+		}
+	}
+	if len(requests) == 0 {
+		return
+	}
+	go func() {
+		if res := p.handleAll(requests, batch); res != nil {
+			p.w.SendJSON(json.RawMessage(res))
+		}
+	}()
+}
+
+// receiveSync handles a request sent by $rpc.callSync and returns the response.
+// Handlers run on the calling goroutine, inside the $sendSync callback, so that
+// they may call the worker like any nested call.
+func (p *rpcPeer) receiveSync(data string) string {
+	messages, batch, errRes := parseRPC(data)
+	if errRes != nil {
+		res, _ := json.Marshal(errRes)
+		return string(res)
+	}
+	responses := make([]*rpcMessage, len(messages))
+	for i, m := range messages {
+		responses[i] = p.handle(m)
+	}
+	res := encodeResponses(responses, batch)
+	if res == nil {
+		return `{"jsonrpc":"2.0","id":null,"result":null}`
+	}
+	return string(res)
+}
+
+// parseRPC parses a message or batch, returning the error response to send if
+// it is invalid.
+func parseRPC(data string) (messages []*rpcMessage, batch bool, errRes *rpcMessage) {
+	invalid := func(code int, message string) *rpcMessage {
+		return &rpcMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &RPCError{Code: code, Message: message}}
+	}
+	raw := bytes.TrimSpace([]byte(data))
+	if len(raw) > 0 && raw[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, true, invalid(RPCParseError, "Parse error")
+		}
+		if len(items) == 0 {
+			return nil, true, invalid(RPCInvalidRequest, "Invalid Request")
+		}
+		for _, item := range items {
+			m := new(rpcMessage)
+			if json.Unmarshal(item, m) != nil {
+				// handleAll answers it with an invalid request error
+				m = &rpcMessage{ID: json.RawMessage("null")}
+			}
+			messages = append(messages, m)
+		}
+		return messages, true, nil
+	}
+	m := new(rpcMessage)
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, false, invalid(RPCParseError, "Parse error")
+	}
+	return []*rpcMessage{m}, false, nil
+}
+
+// handleAll runs the handlers of requests concurrently and returns the JSON of
+// their responses, nil if there are none.
+func (p *rpcPeer) handleAll(requests []*rpcMessage, batch bool) []byte {
+	responses := make([]*rpcMessage, len(requests))
+	var wg sync.WaitGroup
+	for i, m := range requests {
+		wg.Add(1)
+		go func(i int, m *rpcMessage) {
+			defer wg.Done()
+			responses[i] = p.handle(m)
+		}(i, m)
+	}
+	wg.Wait()
+	return encodeResponses(responses, batch)
+}
+
+// encodeResponses returns the JSON of the responses which aren't nil, nil if
+// there are none.
+func encodeResponses(responses []*rpcMessage, batch bool) []byte {
+	var out []*rpcMessage
+	for _, res := range responses {
+		if res != nil {
+			out = append(out, res)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	var data []byte
+	if batch {
+		data, _ = json.Marshal(out)
+	} else {
+		data, _ = json.Marshal(out[0])
+	}
+	return data
+}
+
+// handle runs the handler of a request and returns its response, nil for
+// notifications.
+func (p *rpcPeer) handle(m *rpcMessage) *rpcMessage {
+	res := &rpcMessage{JSONRPC: "2.0", ID: m.ID}
+	if m.JSONRPC != "2.0" || m.Method == "" {
+		res.ID = json.RawMessage("null")
+		res.Error = &RPCError{Code: RPCInvalidRequest, Message: "Invalid Request"}
+		return res
+	}
+
+	p.locker.Lock()
+	h := p.methods[m.Method]
+	p.locker.Unlock()
+	var result interface{}
+	var err error
+	if h == nil {
+		err = &RPCError{Code: RPCMethodNotFound, Message: "Method not found: " + m.Method}
+	} else {
+		result, err = h(context.Background(), m.Params)
+	}
+	if m.ID == nil {
+		return nil
+	}
+
+	if err == nil {
+		res.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: RPCServerError, Message: err.Error()}
+		}
+		res.Result = nil
+		res.Error = rpcErr
+	}
+	return res
+}
+
+// settle passes a response to the Call waiting for it.
+func (p *rpcPeer) settle(m *rpcMessage) {
+	p.locker.Lock()
+	ch := p.pending[string(m.ID)]
+	delete(p.pending, string(m.ID))
+	p.locker.Unlock()
+	if ch != nil {
+		ch <- m
+	}
+}
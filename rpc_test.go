@@ -0,0 +1,194 @@
+package v8worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newRPCWorker(t *testing.T, cb ReceiveMessageCallback) *Worker {
+	worker := New(cb, DiscardSendSync)
+	if err := worker.EnableRPC(); err != nil {
+		t.Fatal(err)
+	}
+	return worker
+}
+
+func TestRPCCall(t *testing.T) {
+	worker := newRPCWorker(t, func(msg string) {})
+	err := worker.Load("code.js", `
+		$rpc.register("add", function(p) { return p[0] + p[1]; });
+		$rpc.register("double", function(n) { return Promise.resolve(n * 2); });
+		$rpc.register("fail", function() { throw new Error("boom"); });
+		$rpc.register("coded", function() { throw new $rpc.Error(42, "custom"); });
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	var sum int
+	if err := worker.Call(ctx, "add", []int{1, 2}, &sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 3 {
+		t.Fatal("bad sum", sum)
+	}
+	var doubled int
+	if err := worker.Call(ctx, "double", 21, &doubled); err != nil {
+		t.Fatal(err)
+	}
+	if doubled != 42 {
+		t.Fatal("bad promise result", doubled)
+	}
+
+	for method, code := range map[string]int{"fail": RPCServerError, "coded": 42, "missing": RPCMethodNotFound} {
+		err := worker.Call(ctx, method, nil, nil)
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != code {
+			t.Fatal(method, "expected error code", code, err)
+		}
+	}
+
+	calls := []*RPCCall{
+		{Method: "add", Params: []int{2, 3}, Result: new(int)},
+		{Method: "add", Params: []int{0, 0}, Notification: true},
+		{Method: "missing"},
+	}
+	if err := worker.CallBatch(ctx, calls); err != nil {
+		t.Fatal(err)
+	}
+	if *calls[0].Result.(*int) != 5 || calls[0].Error != nil || calls[2].Error == nil {
+		t.Fatal("bad batch results", calls[0], calls[2])
+	}
+
+	// a batch failing to encode leaves no call pending
+	calls = []*RPCCall{
+		{Method: "add", Params: []int{2, 3}},
+		{Method: "add", Params: make(chan int)},
+	}
+	if err := worker.CallBatch(ctx, calls); err == nil {
+		t.Fatal("Expected an encoding error")
+	}
+	p := worker.lookupRPC()
+	p.locker.Lock()
+	pending := len(p.pending)
+	p.locker.Unlock()
+	if pending != 0 {
+		t.Fatal("Expected no pending calls", pending)
+	}
+}
+
+func TestRPCCallTimeout(t *testing.T) {
+	worker := newRPCWorker(t, func(msg string) {})
+	err := worker.Load("code.js", `
+		$rpc.register("never", function() { return new Promise(function() {}); });
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := worker.Call(ctx, "never", nil, nil); err != context.DeadlineExceeded {
+		t.Fatal("expected deadline exceeded", err)
+	}
+}
+
+func TestRPCFromJS(t *testing.T) {
+	results := make(chan string, 10)
+	worker := newRPCWorker(t, func(msg string) {
+		results <- msg
+	})
+	worker.HandleRPC("greet", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		var name string
+		if err := json.Unmarshal(params, &name); err != nil {
+			return nil, &RPCError{Code: RPCInvalidParams, Message: err.Error()}
+		}
+		return "hello " + name, nil
+	})
+	notified := make(chan string, 1)
+	worker.HandleRPC("log", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		notified <- string(params)
+		return nil, nil
+	})
+
+	err := worker.Load("code.js", `
+		$send("sync " + $rpc.callSync("greet", "sync"));
+		try {
+			$rpc.callSync("greet", 1);
+		} catch (e) {
+			$send("error " + e.code);
+		}
+		$rpc.call("greet", "async").then(function(res) {
+			$send("async " + res);
+		});
+		$rpc.notify("log", "note");
+		$recv(function(msg) {
+			$send("user " + msg);
+		});
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(want string) {
+		select {
+		case got := <-results:
+			if got != want {
+				t.Fatal("expected", want, "got", got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for", want)
+		}
+	}
+	expect("sync hello sync")
+	expect("error -32602")
+	expect("async hello async")
+	if note := <-notified; note != `"note"` {
+		t.Fatal("bad notification", note)
+	}
+
+	// other messages still reach $recv
+	if err := worker.Send("hi"); err != nil {
+		t.Fatal(err)
+	}
+	expect("user hi")
+}
+
+func TestRPCCallSyncReentrant(t *testing.T) {
+	var worker *Worker
+	var msgs []string
+	worker = newRPCWorker(t, func(msg string) {
+		msgs = append(msgs, msg)
+	})
+	// the handler calls back into the worker while js waits in callSync
+	worker.HandleRPC("nested", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		if err := worker.Send("inner"); err != nil {
+			return nil, err
+		}
+		return "outer", nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- worker.Load("code.js", `
+			$recv(function(msg) {
+				$send("recv " + msg);
+			});
+			$send("result " + $rpc.callSync("nested"));
+		`)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nested call from a callSync handler deadlocked")
+	}
+	if len(msgs) != 2 || msgs[0] != "recv inner" || msgs[1] != "result outer" {
+		t.Fatal("bad msgs", msgs)
+	}
+}
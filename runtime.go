@@ -0,0 +1,14 @@
+package v8worker
+
+import "github.com/getblank/v8worker/v8runtime"
+
+// Runtime is implemented by Worker, RemoteWorker and Client. Code depending on
+// it instead of *Worker can be tested with the fake of package v8workertest,
+// which builds without V8.
+type Runtime = v8runtime.Runtime
+
+var (
+	_ Runtime = (*Worker)(nil)
+	_ Runtime = (*RemoteWorker)(nil)
+	_ Runtime = (*Client)(nil)
+)
@@ -0,0 +1,326 @@
+package v8worker
+
+/*
+#include <time.h>
+
+static long long thread_cpu_time_ns() {
+  struct timespec ts;
+  clock_gettime(CLOCK_THREAD_CPUTIME_ID, &ts);
+  return (long long)ts.tv_sec * 1000000000LL + ts.tv_nsec;
+}
+*/
+import "C"
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQuotaExceeded is returned for tenants over their CPU quota which
+	// reject work instead of delaying it.
+	ErrQuotaExceeded = errors.New("v8worker: tenant CPU quota exceeded")
+	// ErrQueueFull is returned when a tenant already has MaxQueue calls waiting.
+	ErrQueueFull = errors.New("v8worker: tenant queue full")
+)
+
+// TenantConfig holds the scheduling settings of a tenant. The zero value is a
+// tenant of weight 1 without limits.
+type TenantConfig struct {
+	// Weight is the share of the pool the tenant gets when others compete
+	// for it. Defaults to 1.
+	Weight int
+	// MaxConcurrency limits the calls of the tenant running at once.
+	MaxConcurrency int
+	// MaxQueue limits the calls of the tenant waiting to run. More calls
+	// fail with ErrQueueFull.
+	MaxQueue int
+	// CPUQuota is the CPU time the tenant may use per scheduler period.
+	CPUQuota time.Duration
+	// RejectOverQuota makes calls of a tenant over its CPU quota fail with
+	// ErrQuotaExceeded; by default they wait for the next period.
+	RejectOverQuota bool
+}
+
+// TenantStats are the counters of a tenant.
+type TenantStats struct {
+	Queued   int
+	Running  int
+	CPUUsed  time.Duration // in the current period
+	CPUTotal time.Duration
+	Calls    int64
+	Rejected int64
+}
+
+// Scheduler shares a pool between tenants with weighted fair queuing. Calls
+// of every tenant are queued separately and run in the order of their virtual
+// finish time, where a call costs the average CPU time of its tenant divided by
+// the tenant weight. CPU time is measured on the OS thread running the call.
+//
+// Tenants configured with SetTenant are kept until RemoveTenant. Others get
+// the zero config and are forgotten, with their stats, once they have no
+// calls queued or running, so that arbitrary tenant names don't pile up.
+type Scheduler struct {
+	pool   *Pool
+	period time.Duration
+
+	locker  sync.Mutex
+	tenants map[string]*tenant
+	running int
+	vtime   float64
+	timer   *time.Timer
+}
+
+type tenant struct {
+	name        string
+	configured  bool // by SetTenant
+	config      TenantConfig
+	queue       []*scheduledCall
+	finish      float64
+	avgCPU      float64 // seconds, moving average
+	windowStart time.Time
+	stats       TenantStats
+}
+
+type scheduledCall struct {
+	start      float64 // virtual start time
+	finish     float64 // virtual finish time, the dispatch order
+	ready      chan struct{}
+	dispatched bool
+}
+
+// NewScheduler creates a scheduler running calls on pool. CPU quotas apply to
+// windows of period.
+func NewScheduler(pool *Pool, period time.Duration) *Scheduler {
+	if period <= 0 {
+		period = time.Second
+	}
+	return &Scheduler{
+		pool:    pool,
+		period:  period,
+		tenants: make(map[string]*tenant),
+	}
+}
+
+// SetTenant sets the config of a tenant. Unknown tenants get the zero config.
+func (s *Scheduler) SetTenant(name string, config TenantConfig) {
+	s.locker.Lock()
+	t := s.tenant(name)
+	t.config = config
+	t.configured = true
+	s.dispatch()
+	s.locker.Unlock()
+}
+
+// RemoveTenant resets a tenant to the zero config. It is forgotten, with its
+// stats, once it has no calls queued or running.
+func (s *Scheduler) RemoveTenant(name string) {
+	s.locker.Lock()
+	if t := s.tenants[name]; t != nil {
+		t.config = TenantConfig{}
+		t.configured = false
+		s.prune(t)
+		s.dispatch()
+	}
+	s.locker.Unlock()
+}
+
+// Stats returns the counters of a tenant, zero for unknown tenants.
+func (s *Scheduler) Stats(name string) TenantStats {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	t := s.tenants[name]
+	if t == nil {
+		return TenantStats{}
+	}
+	s.resetWindow(t, time.Now())
+	stats := t.stats
+	stats.Queued = len(t.queue)
+	return stats
+}
+
+// Do waits for the turn of tenant and runs fn with an idle worker of the pool.
+// It gives up with ctx.Err() if ctx is done before the call runs.
+func (s *Scheduler) Do(ctx context.Context, name string, fn func(w *Worker) error) error {
+	s.locker.Lock()
+	t := s.tenant(name)
+	s.resetWindow(t, time.Now())
+	if t.config.MaxQueue > 0 && len(t.queue) >= t.config.MaxQueue {
+		t.stats.Rejected++
+		s.locker.Unlock()
+		return ErrQueueFull
+	}
+	if t.overQuota() && t.config.RejectOverQuota {
+		t.stats.Rejected++
+		s.locker.Unlock()
+		return ErrQuotaExceeded
+	}
+	weight := t.config.Weight
+	if weight < 1 {
+		weight = 1
+	}
+	cost := t.avgCPU
+	if cost <= 0 {
+		cost = time.Millisecond.Seconds()
+	}
+	c := &scheduledCall{ready: make(chan struct{})}
+	c.start = s.vtime
+	if t.finish > c.start {
+		c.start = t.finish
+	}
+	c.finish = c.start + cost/float64(weight)
+	t.finish = c.finish
+	t.queue = append(t.queue, c)
+	s.dispatch()
+	s.locker.Unlock()
+
+	select {
+	case <-c.ready:
+	case <-ctx.Done():
+		s.locker.Lock()
+		if !c.dispatched {
+			for i := range t.queue {
+				if t.queue[i] == c {
+					t.queue = append(t.queue[:i], t.queue[i+1:]...)
+					break
+				}
+			}
+			s.prune(t)
+			s.locker.Unlock()
+			return ctx.Err()
+		}
+		s.locker.Unlock()
+		s.done(t, false, 0)
+		return ctx.Err()
+	}
+
+	var ran bool
+	var cpu time.Duration
+	err := s.pool.DoContext(ctx, func(w *Worker) error {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		ran = true
+		start := C.thread_cpu_time_ns()
+		err := fn(w)
+		cpu = time.Duration(C.thread_cpu_time_ns() - start)
+		return err
+	})
+	s.done(t, ran, cpu)
+	return err
+}
+
+// Send sends msg for tenant with Send on a worker of the pool.
+func (s *Scheduler) Send(ctx context.Context, name string, msg string) error {
+	return s.Do(ctx, name, func(w *Worker) error {
+		return w.Send(msg)
+	})
+}
+
+// SendSync sends msg for tenant with SendSync on a worker of the pool.
+func (s *Scheduler) SendSync(ctx context.Context, name string, msg string) (string, error) {
+	var res string
+	err := s.Do(ctx, name, func(w *Worker) error {
+		res = w.SendSync(msg)
+		return nil
+	})
+	return res, err
+}
+
+// done releases the slot of a dispatched call. ran reports whether the call
+// got a worker and ran, using cpu, which may be too short to measure.
+func (s *Scheduler) done(t *tenant, ran bool, cpu time.Duration) {
+	s.locker.Lock()
+	t.stats.Running--
+	s.running--
+	if ran {
+		t.stats.CPUUsed += cpu
+		t.stats.CPUTotal += cpu
+		t.stats.Calls++
+		if t.stats.Calls == 1 {
+			t.avgCPU = cpu.Seconds()
+		} else {
+			t.avgCPU = 0.8*t.avgCPU + 0.2*cpu.Seconds()
+		}
+	}
+	s.prune(t)
+	s.dispatch()
+	s.locker.Unlock()
+}
+
+// dispatch starts queued calls while the pool has idle workers, picking the
+// eligible call with the smallest virtual finish time. Must be called with
+// locker held.
+func (s *Scheduler) dispatch() {
+	now := time.Now()
+	for s.running < s.pool.Size() {
+		var best *tenant
+		var retry time.Time
+		for _, t := range s.tenants {
+			if len(t.queue) == 0 {
+				continue
+			}
+			if t.config.MaxConcurrency > 0 && t.stats.Running >= t.config.MaxConcurrency {
+				continue
+			}
+			s.resetWindow(t, now)
+			if t.overQuota() {
+				end := t.windowStart.Add(s.period)
+				if retry.IsZero() || end.Before(retry) {
+					retry = end
+				}
+				continue
+			}
+			if best == nil || t.queue[0].finish < best.queue[0].finish {
+				best = t
+			}
+		}
+		if best == nil {
+			if !retry.IsZero() && s.timer == nil {
+				s.timer = time.AfterFunc(retry.Sub(now), func() {
+					s.locker.Lock()
+					s.timer = nil
+					s.dispatch()
+					s.locker.Unlock()
+				})
+			}
+			return
+		}
+		c := best.queue[0]
+		best.queue = best.queue[1:]
+		best.stats.Running++
+		s.running++
+		s.vtime = c.start
+		c.dispatched = true
+		close(c.ready)
+	}
+}
+
+func (s *Scheduler) tenant(name string) *tenant {
+	t := s.tenants[name]
+	if t == nil {
+		t = &tenant{name: name, windowStart: time.Now()}
+		s.tenants[name] = t
+	}
+	return t
+}
+
+// prune forgets t if it isn't configured and idle. Must be called with locker
+// held.
+func (s *Scheduler) prune(t *tenant) {
+	if !t.configured && len(t.queue) == 0 && t.stats.Running == 0 {
+		delete(s.tenants, t.name)
+	}
+}
+
+func (s *Scheduler) resetWindow(t *tenant, now time.Time) {
+	if now.Sub(t.windowStart) >= s.period {
+		t.windowStart = now
+		t.stats.CPUUsed = 0
+	}
+}
+
+func (t *tenant) overQuota() bool {
+	return t.config.CPUQuota > 0 && t.stats.CPUUsed >= t.config.CPUQuota
+}
@@ -0,0 +1,171 @@
+package v8worker
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSpinPool(t *testing.T, size int) *Pool {
+	pool := NewPool(size, func() *Worker {
+		return New(func(msg string) {}, DiscardSendSync)
+	})
+	err := pool.Deploy("1", []Script{{Origin: ScriptOrigin{ScriptName: "spin.js"}, Code: `
+		$recvSync(function(msg) {
+			var end = Date.now() + parseInt(msg, 10);
+			while (Date.now() < end) { ; }
+			return "done";
+		});
+	`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestSchedulerFairness(t *testing.T) {
+	s := NewScheduler(newSpinPool(t, 1), time.Second)
+	s.SetTenant("light", TenantConfig{Weight: 1})
+	s.SetTenant("heavy", TenantConfig{Weight: 1})
+
+	// heavy floods the queue first
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.SendSync(context.Background(), "heavy", "10")
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	if res, err := s.SendSync(context.Background(), "light", "1"); err != nil || res != "done" {
+		t.Fatal(res, err)
+	}
+	// light must not wait for the whole heavy backlog (~200ms)
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Error("light tenant starved for", waited)
+	}
+	wg.Wait()
+
+	if stats := s.Stats("heavy"); stats.Calls != 20 || stats.CPUTotal <= 0 {
+		t.Error("bad stats", stats)
+	}
+}
+
+func TestSchedulerQuotas(t *testing.T) {
+	s := NewScheduler(newSpinPool(t, 2), time.Hour)
+	s.SetTenant("limited", TenantConfig{CPUQuota: 20 * time.Millisecond, RejectOverQuota: true})
+
+	if _, err := s.SendSync(context.Background(), "limited", "30"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SendSync(context.Background(), "limited", "1"); err != ErrQuotaExceeded {
+		t.Fatal("Expected ErrQuotaExceeded", err)
+	}
+
+	// over quota without rejection the call waits for the next period
+	s.SetTenant("delayed", TenantConfig{CPUQuota: 20 * time.Millisecond})
+	if _, err := s.SendSync(context.Background(), "delayed", "30"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.SendSync(ctx, "delayed", "1"); err != context.DeadlineExceeded {
+		t.Fatal("Expected context.DeadlineExceeded", err)
+	}
+
+	s.SetTenant("queued", TenantConfig{MaxConcurrency: 1, MaxQueue: 1})
+	go s.SendSync(context.Background(), "queued", "100")
+	go s.SendSync(context.Background(), "queued", "100")
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.SendSync(context.Background(), "queued", "1"); err != ErrQueueFull {
+		t.Fatal("Expected ErrQueueFull", err)
+	}
+}
+
+func TestSchedulerFinishOrder(t *testing.T) {
+	s := NewScheduler(newSpinPool(t, 1), time.Second)
+	s.SetTenant("big", TenantConfig{Weight: 1})
+	s.SetTenant("small", TenantConfig{Weight: 10})
+
+	// both calls are queued behind the blocker with the same virtual start
+	// time; the one of the heavier weight finishes first
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	go s.Do(context.Background(), "blocker", func(w *Worker) error {
+		close(blocked)
+		<-release
+		return nil
+	})
+	<-blocked
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	for _, name := range []string{"big", "small"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			s.Do(context.Background(), name, func(w *Worker) error {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				return nil
+			})
+		}(name)
+		for s.Stats(name).Queued == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(release)
+	wg.Wait()
+	if len(order) != 2 || order[0] != "small" {
+		t.Fatal("Expected the call with the earlier virtual finish time first", order)
+	}
+
+	// calls too short to measure are counted too
+	s.SetTenant("noop", TenantConfig{})
+	for i := 0; i < 3; i++ {
+		s.Do(context.Background(), "noop", func(w *Worker) error { return nil })
+	}
+	if stats := s.Stats("noop"); stats.Calls != 3 {
+		t.Fatal("Expected 3 calls", stats)
+	}
+}
+
+func TestSchedulerForgetsTenants(t *testing.T) {
+	s := NewScheduler(newSpinPool(t, 1), time.Second)
+	for i := 0; i < 10; i++ {
+		if _, err := s.SendSync(context.Background(), "request-"+strconv.Itoa(i), "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := s.Stats("unknown"); stats != (TenantStats{}) {
+		t.Fatal("Expected zero stats", stats)
+	}
+	s.SetTenant("kept", TenantConfig{Weight: 2})
+	if _, err := s.SendSync(context.Background(), "kept", "1"); err != nil {
+		t.Fatal(err)
+	}
+	s.locker.Lock()
+	n := len(s.tenants)
+	s.locker.Unlock()
+	if n != 1 {
+		t.Fatal("Expected only the configured tenant to be kept", n)
+	}
+	if stats := s.Stats("kept"); stats.Calls != 1 {
+		t.Fatal("Expected 1 call", stats)
+	}
+
+	s.RemoveTenant("kept")
+	s.locker.Lock()
+	n = len(s.tenants)
+	s.locker.Unlock()
+	if n != 0 {
+		t.Fatal("Expected no tenants", n)
+	}
+}
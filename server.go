@@ -0,0 +1,215 @@
+package v8worker
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrUnauthorized is returned by Dial when the server rejects the auth token.
+var ErrUnauthorized = errors.New("v8worker: unauthorized")
+
+// WorkerFactory creates the worker served to a single connection. Messages the
+// worker sends with $send and $sendSync must be routed to cb and syncCB.
+type WorkerFactory func(cb ReceiveMessageCallback, syncCB ReceiveSyncMessageCallback) (*Worker, error)
+
+// Server serves workers to clients connecting with Dial. Every connection gets
+// its own worker from Factory.
+type Server struct {
+	Factory WorkerFactory
+	// Token, if set, must be presented by clients before any other request.
+	Token string
+}
+
+// ServeWorker accepts connections on l and serves a worker created by factory
+// to each of them. It returns when l is closed.
+func ServeWorker(l net.Listener, factory WorkerFactory) error {
+	s := &Server{Factory: factory}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves a worker to each of them. It
+// returns when l is closed.
+func (srv *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a worker on a single connection and closes it when done.
+func (srv *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	s := newFrameServer(r, conn)
+
+	if srv.Token != "" {
+		f, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+		if f.kind != frameAuth || subtle.ConstantTimeCompare(f.payload, []byte(srv.Token)) != 1 {
+			s.out.write(frame{kind: frameError, id: f.id, payload: []byte(ErrUnauthorized.Error())})
+			return ErrUnauthorized
+		}
+		s.out.write(frame{kind: frameResult, id: f.id})
+	}
+
+	worker, err := srv.Factory(s.recv, s.recvSync)
+	if err != nil {
+		s.out.write(frame{kind: frameError, payload: []byte(err.Error())})
+		return err
+	}
+	s.worker = worker
+	return s.serve()
+}
+
+// frameServer drives a worker with the frames read from r and writes replies
+// and the messages sent from javascript to w. Requests are correlated by id and
+// run one at a time.
+type frameServer struct {
+	r      io.Reader
+	out    *frameWriter
+	worker *Worker
+	calls  sync.Mutex // serializes the requests run by handle
+
+	syncLocker  sync.Mutex
+	syncId      uint32
+	syncReplies map[uint32]chan frame
+	closed      bool
+}
+
+func newFrameServer(r io.Reader, w io.Writer) *frameServer {
+	return &frameServer{
+		r:           r,
+		out:         &frameWriter{w: bufio.NewWriter(w)},
+		syncReplies: make(map[uint32]chan frame),
+	}
+}
+
+// recv is the ReceiveMessageCallback of the served worker.
+func (s *frameServer) recv(msg string) {
+	s.out.write(frame{kind: frameRecv, payload: []byte(msg)})
+}
+
+// recvSync is the ReceiveSyncMessageCallback of the served worker. It blocks
+// until the other side answers.
+func (s *frameServer) recvSync(msg string) string {
+	ch := make(chan frame, 1)
+	s.syncLocker.Lock()
+	if s.closed {
+		s.syncLocker.Unlock()
+		return ""
+	}
+	s.syncId++
+	id := s.syncId
+	s.syncReplies[id] = ch
+	s.syncLocker.Unlock()
+
+	if err := s.out.write(frame{kind: frameRecvSync, id: id, payload: []byte(msg)}); err != nil {
+		s.syncLocker.Lock()
+		delete(s.syncReplies, id)
+		s.syncLocker.Unlock()
+		return ""
+	}
+	f, ok := <-ch
+	if !ok {
+		return ""
+	}
+	return string(f.payload)
+}
+
+func (s *frameServer) serve() error {
+	var wg sync.WaitGroup
+	var err error
+	for {
+		var f frame
+		f, err = readFrame(s.r)
+		if err != nil {
+			break
+		}
+		switch f.kind {
+		case frameTerminate:
+			// handled here since requests may be busy running javascript
+			s.worker.TerminateExecution()
+		case frameResult, frameError:
+			s.syncLocker.Lock()
+			ch := s.syncReplies[f.id]
+			delete(s.syncReplies, f.id)
+			s.syncLocker.Unlock()
+			if ch != nil {
+				ch <- f
+			}
+		default:
+			wg.Add(1)
+			go func(f frame) {
+				defer wg.Done()
+				s.handle(f)
+			}(f)
+		}
+	}
+
+	s.syncLocker.Lock()
+	s.closed = true
+	for id, ch := range s.syncReplies {
+		close(ch)
+		delete(s.syncReplies, id)
+	}
+	s.syncLocker.Unlock()
+	wg.Wait()
+
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (s *frameServer) handle(f frame) {
+	var res []byte
+	var err error
+	s.calls.Lock()
+	switch f.kind {
+	case frameAuth:
+		// the server has no token; accept anything
+	case frameLoad:
+		var req loadRequest
+		if err = json.Unmarshal(f.payload, &req); err == nil {
+			err = s.worker.LoadWithOptions(req.Origin, req.Code)
+		}
+	case frameSend:
+		err = s.worker.Send(string(f.payload))
+	case frameSendSync:
+		res = []byte(s.worker.SendSync(string(f.payload)))
+	case frameHeapStatistics:
+		res, err = json.Marshal(s.worker.GetHeapStatistics())
+	default:
+		err = errors.New("v8worker: unknown frame kind")
+	}
+	s.calls.Unlock()
+	if err != nil {
+		s.out.write(frame{kind: frameError, id: f.id, payload: []byte(err.Error())})
+	} else {
+		s.out.write(frame{kind: frameResult, id: f.id, payload: res})
+	}
+}
+
+type frameWriter struct {
+	sync.Mutex
+	w *bufio.Writer
+}
+
+func (fw *frameWriter) write(f frame) error {
+	fw.Lock()
+	defer fw.Unlock()
+	if err := writeFrame(fw.w, f); err != nil {
+		return err
+	}
+	return fw.w.Flush()
+}
@@ -0,0 +1,149 @@
+package v8worker
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newWorkerForConn(cb ReceiveMessageCallback, syncCB ReceiveSyncMessageCallback) (*Worker, error) {
+	return New(cb, syncCB), nil
+}
+
+func TestServeWorker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go ServeWorker(l, newWorkerForConn)
+
+	client, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	received := make(chan string, 1)
+	unsubscribe := client.Subscribe(func(msg string) {
+		received <- msg
+	})
+	defer unsubscribe()
+	client.HandleSync(func(msg string) string {
+		return msg + " exchanged"
+	})
+
+	err = client.Load("code.js", `
+		$recv(function(msg) { $send("got " + msg); });
+		$recvSync(function(msg) { return $sendSync(msg); });
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Send("hi"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-received, "got hi"; got != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+
+	// requests are multiplexed over the connection
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := "ping " + strconv.Itoa(i)
+			if got, want := client.SendSync(msg), msg+" exchanged"; got != want {
+				t.Errorf("got %q want %q", got, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// concurrent failing and succeeding loads get their own results
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				if err := client.Load("ok.js", `var ok = 1;`); err != nil {
+					t.Error(err)
+				}
+			} else if err := client.Load("fail.js", `throw new Error("fail")`); err == nil || !strings.Contains(err.Error(), "fail.js") {
+				t.Error("Expected error of fail.js", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := client.Load("error.js", `throw new Error("Error")`); err == nil {
+		t.Fatal("Expected error")
+	}
+}
+
+func TestServeWorkerToken(t *testing.T) {
+	srv := &Server{Factory: newWorkerForConn, Token: "secret"}
+
+	serverConn, clientConn := net.Pipe()
+	go srv.ServeConn(serverConn)
+	if _, err := NewClient(clientConn, "wrong"); err != ErrUnauthorized {
+		t.Fatal("Expected ErrUnauthorized", err)
+	}
+
+	serverConn, clientConn = net.Pipe()
+	go srv.ServeConn(serverConn)
+	client, err := NewClient(clientConn, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Load("code.js", `$print("authorized")`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientSubscriberRequests(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	go (&Server{Factory: newWorkerForConn}).ServeConn(serverConn)
+	client, err := NewClient(clientConn, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the callback makes a request whose reply is read by the client while
+	// the callback is running
+	received := make(chan string, 2)
+	client.Subscribe(func(msg string) {
+		received <- msg
+		if msg == "first" {
+			if err := client.Send("again"); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	err = client.Load("code.js", `
+		var n = 0;
+		$recv(function(msg) { $send(n++ === 0 ? "first" : "second"); });
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Send("go"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"first", "second"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("got %q want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("subscriber deadlocked")
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package v8worker
+
+/*
+#include <stdlib.h>
+#include "binding.h"
+*/
+import "C"
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// streamChunkSize matches the size of the chunks GoSourceStream asks for.
+const streamChunkSize = 32 * 1024
+
+var (
+	streamIdSequence int
+	streamsMapLocker sync.Mutex
+	streamsMap       = make(map[int]*sourceStream)
+)
+
+// sourceStream pumps an io.Reader into V8's background parser and keeps the
+// full source, which V8 needs again to finish compilation.
+type sourceStream struct {
+	ctx    context.Context
+	chunks chan []byte
+	source bytes.Buffer
+	err    error
+}
+
+func newSourceStream(ctx context.Context, r io.Reader) *sourceStream {
+	s := &sourceStream{
+		ctx:    ctx,
+		chunks: make(chan []byte, 4),
+	}
+	go s.pump(r)
+	return s
+}
+
+// pump reads r in its own goroutine so a blocked Read doesn't prevent
+// cancellation.
+func (s *sourceStream) pump(r io.Reader) {
+	defer close(s.chunks)
+	for {
+		buf := make([]byte, streamChunkSize)
+		n, err := r.Read(buf)
+		if n > 0 {
+			select {
+			case s.chunks <- buf[:n]:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// the error must be set before V8 sees the end of the stream
+			s.setErr(err)
+			select {
+			case s.chunks <- nil:
+			case <-s.ctx.Done():
+			}
+			return
+		}
+	}
+}
+
+func (s *sourceStream) setErr(err error) {
+	streamsMapLocker.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	streamsMapLocker.Unlock()
+}
+
+// read copies the next chunk into buf. It returns 0 at the end of the stream,
+// on read errors and once the context is done.
+func (s *sourceStream) read(buf []byte) int {
+	var chunk []byte
+	var ok bool
+	select {
+	case chunk, ok = <-s.chunks:
+	case <-s.ctx.Done():
+		s.setErr(s.ctx.Err())
+		return 0
+	}
+	if !ok || chunk == nil {
+		return 0
+	}
+	n := copy(buf, chunk)
+	s.source.Write(chunk[:n])
+	return n
+}
+
+//export streamReadCb
+func streamReadCb(streamId int, buf *C.char, size C.int) C.int {
+	streamsMapLocker.Lock()
+	s := streamsMap[streamId]
+	streamsMapLocker.Unlock()
+	if s == nil {
+		return 0
+	}
+	return C.int(s.read(unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(size))))
+}
+
+// LoadStream loads and executes a UTF-8 javascript source read from r. V8
+// parses the source on the calling thread as it arrives, without locking the
+// isolate, so the worker keeps serving other calls until the script is
+// complete. Reading stops with ctx.Err() once ctx is done.
+func (w *Worker) LoadStream(ctx context.Context, origin *ScriptOrigin, r io.Reader) error {
+	s := newSourceStream(ctx, r)
+	streamsMapLocker.Lock()
+	id := streamIdSequence
+	streamIdSequence++
+	streamsMap[id] = s
+	streamsMapLocker.Unlock()
+	defer func() {
+		streamsMapLocker.Lock()
+		delete(streamsMap, id)
+		streamsMapLocker.Unlock()
+	}()
+
+	cStream := C.worker_stream_new(w.cWorker, C.int(id))
+	defer C.stream_dispose(cStream)
+	C.stream_run(cStream)
+
+	streamsMapLocker.Lock()
+	err := s.err
+	streamsMapLocker.Unlock()
+	if err != nil {
+		return err
+	}
+
+	cCode := C.CString(s.source.String())
+	defer C.free(unsafe.Pointer(cCode))
+
+	o := newCScriptOrigin(origin)
+	defer o.free()
+
+	call := w.startCall("LoadStream " + o.scriptName)
+	defer w.endCall(call)
+	res := C.worker_load_stream(w.cWorker, cStream, cCode, o.name, o.lineOffset, o.columnOffset, o.isSharedCrossOrigin, o.scriptId, o.isEmbedderDebugScript, o.sourceMapURL, o.isOpaque)
+	return w.loadError(res)
+}
@@ -0,0 +1,78 @@
+package v8worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadStream(t *testing.T) {
+	var caught string
+	worker := New(func(msg string) {
+		caught = msg
+	}, DiscardSendSync)
+
+	r, w := io.Pipe()
+	go func() {
+		// split a multi-byte character across writes
+		parts := []string{`var greeting = "h`, "\xc3", "\xa9llo\";\n", strings.Repeat("var x = 1;\n", 10000), `$send(greeting);`}
+		for _, part := range parts {
+			w.Write([]byte(part))
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.Close()
+	}()
+
+	err := worker.LoadStream(context.Background(), &ScriptOrigin{ScriptName: "stream.js"}, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caught != "héllo" {
+		t.Fatal("bad msg", caught)
+	}
+
+	err = worker.LoadStream(context.Background(), &ScriptOrigin{ScriptName: "error.js"}, strings.NewReader(`throw new Error("Error")`))
+	if err == nil || !strings.Contains(err.Error(), "error.js:1") {
+		t.Fatal("Expected error", err)
+	}
+}
+
+func TestLoadStreamCancel(t *testing.T) {
+	worker := New(func(msg string) {}, DiscardSendSync)
+
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte(`$print("never finished");`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := worker.LoadStream(ctx, nil, r)
+	if err != context.DeadlineExceeded {
+		t.Fatal("Expected context.DeadlineExceeded", err)
+	}
+}
+
+func TestLoadStreamReadError(t *testing.T) {
+	var caught []string
+	worker := New(func(msg string) {
+		caught = append(caught, msg)
+	}, DiscardSendSync)
+
+	r, w := io.Pipe()
+	readErr := errors.New("connection reset")
+	go func() {
+		// a complete statement, then the stream fails
+		w.Write([]byte(`$send("partial");` + "\n"))
+		w.CloseWithError(readErr)
+	}()
+	err := worker.LoadStream(context.Background(), &ScriptOrigin{ScriptName: "truncated.js"}, r)
+	if err != readErr {
+		t.Fatal("Expected the read error", err)
+	}
+	if len(caught) != 0 {
+		t.Fatal("truncated script was executed", caught)
+	}
+}
@@ -0,0 +1,98 @@
+package v8worker
+
+import (
+	"crypto/sha256"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/getblank/v8worker/v8ts"
+)
+
+// TypeScriptOptions configures the transpiling of TypeScript and JSX, see
+// LoadTypeScript.
+type TypeScriptOptions = v8ts.Options
+
+// maxTranspiled is the number of transpiled scripts kept in the cache.
+const maxTranspiled = 256
+
+var (
+	transpiledLocker sync.Mutex
+	transpiled       = map[[sha256.Size]byte]*v8ts.Result{}
+	transpiledOrder  [][sha256.Size]byte // oldest first
+)
+
+// LoadTypeScript transpiles the TypeScript code with the package v8ts and
+// executes it like LoadWithOptions. Types are removed without type checking
+// and, in .tsx and .jsx scripts, JSX is compiled to React.createElement calls
+// unless the worker's Config.TypeScript says otherwise.
+//
+// The lines of the script are kept, so errors and stack traces point at the
+// TypeScript lines, and SourceMapURL is set to an inline source map. Syntax
+// errors are returned as *v8ts.Error. Transpiled scripts are cached by their
+// content.
+func (w *Worker) LoadTypeScript(origin *ScriptOrigin, code string) error {
+	var o ScriptOrigin
+	if origin != nil {
+		o = *origin
+	}
+	if o.ScriptName == "" {
+		o.ScriptName = nextScriptName()
+	}
+	res, err := transpile(o.ScriptName, code, w.typescript)
+	if err != nil {
+		return err
+	}
+	o.SourceMapURL = res.SourceMapURL()
+	return w.LoadWithOptions(&o, res.Code)
+}
+
+func transpile(filename, code string, opts *TypeScriptOptions) (*v8ts.Result, error) {
+	h := sha256.New()
+	h.Write([]byte(filename + "\x00"))
+	if opts != nil {
+		h.Write([]byte(opts.JSXFactory + "\x00" + opts.JSXFragment + "\x00"))
+		if opts.JSX {
+			h.Write([]byte("jsx"))
+		}
+	}
+	h.Write([]byte("\x00" + code))
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	transpiledLocker.Lock()
+	res := transpiled[key]
+	transpiledLocker.Unlock()
+	if res != nil {
+		return res, nil
+	}
+
+	res, err := v8ts.Transpile(filename, code, opts)
+	if err != nil {
+		return nil, err
+	}
+	transpiledLocker.Lock()
+	if _, ok := transpiled[key]; !ok {
+		if len(transpiledOrder) == maxTranspiled {
+			delete(transpiled, transpiledOrder[0])
+			transpiledOrder = transpiledOrder[1:]
+		}
+		transpiled[key] = res
+		transpiledOrder = append(transpiledOrder, key)
+	}
+	transpiledLocker.Unlock()
+	return res, nil
+}
+
+// isTypeScript reports whether LoadFile and LoadFS transpile the file name.
+func (w *Worker) isTypeScript(name string) bool {
+	if w.typescript == nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(filepath.ToSlash(name))) {
+	case ".ts", ".tsx", ".jsx":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package v8worker
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadTypeScript(t *testing.T) {
+	var caught []string
+	worker := New(func(msg string) {
+		caught = append(caught, msg)
+	}, DiscardSendSync)
+	code := `enum Color { Red, Green }
+interface Point { x: number; y: number }
+function show(p: Point, c: Color): string {
+	return p.x + "," + p.y + ":" + Color[c];
+}
+$send(show({ x: 1, y: 2 } as Point, Color.Green));
+`
+	if err := worker.LoadTypeScript(&ScriptOrigin{ScriptName: "code.ts"}, code); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caught, ",") != "1,2:Green" {
+		t.Fatal("bad result", caught)
+	}
+
+	err := worker.LoadTypeScript(&ScriptOrigin{ScriptName: "error.ts"}, "type A = string;\n\nlet a: A = undefinedVariable;")
+	if err == nil || !strings.Contains(err.Error(), "error.ts:3") {
+		t.Fatal("Expected error at the TypeScript line", err)
+	}
+	// like Load, a nil origin gets a generated name
+	caught = nil
+	if err := worker.LoadTypeScript(nil, `let n: number = 1; $send(String(n));`); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caught, ",") != "1" {
+		t.Fatal("bad result", caught)
+	}
+	err = worker.LoadTypeScript(nil, `let n: number = ;`)
+	if err == nil || !strings.HasPrefix(err.Error(), "VM") {
+		t.Fatal("Expected syntax error in a generated script name", err)
+	}
+
+	err = worker.LoadTypeScript(&ScriptOrigin{ScriptName: "syntax.ts"}, "let a: = 1;")
+	if err == nil || !strings.Contains(err.Error(), "syntax.ts:1:8") {
+		t.Fatal("Expected syntax error", err)
+	}
+}
+
+func TestLoadFSTypeScript(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.ts":  {Data: []byte(`const a: string = "a"; $send(a);`)},
+		"b.tsx": {Data: []byte(`$send(<b id="x" />);`)},
+	}
+	var caught []string
+	worker := NewWithConfig(func(msg string) {
+		caught = append(caught, msg)
+	}, DiscardSendSync, &Config{TypeScript: &TypeScriptOptions{JSXFactory: "h"}})
+	if err := worker.Load("h.js", `function h(tag, props) { return tag + "#" + props.id; }`); err != nil {
+		t.Fatal(err)
+	}
+	if err := worker.LoadFS(fsys, "*.ts*"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caught, ",") != "a,b#x" {
+		t.Fatal("bad result", caught)
+	}
+}
@@ -0,0 +1,48 @@
+// Package v8runtime declares the interface shared by the javascript runtimes of
+// package v8worker: Worker, RemoteWorker and Client. It doesn't depend on V8
+// or cgo, so code written against Runtime builds and can be tested without
+// them, see package v8workertest.
+package v8runtime
+
+// Runtime runs javascript. The $recv and $recvSync callbacks registered by
+// the scripts receive the messages of Send and SendSync.
+type Runtime interface {
+	// Load loads and executes a javascript file named scriptName.
+	Load(scriptName string, code string) error
+	// LoadWithOptions loads and executes a javascript file with the
+	// ScriptOrigin specified by origin.
+	LoadWithOptions(origin *ScriptOrigin, code string) error
+	// Send calls the $recv callback with msg.
+	Send(msg string) error
+	// SendSync calls the $recvSync callback with msg and returns its result.
+	// Exceptions are returned as "err: " followed by the exception.
+	SendSync(msg string) string
+	// TerminateExecution terminates the javascript running.
+	TerminateExecution()
+	// GetHeapStatistics returns statistics about the V8 heap.
+	GetHeapStatistics() *HeapStatistics
+}
+
+// ScriptOrigin represents V8 class – see http://v8.paulfryzel.com/docs/master/classv8_1_1_script_origin.html
+type ScriptOrigin struct {
+	ScriptName            string
+	LineOffset            int32
+	ColumnOffset          int32
+	IsSharedCrossOrigin   bool
+	ScriptId              int32
+	IsEmbedderDebugScript bool
+	SourceMapURL          string
+	IsOpaque              bool
+}
+
+// HeapStatistics represents V8 class - see http://v8.paulfryzel.com/docs/master/classv8_1_1_heap_statistics.html
+type HeapStatistics struct {
+	TotalHeapSize           int
+	TotalHeapSizeExecutable int
+	TotalPhysicalSize       int
+	TotalAvailableSize      int
+	UsedHeapSize            int
+	HeapSizeLimit           int
+	MallocedMemory          int
+	DoesZapGarbage          int
+}
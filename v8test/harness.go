@@ -0,0 +1,184 @@
+package v8test
+
+// resultPrefix marks the $send messages of the harness.
+const resultPrefix = "\x00v8test\x00"
+
+// harnessScript defines describe, it, beforeEach, afterEach and expect, and
+// the global $v8test used by Run to list and run the tests.
+const harnessScript = `var $v8test = (function (global) {
+	var root = { name: "", suites: [], tests: [], beforeEach: [], afterEach: [], parent: null };
+	var current = root;
+	var tests = [];
+
+	function AssertionError(message) {
+		this.name = "AssertionError";
+		this.message = message;
+		this.stack = new Error(message).stack.replace(/^Error/, "AssertionError");
+	}
+	AssertionError.prototype = Object.create(Error.prototype);
+	AssertionError.prototype.constructor = AssertionError;
+
+	function format(v) {
+		if (typeof v === "string") return JSON.stringify(v);
+		if (typeof v === "function") return "[Function" + (v.name ? ": " + v.name : "") + "]";
+		if (v instanceof Error) return String(v);
+		try {
+			var s = JSON.stringify(v);
+			if (s !== undefined) return s;
+		} catch (e) {}
+		return String(v);
+	}
+
+	function equal(a, b) {
+		if (a === b) return true;
+		if (a !== a && b !== b) return true;
+		if (typeof a !== "object" || typeof b !== "object" || a === null || b === null) return false;
+		if (Object.prototype.toString.call(a) !== Object.prototype.toString.call(b)) return false;
+		if (a instanceof Date) return a.getTime() === b.getTime();
+		var ka = Object.keys(a), kb = Object.keys(b);
+		if (ka.length !== kb.length) return false;
+		for (var i = 0; i < ka.length; i++) {
+			if (!Object.prototype.hasOwnProperty.call(b, ka[i]) || !equal(a[ka[i]], b[ka[i]])) return false;
+		}
+		return true;
+	}
+
+	function expect(actual) {
+		function matchers(negate) {
+			function check(pass, message) {
+				if (pass === negate) {
+					throw new AssertionError("expected " + format(actual) + (negate ? " not " : " ") + message);
+				}
+			}
+			return {
+				toBe: function (expected) { check(actual === expected, "to be " + format(expected)); },
+				toEqual: function (expected) { check(equal(actual, expected), "to equal " + format(expected)); },
+				toBeTruthy: function () { check(!!actual, "to be truthy"); },
+				toBeFalsy: function () { check(!actual, "to be falsy"); },
+				toBeNull: function () { check(actual === null, "to be null"); },
+				toBeUndefined: function () { check(actual === undefined, "to be undefined"); },
+				toBeDefined: function () { check(actual !== undefined, "to be defined"); },
+				toBeGreaterThan: function (n) { check(actual > n, "to be greater than " + format(n)); },
+				toBeLessThan: function (n) { check(actual < n, "to be less than " + format(n)); },
+				toContain: function (item) { check(actual.indexOf(item) >= 0, "to contain " + format(item)); },
+				toMatch: function (re) { check(new RegExp(re).test(actual), "to match " + String(re)); },
+				toThrow: function (expected) {
+					var thrown = false, error;
+					try {
+						actual();
+					} catch (e) {
+						thrown = true;
+						error = e;
+					}
+					if (thrown && expected !== undefined) {
+						var msg = error && error.message !== undefined ? error.message : String(error);
+						thrown = expected instanceof RegExp ? expected.test(msg) : msg.indexOf(expected) >= 0;
+					}
+					check(thrown, "to throw" + (expected !== undefined ? " " + format(expected) : ""));
+				}
+			};
+		}
+		var m = matchers(false);
+		m.not = matchers(true);
+		return m;
+	}
+
+	function describe(name, fn) {
+		var suite = { name: String(name), suites: [], tests: [], beforeEach: [], afterEach: [], parent: current };
+		current.suites.push(suite);
+		current = suite;
+		try {
+			fn();
+		} finally {
+			current = suite.parent;
+		}
+	}
+
+	// it registers a test. timeout, in milliseconds, overrides the default.
+	function it(name, fn, timeout) {
+		var test = { id: tests.length, name: String(name), fn: fn, timeout: timeout || 0, suite: current };
+		tests.push(test);
+		current.tests.push(test);
+	}
+
+	// errorString returns the stack trace of e without the frames of the
+	// harness.
+	function errorString(e) {
+		if (e instanceof Error && typeof e.stack === "string") {
+			return e.stack.split("\n").filter(function (line) {
+				return line.indexOf("(v8test:") < 0 && line.indexOf("at v8test:") < 0;
+			}).join("\n");
+		}
+		return "uncaught " + format(e);
+	}
+
+	function tree(suite) {
+		return {
+			name: suite.name,
+			tests: suite.tests.map(function (t) { return { id: t.id, name: t.name, timeout: t.timeout }; }),
+			suites: suite.suites.map(tree)
+		};
+	}
+
+	function hooks(suite, kind) {
+		var list = [];
+		for (var s = suite; s; s = s.parent) {
+			list = s[kind].concat(list);
+		}
+		return kind === "afterEach" ? list.reverse() : list;
+	}
+
+	var state;
+
+	return {
+		globals: { describe: describe, it: it, expect: expect, AssertionError: AssertionError },
+		list: function () {
+			$send("` + resultPrefix + `" + JSON.stringify(tree(root)));
+		},
+		// run starts a test. A test returning a promise is settled once the
+		// microtasks have run, when report is called.
+		run: function (id) {
+			var test = tests[id];
+			state = { test: test, error: null, settled: true };
+			try {
+				hooks(test.suite, "beforeEach").forEach(function (fn) { fn(); });
+				var result = test.fn();
+				if (result && typeof result.then === "function") {
+					state.settled = false;
+					var s = state;
+					result.then(function () {
+						s.settled = true;
+					}, function (e) {
+						s.settled = true;
+						s.error = errorString(e);
+					});
+				}
+			} catch (e) {
+				state.error = errorString(e);
+			}
+		},
+		report: function () {
+			var s = state;
+			if (!s.settled && !s.error) {
+				s.error = "test returned a promise which was never settled";
+			}
+			hooks(s.test.suite, "afterEach").forEach(function (fn) {
+				try {
+					fn();
+				} catch (e) {
+					if (!s.error) s.error = errorString(e);
+				}
+			});
+			$send("` + resultPrefix + `" + JSON.stringify({ error: s.error }));
+		},
+		beforeEach: function (fn) { current.beforeEach.push(fn); },
+		afterEach: function (fn) { current.afterEach.push(fn); }
+	};
+})(this);
+var describe = $v8test.globals.describe;
+var it = $v8test.globals.it;
+var expect = $v8test.globals.expect;
+var AssertionError = $v8test.globals.AssertionError;
+var beforeEach = $v8test.beforeEach;
+var afterEach = $v8test.afterEach;
+`
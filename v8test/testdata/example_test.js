@@ -0,0 +1,59 @@
+function sum(a, b) {
+	return a + b;
+}
+
+describe("expect", function () {
+	it("compares values", function () {
+		expect(sum(1, 2)).toBe(3);
+		expect(sum(1, 2)).not.toBe(4);
+		expect({ a: [1, 2], b: null }).toEqual({ a: [1, 2], b: null });
+		expect([1, 2, 3]).toContain(2);
+		expect("hello world").toMatch(/wor/);
+		expect(2).toBeGreaterThan(1);
+		expect(undefined).toBeUndefined();
+	});
+
+	it("catches exceptions", function () {
+		expect(function () { throw new Error("boom"); }).toThrow("boom");
+		expect(function () {}).not.toThrow();
+	});
+
+	it("reports assertion failures", function () {
+		var err;
+		try {
+			expect(1).toBe(2);
+		} catch (e) {
+			err = e;
+		}
+		expect(err instanceof AssertionError).toBe(true);
+		expect(err.message).toBe("expected 1 to be 2");
+		expect(err.stack).toMatch(/example_test\.js/);
+	});
+});
+
+describe("hooks", function () {
+	var log = [];
+	beforeEach(function () { log.push("before"); });
+	afterEach(function () { log.push("after"); });
+
+	// tests run before nested suites
+	it("runs beforeEach", function () {
+		expect(log).toEqual(["before"]);
+	});
+
+	describe("nested", function () {
+		beforeEach(function () { log.push("inner"); });
+
+		it("runs outer hooks first", function () {
+			expect(log).toEqual(["before", "after", "before", "inner"]);
+		});
+	});
+});
+
+describe("promises", function () {
+	it("waits for resolved promises", function () {
+		return Promise.resolve(42).then(function (v) {
+			expect(v).toBe(42);
+		});
+	});
+});
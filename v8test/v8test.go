@@ -0,0 +1,165 @@
+// Package v8test runs javascript unit tests inside a v8worker.Worker as Go
+// subtests.
+//
+// Test files use a small describe/it/expect harness:
+//
+//	describe("sum", function () {
+//		beforeEach(function () { ... });
+//		it("adds numbers", function () {
+//			expect(sum(1, 2)).toBe(3);
+//		});
+//		it("is slow", function () { ... }, 5000); // timeout in ms
+//	});
+//
+// and are run from a Go test:
+//
+//	func TestJS(t *testing.T) {
+//		v8test.Run(t, "lib.js", "lib_test.js")
+//	}
+//
+// Every describe becomes a t.Run subtest and every it a nested one. Failures
+// are reported with the javascript stack trace. A test may return a promise,
+// which must be settled once the microtasks have run since workers have no
+// event loop. Tests running longer than their timeout are stopped with
+// TerminateExecution.
+package v8test
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getblank/v8worker"
+)
+
+// DefaultTimeout is the timeout of tests which don't set their own.
+var DefaultTimeout = 5 * time.Second
+
+type suite struct {
+	Name   string  `json:"name"`
+	Tests  []test  `json:"tests"`
+	Suites []suite `json:"suites"`
+}
+
+type test struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Timeout int    `json:"timeout"` // ms
+}
+
+type result struct {
+	Error *string `json:"error"`
+}
+
+// runner holds the worker running the tests and the test currently logging
+// its $send messages.
+type runner struct {
+	worker *v8worker.Worker
+	t      *testing.T
+	reply  string
+}
+
+// Run loads the harness and files, in order, into a new worker and runs the
+// tests they define as subtests of t. Messages sent with $send are logged to
+// the running test; $sendSync is answered with an empty string.
+func Run(t *testing.T, files ...string) {
+	t.Helper()
+	r := &runner{t: t}
+	r.worker = v8worker.New(r.recv, func(msg string) string {
+		return ""
+	})
+	if err := r.worker.Load("v8test:harness.js", harnessScript); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range files {
+		if err := r.worker.LoadFile(filename); err != nil {
+			t.Fatalf("loading %s: %v", filename, err)
+		}
+	}
+
+	if err := r.call("$v8test.list()"); err != nil {
+		t.Fatal(err)
+	}
+	var root suite
+	if err := json.Unmarshal([]byte(r.reply), &root); err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Tests) == 0 && len(root.Suites) == 0 {
+		t.Fatal("v8test: no tests defined in " + strings.Join(files, ", "))
+	}
+	r.runSuite(t, root)
+}
+
+func (r *runner) recv(msg string) {
+	if strings.HasPrefix(msg, resultPrefix) {
+		r.reply = msg[len(resultPrefix):]
+		return
+	}
+	r.t.Log("$send:", msg)
+}
+
+// call runs code and returns the error of the javascript, if any.
+func (r *runner) call(code string) error {
+	r.reply = ""
+	return r.worker.Load("v8test", code)
+}
+
+func (r *runner) runSuite(t *testing.T, s suite) {
+	for _, tt := range s.Tests {
+		tt := tt
+		t.Run(tt.Name, func(t *testing.T) {
+			r.runTest(t, tt)
+		})
+	}
+	for _, sub := range s.Suites {
+		sub := sub
+		t.Run(sub.Name, func(t *testing.T) {
+			r.runSuite(t, sub)
+		})
+	}
+}
+
+func (r *runner) runTest(t *testing.T, tt test) {
+	parent := r.t
+	r.t = t
+	defer func() { r.t = parent }()
+
+	timeout := DefaultTimeout
+	if tt.Timeout > 0 {
+		timeout = time.Duration(tt.Timeout) * time.Millisecond
+	}
+	fired := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() {
+		r.worker.TerminateExecution()
+		close(fired)
+	})
+	err := r.call("$v8test.run(" + strconv.Itoa(tt.Id) + ")")
+	if err == nil {
+		err = r.call("$v8test.report()")
+	}
+	reply := r.reply
+	if !timer.Stop() {
+		<-fired
+		var terminated *v8worker.TerminatedError
+		if errors.As(err, &terminated) {
+			t.Fatalf("timed out after %v", timeout)
+		}
+		// the termination came after the test returned, drop it before it
+		// hits the next test
+		r.worker.CancelTerminateExecution()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res result
+	if err := json.Unmarshal([]byte(reply), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Error != nil {
+		t.Fatal(*res.Error)
+	}
+}
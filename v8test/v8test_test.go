@@ -0,0 +1,37 @@
+package v8test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getblank/v8worker"
+)
+
+func TestRun(t *testing.T) {
+	Run(t, "testdata/example_test.js")
+}
+
+func TestList(t *testing.T) {
+	r := &runner{t: t}
+	r.worker = v8worker.New(r.recv, func(msg string) string { return "" })
+	if err := r.worker.Load("v8test:harness.js", harnessScript); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.worker.LoadFile("testdata/example_test.js"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.call("$v8test.list()"); err != nil {
+		t.Fatal(err)
+	}
+	var root suite
+	if err := json.Unmarshal([]byte(r.reply), &root); err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Suites) != 3 || root.Suites[1].Name != "hooks" {
+		t.Fatal("bad suites", r.reply)
+	}
+	hooks := root.Suites[1]
+	if len(hooks.Tests) != 1 || len(hooks.Suites) != 1 || hooks.Suites[0].Tests[0].Name != "runs outer hooks first" {
+		t.Fatal("bad hooks suite", r.reply)
+	}
+}
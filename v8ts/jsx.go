@@ -0,0 +1,377 @@
+package v8ts
+
+import (
+	"strconv"
+	"strings"
+)
+
+// jsxOut replaces an element with the factory calls generated for it. The
+// code of expressions and nested elements is kept where it is, the text
+// generated between them replaces the JSX syntax around them.
+type jsxOut struct {
+	p   *parser
+	pos int // start of the source not emitted yet
+	buf strings.Builder
+}
+
+func (o *jsxOut) text(s string) {
+	o.buf.WriteString(s)
+}
+
+// keep emits the generated text in place of the source up to start and keeps
+// src[start:end].
+func (o *jsxOut) keep(start, end int) {
+	o.flush(start)
+	o.pos = end
+}
+
+func (o *jsxOut) flush(end int) {
+	o.p.replace(o.pos, end, o.buf.String())
+	o.buf.Reset()
+	o.pos = end
+}
+
+type jsxAttr struct {
+	name       string
+	spread     bool
+	value      string // generated value, if start == end
+	start, end int    // kept value
+}
+
+type jsxChild struct {
+	text       string
+	spread     bool
+	start, end int // kept child, if text is ""
+}
+
+// parseJSX parses the element at the current < token and continues after it.
+func (p *parser) parseJSX() {
+	end := p.parseJSXElement(p.tok.start)
+	p.prev = token{kind: tPunct, start: end - 1, end: end, text: ">", nl: false}
+	p.tok = p.lex(end)
+}
+
+// parseJSXElement parses the element starting with the < at lt and returns
+// the position following it.
+func (p *parser) parseJSXElement(lt int) int {
+	src := p.src
+	i := p.jsxSpace(lt + 1)
+	var tag, name string
+	if i < len(src) && src[i] == '>' {
+		tag = p.opts.jsxFragment()
+	} else {
+		name, i = p.jsxName(i)
+		tag = jsxTag(name)
+	}
+
+	var attrs []jsxAttr
+	selfClosing := false
+	for {
+		i = p.jsxSpace(i)
+		if i >= len(src) {
+			p.failAt(lt, "unterminated JSX element")
+		}
+		if src[i] == '/' {
+			if i+1 >= len(src) || src[i+1] != '>' {
+				p.failAt(i, "expected />")
+			}
+			selfClosing = true
+			i += 2
+			break
+		}
+		if src[i] == '>' {
+			i++
+			break
+		}
+		if src[i] == '{' {
+			// {...props}
+			p.tok = p.lex(i + 1)
+			if !p.tok.is("...") {
+				p.failAt(p.tok.start, "expected ...")
+			}
+			p.next()
+			start := p.tok.start
+			p.parseAssign()
+			attrs = append(attrs, jsxAttr{spread: true, start: start, end: p.prev.end})
+			i = p.jsxClose()
+			continue
+		}
+		var attr jsxAttr
+		attr.name, i = p.jsxName(i)
+		i = p.jsxSpace(i)
+		if i >= len(src) || src[i] != '=' {
+			attr.value = "true"
+			attrs = append(attrs, attr)
+			continue
+		}
+		i = p.jsxSpace(i + 1)
+		switch {
+		case i < len(src) && (src[i] == '"' || src[i] == '\''):
+			end := strings.IndexByte(src[i+1:], src[i])
+			if end < 0 {
+				p.failAt(i, "unterminated string literal")
+			}
+			attr.value = jsString(decodeEntities(src[i+1 : i+1+end]))
+			i += end + 2
+		case i < len(src) && src[i] == '{':
+			p.tok = p.lex(i + 1)
+			attr.start = p.tok.start
+			p.parseAssign()
+			attr.end = p.prev.end
+			i = p.jsxClose()
+		case i < len(src) && src[i] == '<':
+			attr.start = i
+			i = p.parseJSXElement(i)
+			attr.end = i
+		default:
+			p.failAt(i, "expected JSX attribute value")
+		}
+		attrs = append(attrs, attr)
+	}
+
+	var children []jsxChild
+	if !selfClosing {
+		children, i = p.parseJSXChildren(lt, i, name)
+	}
+
+	o := &jsxOut{p: p, pos: lt}
+	o.text(p.opts.jsxFactory() + "(" + tag)
+	o.attrs(attrs)
+	for _, c := range children {
+		o.text(", ")
+		switch {
+		case c.text != "":
+			o.text(jsString(c.text))
+		case c.spread:
+			o.text("...")
+			o.keep(c.start, c.end)
+		default:
+			o.keep(c.start, c.end)
+		}
+	}
+	o.text(")")
+	o.flush(i)
+	return i
+}
+
+// attrs emits the props argument of an element.
+func (o *jsxOut) attrs(attrs []jsxAttr) {
+	if len(attrs) == 0 {
+		o.text(", null")
+		return
+	}
+	spread := false
+	for _, a := range attrs {
+		spread = spread || a.spread
+	}
+	if spread {
+		o.text(", Object.assign({}")
+	}
+	open := false
+	for _, a := range attrs {
+		if a.spread {
+			if open {
+				o.text("}")
+				open = false
+			}
+			o.text(", ")
+			o.keep(a.start, a.end)
+			continue
+		}
+		if open {
+			o.text(", ")
+		} else {
+			o.text(", {")
+			open = true
+		}
+		if identifier(a.name) {
+			o.text(a.name + ": ")
+		} else {
+			o.text(jsString(a.name) + ": ")
+		}
+		if a.start == a.end {
+			o.text(a.value)
+		} else {
+			o.keep(a.start, a.end)
+		}
+	}
+	if open {
+		o.text("}")
+	}
+	if spread {
+		o.text(")")
+	}
+}
+
+// parseJSXChildren parses the children starting at i of the element starting
+// at lt, up to and including its closing tag, and returns the position
+// following it.
+func (p *parser) parseJSXChildren(lt, i int, name string) ([]jsxChild, int) {
+	src := p.src
+	var children []jsxChild
+	for {
+		start := i
+		for i < len(src) && src[i] != '<' && src[i] != '{' {
+			i++
+		}
+		if text := jsxText(src[start:i]); text != "" {
+			children = append(children, jsxChild{text: text})
+		}
+		if i >= len(src) {
+			p.failAt(lt, "unterminated JSX element")
+		}
+		switch {
+		case src[i] == '<' && i+1 < len(src) && src[i+1] == '/':
+			j := p.jsxSpace(i + 2)
+			closing := ""
+			if j < len(src) && src[j] != '>' {
+				closing, j = p.jsxName(j)
+			}
+			j = p.jsxSpace(j)
+			if closing != name {
+				p.failAt(i, "expected </"+name+">")
+			}
+			if j >= len(src) || src[j] != '>' {
+				p.failAt(j, "expected >")
+			}
+			return children, j + 1
+		case src[i] == '<':
+			end := p.parseJSXElement(i)
+			children = append(children, jsxChild{start: i, end: end})
+			i = end
+		default:
+			p.tok = p.lex(i + 1)
+			if p.tok.is("}") {
+				// empty or comment
+				i = p.tok.end
+				continue
+			}
+			var c jsxChild
+			if p.tok.is("...") {
+				c.spread = true
+				p.next()
+			}
+			c.start = p.tok.start
+			p.parseAssign()
+			c.end = p.prev.end
+			children = append(children, c)
+			i = p.jsxClose()
+		}
+	}
+}
+
+// jsxClose checks that the current token closes an expression container and
+// returns the position following it.
+func (p *parser) jsxClose() int {
+	if !p.tok.is("}") {
+		p.failAt(p.tok.start, "expected }")
+	}
+	return p.tok.end
+}
+
+// jsxSpace skips white space and comments inside tags.
+func (p *parser) jsxSpace(i int) int {
+	t := p.lex(i)
+	return t.start
+}
+
+// jsxName scans a tag or attribute name like div, my-element, a:b or
+// Foo.Bar.
+func (p *parser) jsxName(i int) (string, int) {
+	src := p.src
+	start := i
+	for i < len(src) {
+		c := src[i]
+		if c == '-' || c == ':' || c == '.' || c >= '0' && c <= '9' && i > start {
+			i++
+			continue
+		}
+		if !isIdentStart(src, i) {
+			break
+		}
+		i = scanIdent(src, i)
+	}
+	if i == start {
+		p.failAt(i, "expected JSX name")
+	}
+	return src[start:i], i
+}
+
+// jsxTag returns the expression of the element type of the tag name: intrinsic
+// elements are strings, components references.
+func jsxTag(name string) string {
+	if strings.ContainsAny(name, "-:") || name[0] >= 'a' && name[0] <= 'z' && !strings.Contains(name, ".") {
+		return jsString(name)
+	}
+	return name
+}
+
+func identifier(s string) bool {
+	if s == "" || !isIdentStart(s, 0) {
+		return false
+	}
+	return scanIdent(s, 0) == len(s)
+}
+
+// jsxText trims the white space of the text of children like React does:
+// lines are trimmed, blank lines dropped and the others joined with a space.
+func jsxText(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	var out []string
+	for i, line := range lines {
+		if i > 0 {
+			line = strings.TrimLeft(line, " \t")
+		}
+		if i < len(lines)-1 {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return decodeEntities(strings.Join(out, " "))
+}
+
+var entities = map[string]string{
+	"amp": "&", "lt": "<", "gt": ">", "quot": `"`, "apos": "'", "nbsp": "\u00a0",
+	"copy": "©", "reg": "®", "trade": "™", "hellip": "…", "mdash": "—", "ndash": "–",
+	"laquo": "«", "raquo": "»", "middot": "·", "times": "×", "bull": "•",
+}
+
+// decodeEntities decodes the HTML entities of JSX text.
+func decodeEntities(s string) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		amp := strings.IndexByte(s, '&')
+		if amp < 0 {
+			break
+		}
+		semi := strings.IndexByte(s[amp:], ';')
+		if semi < 0 {
+			break
+		}
+		b.WriteString(s[:amp])
+		entity := s[amp+1 : amp+semi]
+		decoded, ok := entities[entity]
+		if strings.HasPrefix(entity, "#") {
+			var n uint64
+			var err error
+			if strings.HasPrefix(entity, "#x") {
+				n, err = strconv.ParseUint(entity[2:], 16, 32)
+			} else {
+				n, err = strconv.ParseUint(entity[1:], 10, 32)
+			}
+			decoded, ok = string(rune(n)), err == nil
+		}
+		if !ok {
+			decoded = "&" + entity + ";"
+		}
+		b.WriteString(decoded)
+		s = s[amp+semi+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
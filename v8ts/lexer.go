@@ -0,0 +1,250 @@
+package v8ts
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tEOF tokenKind = iota
+	tName
+	tPrivateName // #name
+	tNumber
+	tString
+	tTemplate // from ` or } up to and including ` or ${
+	tRegExp
+	tPunct
+)
+
+type token struct {
+	kind       tokenKind
+	start, end int
+	text       string
+	// nl is set if a line break precedes the token.
+	nl bool
+}
+
+// is reports whether t is the punctuator or name s.
+func (t token) is(s string) bool {
+	return (t.kind == tPunct || t.kind == tName) && t.text == s
+}
+
+// templateTail reports whether the template token t ends the literal.
+func (t token) templateTail() bool {
+	return strings.HasSuffix(t.text, "`") && len(t.text) > 1
+}
+
+// punctuators sorted so that longer ones match first.
+var punctuators = []string{
+	">>>=",
+	"...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "**", "<<", ">>",
+}
+
+// lex scans the token starting at or after pos.
+func (p *parser) lex(pos int) token {
+	src := p.src
+	nl := false
+	// skip white space and comments
+	for pos < len(src) {
+		c := src[pos]
+		switch {
+		case c == '\n' || c == '\r':
+			nl = true
+			pos++
+		case c == ' ' || c == '\t' || c == '\v' || c == '\f':
+			pos++
+		case c == '/' && pos+1 < len(src) && src[pos+1] == '/':
+			for pos < len(src) && src[pos] != '\n' && src[pos] != '\r' {
+				pos++
+			}
+		case c == '/' && pos+1 < len(src) && src[pos+1] == '*':
+			end := strings.Index(src[pos+2:], "*/")
+			if end < 0 {
+				p.failAt(pos, "unterminated comment")
+			}
+			if strings.ContainsAny(src[pos:pos+2+end], "\n\r") {
+				nl = true
+			}
+			pos += end + 4
+		case c >= utf8.RuneSelf:
+			r, n := utf8.DecodeRuneInString(src[pos:])
+			if r == '\u2028' || r == '\u2029' {
+				nl = true
+			} else if !unicode.IsSpace(r) && r != '\ufeff' {
+				return p.lexToken(pos, nl)
+			}
+			pos += n
+		default:
+			return p.lexToken(pos, nl)
+		}
+	}
+	return token{kind: tEOF, start: len(src), end: len(src), nl: true}
+}
+
+func (p *parser) lexToken(start int, nl bool) token {
+	src := p.src
+	c := src[start]
+	t := token{start: start, nl: nl}
+	switch {
+	case isIdentStart(src, start):
+		t.kind = tName
+		t.end = scanIdent(src, start)
+	case c == '#' && start+1 < len(src) && isIdentStart(src, start+1):
+		t.kind = tPrivateName
+		t.end = scanIdent(src, start+1)
+	case c >= '0' && c <= '9' || c == '.' && start+1 < len(src) && src[start+1] >= '0' && src[start+1] <= '9':
+		t.kind = tNumber
+		t.end = scanNumber(src, start)
+	case c == '"' || c == '\'':
+		t.kind = tString
+		t.end = p.scanString(start)
+	case c == '`':
+		t.kind = tTemplate
+		t.end = p.scanTemplate(start + 1)
+	default:
+		t.kind = tPunct
+		t.end = start + 1
+		for _, punct := range punctuators {
+			if strings.HasPrefix(src[start:], punct) {
+				t.end = start + len(punct)
+				break
+			}
+		}
+		// a?.5:1 is a conditional
+		if src[start:t.end] == "?." && t.end < len(src) && src[t.end] >= '0' && src[t.end] <= '9' {
+			t.end = start + 1
+		}
+	}
+	t.text = src[start:t.end]
+	return t
+}
+
+func isIdentStart(src string, i int) bool {
+	c := src[i]
+	if c == '$' || c == '_' || c == '\\' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+		return true
+	}
+	if c < utf8.RuneSelf {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(src[i:])
+	return unicode.IsLetter(r)
+}
+
+func scanIdent(src string, i int) int {
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '$' || c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9':
+			i++
+		case c == '\\':
+			// \uXXXX or \u{X...}
+			i++
+			for i < len(src) && (src[i] == 'u' || src[i] == '{' || src[i] == '}' || isHex(src[i])) {
+				i++
+				if src[i-1] == '}' {
+					break
+				}
+			}
+		case c >= utf8.RuneSelf:
+			r, n := utf8.DecodeRuneInString(src[i:])
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r) && !unicode.Is(unicode.Mc, r) &&
+				!unicode.Is(unicode.Pc, r) && r != '\u200c' && r != '\u200d' {
+				return i
+			}
+			i += n
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func scanNumber(src string, i int) int {
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '.':
+			i++
+			// exponent sign
+			if (c == 'e' || c == 'E') && i < len(src) && (src[i] == '+' || src[i] == '-') &&
+				!strings.HasPrefix(src[i-2:], "0x") && !strings.HasPrefix(src[i-2:], "0X") {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func (p *parser) scanString(start int) int {
+	src := p.src
+	quote := src[start]
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case quote:
+			return i + 1
+		case '\\':
+			i++
+		case '\n', '\r':
+			p.failAt(start, "unterminated string literal")
+		}
+	}
+	p.failAt(start, "unterminated string literal")
+	return 0
+}
+
+// scanTemplate scans a template literal part starting after its ` or }, up to
+// the closing ` or ${.
+func (p *parser) scanTemplate(i int) int {
+	src := p.src
+	start := i - 1
+	for ; i < len(src); i++ {
+		switch src[i] {
+		case '`':
+			return i + 1
+		case '\\':
+			i++
+		case '$':
+			if i+1 < len(src) && src[i+1] == '{' {
+				return i + 2
+			}
+		}
+	}
+	p.failAt(start, "unterminated template literal")
+	return 0
+}
+
+// scanRegExp scans the regular expression literal starting at start.
+func (p *parser) scanRegExp(start int) token {
+	src := p.src
+	class := false
+	i := start + 1
+	for ; ; i++ {
+		if i >= len(src) || src[i] == '\n' || src[i] == '\r' {
+			p.failAt(start, "unterminated regular expression")
+		}
+		c := src[i]
+		if c == '\\' {
+			i++
+		} else if c == '[' {
+			class = true
+		} else if c == ']' {
+			class = false
+		} else if c == '/' && !class {
+			break
+		}
+	}
+	end := scanIdent(src, i+1)
+	return token{kind: tRegExp, start: start, end: end, text: src[start:end], nl: p.tok.nl}
+}
@@ -0,0 +1,1702 @@
+package v8ts
+
+import "strings"
+
+// parser walks TypeScript code without building a syntax tree, recording the
+// edits turning it into javascript: types are blanked out and the few
+// constructs with a runtime meaning (enums, parameter properties, JSX) are
+// rewritten in place.
+type parser struct {
+	src  string
+	jsx  bool
+	opts *Options
+
+	tok  token // current token
+	prev token // last consumed token
+
+	edits []edit
+}
+
+// edit replaces src[start:end] with text.
+type edit struct {
+	start, end int
+	text       string
+}
+
+type parseError struct {
+	pos int
+	msg string
+}
+
+func (p *parser) failAt(pos int, msg string) {
+	panic(&parseError{pos: pos, msg: msg})
+}
+
+func (p *parser) unexpected() {
+	if p.tok.kind == tEOF {
+		p.failAt(p.tok.start, "unexpected end of input")
+	}
+	p.failAt(p.tok.start, "unexpected "+p.tok.text)
+}
+
+func (p *parser) next() {
+	p.prev = p.tok
+	p.tok = p.lex(p.tok.end)
+}
+
+func (p *parser) peek() token {
+	return p.lex(p.tok.end)
+}
+
+func (p *parser) expect(s string) {
+	if !p.tok.is(s) {
+		if p.tok.kind == tEOF {
+			p.failAt(p.tok.start, "expected "+s+", got end of input")
+		}
+		p.failAt(p.tok.start, "expected "+s+", got "+p.tok.text)
+	}
+	p.next()
+}
+
+// expectGreater consumes the > closing type parameters or arguments,
+// splitting tokens like >> and >=.
+func (p *parser) expectGreater() {
+	t := p.tok
+	if t.kind != tPunct || t.text[0] != '>' {
+		p.failAt(t.start, "expected >")
+	}
+	if t.text == ">" {
+		p.next()
+		return
+	}
+	p.prev = token{kind: tPunct, start: t.start, end: t.start + 1, text: ">", nl: t.nl}
+	p.tok = p.lexToken(t.start+1, false)
+}
+
+// semicolon consumes the end of a statement, allowing automatic semicolon
+// insertion.
+func (p *parser) semicolon() {
+	switch {
+	case p.tok.is(";"):
+		p.next()
+	case p.tok.is("}"), p.tok.kind == tEOF, p.tok.nl:
+	default:
+		p.unexpected()
+	}
+}
+
+type parserState struct {
+	tok, prev token
+	edits     int
+}
+
+// try runs f and reports whether it parsed without errors. Otherwise the
+// parser is rewound to where it was.
+func (p *parser) try(f func()) (ok bool) {
+	s := parserState{tok: p.tok, prev: p.prev, edits: len(p.edits)}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isParseError := r.(*parseError); !isParseError {
+				panic(r)
+			}
+			p.tok, p.prev, p.edits = s.tok, s.prev, p.edits[:s.edits]
+			ok = false
+		}
+	}()
+	f()
+	return true
+}
+
+// replace replaces src[start:end] with text followed by the line breaks of
+// the replaced code, keeping the following code on its line.
+func (p *parser) replace(start, end int, text string) {
+	p.edits = append(p.edits, edit{start, end, text + strings.Repeat("\n", strings.Count(p.src[start:end], "\n"))})
+}
+
+func (p *parser) insert(pos int, text string) {
+	p.edits = append(p.edits, edit{pos, pos, text})
+}
+
+// blank replaces src[start:end] with spaces, keeping line breaks and the
+// columns of the following code.
+func (p *parser) blank(start, end int) {
+	if start >= end {
+		return
+	}
+	var b strings.Builder
+	for _, r := range p.src[start:end] {
+		switch {
+		case r == '\n' || r == '\r':
+			b.WriteRune(r)
+		case r >= 0x10000:
+			b.WriteString("  ") // two UTF-16 code units
+		default:
+			b.WriteByte(' ')
+		}
+	}
+	p.edits = append(p.edits, edit{start, end, b.String()})
+}
+
+// strip blanks src[start:end], dropping the edits recorded in that range.
+func (p *parser) strip(start, end int) {
+	n := len(p.edits)
+	for n > 0 && p.edits[n-1].start >= start {
+		n--
+	}
+	p.edits = p.edits[:n]
+	p.blank(start, end)
+}
+
+var reserved = map[string]bool{
+	"break": true, "case": true, "catch": true, "class": true, "const": true, "continue": true,
+	"debugger": true, "default": true, "delete": true, "do": true, "else": true, "enum": true,
+	"export": true, "extends": true, "false": true, "finally": true, "for": true, "function": true,
+	"if": true, "import": true, "in": true, "instanceof": true, "new": true, "null": true,
+	"return": true, "super": true, "switch": true, "this": true, "throw": true, "true": true,
+	"try": true, "typeof": true, "var": true, "void": true, "while": true, "with": true,
+}
+
+// strictReserved are the other words reserved in strict mode code.
+var strictReserved = map[string]bool{
+	"implements": true, "interface": true, "let": true, "package": true, "private": true,
+	"protected": true, "public": true, "static": true, "yield": true, "await": true,
+	"arguments": true, "eval": true,
+}
+
+func isIdentifier(t token) bool {
+	return t.kind == tName && !reserved[t.text]
+}
+
+// Statements
+
+func (p *parser) parseProgram() {
+	p.tok = p.lex(0)
+	if strings.HasPrefix(p.src[p.tok.start:], "#!") {
+		end := strings.IndexAny(p.src[p.tok.start:], "\r\n")
+		if end < 0 {
+			return
+		}
+		p.tok = p.lex(p.tok.start + end)
+	}
+	for p.tok.kind != tEOF {
+		p.parseStatement()
+	}
+}
+
+func (p *parser) parseStatement() {
+	p.parseStatementAt(p.tok.start)
+}
+
+// parseStatementAt parses the statement at the current token, start being the
+// start of the statement including modifiers like export.
+func (p *parser) parseStatementAt(start int) {
+	t := p.tok
+	if p.parseTypeScriptStatement(start) {
+		return
+	}
+	switch {
+	case t.is("{"):
+		p.parseBlock()
+	case t.is(";"):
+		p.next()
+	case t.is("var"), t.is("const"), t.is("let") && p.startsBinding(p.peek()):
+		p.next()
+		p.parseVarDecls()
+		p.semicolon()
+	case t.is("function"):
+		p.parseFunction(start, true)
+	case t.is("async") && p.peek().is("function") && !p.peek().nl:
+		p.next()
+		p.parseFunction(start, true)
+	case t.is("class"):
+		p.parseClass()
+	case t.is("if"):
+		p.next()
+		p.parseParenExpression()
+		p.parseStatement()
+		if p.tok.is("else") {
+			p.next()
+			p.parseStatement()
+		}
+	case t.is("for"):
+		p.parseFor()
+	case t.is("while"), t.is("with"):
+		p.next()
+		p.parseParenExpression()
+		p.parseStatement()
+	case t.is("do"):
+		p.next()
+		p.parseStatement()
+		p.expect("while")
+		p.parseParenExpression()
+		if p.tok.is(";") {
+			p.next()
+		}
+	case t.is("return"), t.is("throw"):
+		p.next()
+		if !p.tok.is(";") && !p.tok.is("}") && !p.tok.nl && p.tok.kind != tEOF {
+			p.parseExpression()
+		}
+		p.semicolon()
+	case t.is("break"), t.is("continue"):
+		p.next()
+		if isIdentifier(p.tok) && !p.tok.nl {
+			p.next()
+		}
+		p.semicolon()
+	case t.is("switch"):
+		p.parseSwitch()
+	case t.is("try"):
+		p.parseTry()
+	case t.is("debugger"):
+		p.next()
+		p.semicolon()
+	case t.is("import") && !p.peek().is("(") && !p.peek().is("."):
+		p.parseImport(start)
+	case t.is("export"):
+		p.parseExport(start)
+	case t.is("@"):
+		p.failAt(t.start, "decorators are not supported")
+	case isIdentifier(t) && p.peek().is(":"):
+		// label
+		p.next()
+		p.next()
+		p.parseStatement()
+	default:
+		p.parseExpression()
+		p.semicolon()
+	}
+}
+
+// parseTypeScriptStatement parses the declarations of TypeScript, reporting
+// whether the current token starts one.
+func (p *parser) parseTypeScriptStatement(start int) bool {
+	t := p.tok
+	if t.kind != tName {
+		return false
+	}
+	next := p.peek()
+	sameLine := !next.nl
+	switch t.text {
+	case "interface":
+		if !isIdentifier(next) || !sameLine {
+			return false
+		}
+		p.skipInterface()
+	case "type":
+		if !isIdentifier(next) || !sameLine {
+			return false
+		}
+		p.skipTypeAlias()
+	case "declare":
+		if next.kind != tName || !sameLine {
+			return false
+		}
+		p.next()
+		p.skipDeclaration()
+	case "abstract":
+		if !next.is("class") || !sameLine {
+			return false
+		}
+		p.blank(t.start, t.end)
+		p.next()
+		p.parseClass()
+		return true
+	case "enum":
+		if !isIdentifier(next) {
+			return false
+		}
+		p.parseEnum(start)
+		return true
+	case "const":
+		if !next.is("enum") {
+			return false
+		}
+		p.parseEnum(start)
+		return true
+	case "namespace", "module":
+		if next.kind != tName && next.kind != tString || !sameLine {
+			return false
+		}
+		p.failAt(t.start, "namespaces are not supported")
+	default:
+		return false
+	}
+	p.strip(start, p.prev.end)
+	return true
+}
+
+// startsBinding reports whether t starts a binding name or pattern.
+func (p *parser) startsBinding(t token) bool {
+	return isIdentifier(t) || t.is("[") || t.is("{")
+}
+
+func (p *parser) parseBlock() {
+	p.expect("{")
+	for !p.tok.is("}") {
+		if p.tok.kind == tEOF {
+			p.unexpected()
+		}
+		p.parseStatement()
+	}
+	p.next()
+}
+
+func (p *parser) parseParenExpression() {
+	p.expect("(")
+	p.parseExpression()
+	p.expect(")")
+}
+
+// parseVarDecls parses the declarations following var, let or const.
+func (p *parser) parseVarDecls() {
+	for {
+		p.parseBinding()
+		if p.tok.is("!") {
+			p.blank(p.tok.start, p.tok.end)
+			p.next()
+		}
+		if p.tok.is(":") {
+			p.parseTypeAnnotation()
+		}
+		if p.tok.is("=") {
+			p.next()
+			p.parseAssign()
+		}
+		if !p.tok.is(",") {
+			return
+		}
+		p.next()
+	}
+}
+
+// parseTypeAnnotation blanks the : and the type at the current token.
+func (p *parser) parseTypeAnnotation() {
+	start := p.tok.start
+	p.next()
+	p.skipType()
+	p.blank(start, p.prev.end)
+}
+
+func (p *parser) parseBinding() {
+	switch {
+	case p.tok.is("["):
+		p.next()
+		for !p.tok.is("]") {
+			if p.tok.is(",") {
+				p.next()
+				continue
+			}
+			if p.tok.is("...") {
+				p.next()
+			}
+			p.parseBinding()
+			if p.tok.is("=") {
+				p.next()
+				p.parseAssign()
+			}
+			if !p.tok.is(",") {
+				break
+			}
+			p.next()
+		}
+		p.expect("]")
+	case p.tok.is("{"):
+		p.next()
+		for !p.tok.is("}") {
+			if p.tok.is("...") {
+				p.next()
+				p.parseBinding()
+			} else {
+				p.parsePropertyKey()
+				if p.tok.is(":") {
+					p.next()
+					p.parseBinding()
+				}
+				if p.tok.is("=") {
+					p.next()
+					p.parseAssign()
+				}
+			}
+			if !p.tok.is(",") {
+				break
+			}
+			p.next()
+		}
+		p.expect("}")
+	case isIdentifier(p.tok):
+		p.next()
+	default:
+		p.unexpected()
+	}
+}
+
+func (p *parser) parsePropertyKey() {
+	switch p.tok.kind {
+	case tName, tString, tNumber, tPrivateName:
+		p.next()
+	default:
+		if !p.tok.is("[") {
+			p.unexpected()
+		}
+		p.next()
+		p.parseAssign()
+		p.expect("]")
+	}
+}
+
+func (p *parser) parseFor() {
+	p.expect("for")
+	if p.tok.is("await") {
+		p.next()
+	}
+	p.expect("(")
+	for !p.tok.is(")") {
+		switch {
+		case p.tok.is(";"), p.tok.is("of"), p.tok.is("in"):
+			p.next()
+		case p.tok.is("var"), p.tok.is("const"), p.tok.is("let") && p.startsBinding(p.peek()):
+			p.next()
+			p.parseVarDecls()
+		default:
+			p.parseExpression()
+			if !p.tok.is(";") && !p.tok.is(")") && !p.tok.is("of") {
+				p.unexpected()
+			}
+		}
+	}
+	p.next()
+	p.parseStatement()
+}
+
+func (p *parser) parseSwitch() {
+	p.expect("switch")
+	p.parseParenExpression()
+	p.expect("{")
+	for !p.tok.is("}") {
+		switch {
+		case p.tok.is("case"):
+			p.next()
+			p.parseExpression()
+			p.expect(":")
+		case p.tok.is("default"):
+			p.next()
+			p.expect(":")
+		case p.tok.kind == tEOF:
+			p.unexpected()
+		default:
+			p.parseStatement()
+		}
+	}
+	p.next()
+}
+
+func (p *parser) parseTry() {
+	p.expect("try")
+	p.parseBlock()
+	if p.tok.is("catch") {
+		p.next()
+		if p.tok.is("(") {
+			p.next()
+			p.parseBinding()
+			if p.tok.is(":") {
+				p.parseTypeAnnotation()
+			}
+			p.expect(")")
+		}
+		p.parseBlock()
+	}
+	if p.tok.is("finally") {
+		p.next()
+		p.parseBlock()
+	}
+}
+
+// parseFunction parses a function declaration or expression. Declarations
+// without a body are overloads and are removed from start.
+func (p *parser) parseFunction(start int, declaration bool) {
+	p.expect("function")
+	if p.tok.is("*") {
+		p.next()
+	}
+	if p.tok.kind == tName {
+		p.next()
+	}
+	p.parseTypeParams()
+	p.parseParams(nil)
+	p.parseReturnType()
+	if !p.tok.is("{") && declaration {
+		p.semicolon()
+		p.strip(start, p.prev.end)
+		return
+	}
+	p.parseBlock()
+}
+
+// parseTypeParams blanks type parameters.
+func (p *parser) parseTypeParams() {
+	if p.tok.is("<") {
+		start := p.tok.start
+		p.skipTypeParams()
+		p.blank(start, p.prev.end)
+	}
+}
+
+func (p *parser) parseReturnType() {
+	if p.tok.is(":") {
+		p.parseTypeAnnotation()
+	}
+}
+
+var parameterModifiers = map[string]bool{
+	"public": true, "private": true, "protected": true, "readonly": true, "override": true,
+}
+
+// parseParams parses a parameter list. The names of parameter properties,
+// constructor parameters with modifiers, are appended to props if not nil.
+func (p *parser) parseParams(props *[]string) {
+	p.expect("(")
+	for !p.tok.is(")") {
+		start := p.tok.start
+		property := false
+		for p.tok.kind == tName && parameterModifiers[p.tok.text] && p.startsBinding(p.peek()) {
+			p.blank(p.tok.start, p.tok.end)
+			p.next()
+			property = true
+		}
+		if p.tok.is("this") {
+			// this parameters only have a type
+			p.next()
+			if p.tok.is(":") {
+				p.next()
+				p.skipType()
+			}
+			if p.tok.is(",") {
+				p.next()
+			}
+			p.strip(start, p.prev.end)
+			continue
+		}
+		if p.tok.is("...") {
+			p.next()
+		}
+		name := p.tok
+		p.parseBinding()
+		if p.tok.is("?") {
+			p.blank(p.tok.start, p.tok.end)
+			p.next()
+		}
+		if p.tok.is(":") {
+			p.parseTypeAnnotation()
+		}
+		if p.tok.is("=") {
+			p.next()
+			p.parseAssign()
+		}
+		if property {
+			if props == nil || name.kind != tName {
+				p.failAt(start, "parameter properties are only allowed in constructors")
+			}
+			*props = append(*props, name.text)
+		}
+		if !p.tok.is(",") {
+			break
+		}
+		p.next()
+	}
+	p.expect(")")
+}
+
+// Classes
+
+func (p *parser) parseClass() {
+	p.expect("class")
+	if isIdentifier(p.tok) && !p.tok.is("implements") {
+		p.next()
+	}
+	p.parseTypeParams()
+	derived := false
+	if p.tok.is("extends") {
+		derived = true
+		p.next()
+		p.parseCallMember()
+		if p.tok.is("<") {
+			start := p.tok.start
+			p.skipTypeArgs()
+			p.blank(start, p.prev.end)
+		}
+	}
+	if p.tok.is("implements") {
+		start := p.tok.start
+		p.next()
+		p.skipType()
+		for p.tok.is(",") {
+			p.next()
+			p.skipType()
+		}
+		p.blank(start, p.prev.end)
+	}
+	p.parseClassBody(derived)
+}
+
+var memberModifiers = map[string]bool{
+	"static": true, "public": true, "private": true, "protected": true, "readonly": true,
+	"abstract": true, "override": true, "declare": true, "accessor": true,
+	"async": true, "get": true, "set": true,
+}
+
+// isModifier reports whether the current name token is a modifier rather than
+// the name of the member.
+func (p *parser) isModifier() bool {
+	if p.tok.kind != tName || !memberModifiers[p.tok.text] {
+		return false
+	}
+	next := p.peek()
+	if p.tok.is("async") && next.nl {
+		return false
+	}
+	switch next.kind {
+	case tName, tString, tNumber, tPrivateName:
+		return true
+	}
+	return next.is("[") || next.is("*") || next.is("{") && p.tok.is("static")
+}
+
+func (p *parser) parseClassBody(derived bool) {
+	p.expect("{")
+	for !p.tok.is("}") {
+		if p.tok.is(";") {
+			p.next()
+			continue
+		}
+		if p.tok.is("@") {
+			p.failAt(p.tok.start, "decorators are not supported")
+		}
+		if p.tok.kind == tEOF {
+			p.unexpected()
+		}
+		start := p.tok.start
+		removed := false
+		for p.isModifier() {
+			switch p.tok.text {
+			case "abstract", "declare":
+				removed = true
+			case "public", "private", "protected", "readonly", "override":
+				p.blank(p.tok.start, p.tok.end)
+			}
+			p.next()
+		}
+		if p.tok.is("{") && p.prev.is("static") && p.prev.start >= start {
+			p.parseBlock()
+			continue
+		}
+		if p.tok.is("*") {
+			p.next()
+		}
+		if p.tok.is("[") && p.isIndexSignature() {
+			p.skipBalanced()
+			p.parseTypeAnnotation()
+			p.semicolon()
+			p.strip(start, p.prev.end)
+			continue
+		}
+		key := p.tok
+		p.parsePropertyKey()
+		if p.tok.is("?") || p.tok.is("!") {
+			p.blank(p.tok.start, p.tok.end)
+			p.next()
+		}
+		if p.tok.is("(") || p.tok.is("<") {
+			p.parseTypeParams()
+			var props []string
+			constructor := key.text == "constructor" || key.text == `"constructor"` || key.text == "'constructor'"
+			if constructor {
+				p.parseParams(&props)
+			} else {
+				p.parseParams(nil)
+			}
+			p.parseReturnType()
+			switch {
+			case !p.tok.is("{"):
+				// overload or abstract method
+				p.semicolon()
+				p.strip(start, p.prev.end)
+			case len(props) > 0:
+				p.parseConstructorBody(props, derived)
+			default:
+				p.parseBlock()
+			}
+			if removed {
+				p.strip(start, p.prev.end)
+			}
+			continue
+		}
+		// field
+		if p.tok.is(":") {
+			p.parseTypeAnnotation()
+		}
+		initialized := false
+		if p.tok.is("=") {
+			initialized = true
+			p.next()
+			p.parseAssign()
+		}
+		p.semicolon()
+		if !initialized || removed {
+			p.strip(start, p.prev.end)
+		}
+	}
+	p.next()
+}
+
+// isIndexSignature reports whether the [ at the current token starts an index
+// signature like [key: string]: T.
+func (p *parser) isIndexSignature() bool {
+	name := p.peek()
+	if name.kind != tName {
+		return false
+	}
+	return p.lex(name.end).is(":")
+}
+
+// parseConstructorBody parses the body of a constructor with parameter
+// properties, assigning them at its start or after the super call.
+func (p *parser) parseConstructorBody(props []string, derived bool) {
+	var assign strings.Builder
+	for _, name := range props {
+		assign.WriteString(" this." + name + " = " + name + ";")
+	}
+	open := p.tok
+	p.expect("{")
+	inserted := false
+	if !derived {
+		p.insert(open.end, assign.String())
+		inserted = true
+	}
+	for !p.tok.is("}") {
+		if p.tok.kind == tEOF {
+			p.unexpected()
+		}
+		superCall := p.tok.is("super") && p.peek().is("(")
+		p.parseStatement()
+		if superCall && !inserted {
+			p.insert(p.prev.end, ";"+assign.String())
+			inserted = true
+		}
+	}
+	if !inserted {
+		p.insert(open.end, assign.String())
+	}
+	p.next()
+}
+
+// Enums
+
+// parseEnum rewrites an enum declaration to the code TypeScript emits, an
+// object with a reverse mapping of numeric members. Members can refer to the
+// previous ones by name.
+func (p *parser) parseEnum(start int) {
+	if p.tok.is("const") {
+		p.next()
+	}
+	p.expect("enum")
+	name := p.tok
+	p.next()
+	open := p.tok
+	p.expect("{")
+
+	var locals []string
+	prevKey := ""
+	for !p.tok.is("}") {
+		member := p.tok
+		var key string
+		switch member.kind {
+		case tName:
+			key = jsString(member.text)
+		case tString:
+			key = member.text
+		default:
+			p.failAt(member.start, "invalid enum member name")
+		}
+		p.next()
+		local := ""
+		if isIdentifier(member) && !strictReserved[member.text] && member.text != name.text {
+			locals = append(locals, member.text)
+			local = member.text + " = "
+		}
+
+		e := name.text
+		if p.tok.is("=") {
+			p.next()
+			valueStart := p.tok.start
+			p.parseAssign()
+			valueEnd := p.prev.end
+			stringValue := valueStart == p.prev.start && (p.prev.kind == tString || p.prev.kind == tTemplate)
+			if stringValue {
+				p.replace(member.start, valueStart, e+"["+key+"] = "+local)
+				p.endEnumMember(valueEnd, ";")
+			} else {
+				p.replace(member.start, valueStart, e+"["+e+"["+key+"] = "+local)
+				p.endEnumMember(valueEnd, "] = "+key+";")
+			}
+		} else {
+			value := "0"
+			if prevKey != "" {
+				value = e + "[" + prevKey + "] + 1"
+			}
+			p.replace(member.start, member.end, e+"["+e+"["+key+"] = "+local+value+"] = "+key+";")
+			p.endEnumMember(member.end, "")
+		}
+		prevKey = key
+	}
+	head := "var " + name.text + "; (function (" + name.text + ") {"
+	if len(locals) > 0 {
+		head += " var " + strings.Join(locals, ", ") + ";"
+	}
+	p.replace(start, open.end, head)
+	p.replace(p.tok.start, p.tok.end, "})("+name.text+" || ("+name.text+" = {}));")
+	p.next()
+}
+
+// endEnumMember replaces the separator of the member ending at end with
+// text.
+func (p *parser) endEnumMember(end int, text string) {
+	if p.tok.is(",") {
+		p.replace(end, p.tok.end, text)
+		p.next()
+		return
+	}
+	if !p.tok.is("}") {
+		p.unexpected()
+	}
+	if text != "" {
+		p.insert(end, text)
+	}
+}
+
+// Modules
+
+func (p *parser) parseImport(start int) {
+	p.expect("import")
+	next := p.peek()
+	switch {
+	case p.tok.is("type") && !next.is("from") && !next.is(",") && !next.is("="):
+		p.skipImportClause()
+		p.strip(start, p.prev.end)
+		return
+	case isIdentifier(p.tok) && next.is("="):
+		// import x = require("x")
+		p.replace(p.prev.start, p.prev.end, "var")
+		p.next()
+		p.next()
+		p.parseAssign()
+		p.semicolon()
+		return
+	}
+	p.skipImportClause()
+}
+
+// skipImportClause skips the rest of an import or export ... from statement,
+// blanking type only specifiers.
+func (p *parser) skipImportClause() {
+	for p.tok.kind != tString {
+		switch {
+		case p.tok.is("{"):
+			p.skipSpecifiers()
+		case p.tok.kind == tEOF, p.tok.is(";"):
+			p.unexpected()
+		default:
+			p.next()
+		}
+		if p.tok.is("from") {
+			p.next()
+		}
+	}
+	p.next()
+	if (p.tok.is("assert") || p.tok.is("with")) && !p.tok.nl {
+		p.next()
+		p.skipBalanced()
+	}
+	p.semicolon()
+}
+
+// skipSpecifiers skips {a, type B, c as d}, blanking type only specifiers.
+func (p *parser) skipSpecifiers() {
+	p.expect("{")
+	for !p.tok.is("}") {
+		start := p.tok.start
+		typeOnly := p.tok.is("type") && p.peek().kind == tName && !p.peek().is("as")
+		for !p.tok.is(",") && !p.tok.is("}") {
+			if p.tok.kind == tEOF {
+				p.unexpected()
+			}
+			p.next()
+		}
+		if p.tok.is(",") {
+			p.next()
+		}
+		if typeOnly {
+			p.blank(start, p.prev.end)
+		}
+	}
+	p.next()
+}
+
+func (p *parser) parseExport(start int) {
+	p.expect("export")
+	next := p.peek()
+	switch {
+	case p.tok.is("type") && (next.is("{") || next.is("*")):
+		p.next()
+		p.skipExportClause()
+		p.strip(start, p.prev.end)
+	case p.tok.is("{"), p.tok.is("*"):
+		p.skipExportClause()
+	case p.tok.is("default"):
+		p.next()
+		switch {
+		case p.tok.is("interface"):
+			p.skipInterface()
+			p.strip(start, p.prev.end)
+		case p.tok.is("abstract") && p.peek().is("class"):
+			p.blank(p.tok.start, p.tok.end)
+			p.next()
+			p.parseClass()
+		case p.tok.is("function"), p.tok.is("class"),
+			p.tok.is("async") && p.peek().is("function") && !p.peek().nl:
+			p.parseStatementAt(start)
+		default:
+			p.parseAssign()
+			p.semicolon()
+		}
+	case p.tok.is("="), p.tok.is("import"), p.tok.is("as"):
+		p.failAt(p.tok.start, "export "+p.tok.text+" is not supported")
+	default:
+		p.parseStatementAt(start)
+	}
+}
+
+func (p *parser) skipExportClause() {
+	if p.tok.is("{") {
+		p.skipSpecifiers()
+	} else {
+		p.next()
+		if p.tok.is("as") {
+			p.next()
+			p.next()
+		}
+	}
+	if p.tok.is("from") {
+		p.next()
+		if p.tok.kind != tString {
+			p.unexpected()
+		}
+		p.next()
+	}
+	p.semicolon()
+}
+
+// Expressions
+
+func (p *parser) parseExpression() {
+	p.parseAssign()
+	for p.tok.is(",") {
+		p.next()
+		p.parseAssign()
+	}
+}
+
+var assignOps = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true, "**=": true,
+	"<<=": true, ">>=": true, ">>>=": true, "&=": true, "|=": true, "^=": true,
+	"&&=": true, "||=": true, "??=": true,
+}
+
+var binaryOps = map[string]bool{
+	"+": true, "-": true, "*": true, "/": true, "%": true, "**": true,
+	"==": true, "!=": true, "===": true, "!==": true, "<": true, ">": true, "<=": true, ">=": true,
+	"<<": true, ">>": true, ">>>": true, "&": true, "|": true, "^": true,
+	"&&": true, "||": true, "??": true, "in": true, "instanceof": true,
+}
+
+func (p *parser) parseAssign() {
+	if p.parseArrow() {
+		return
+	}
+	if p.tok.is("yield") {
+		p.next()
+		if p.tok.is("*") {
+			p.next()
+		}
+		if !p.tok.nl && p.startsExpression() {
+			p.parseAssign()
+		}
+		return
+	}
+	p.parseConditional()
+	if p.tok.kind == tPunct && assignOps[p.tok.text] {
+		p.next()
+		p.parseAssign()
+	}
+}
+
+// startsExpression reports whether the current token may start an expression.
+func (p *parser) startsExpression() bool {
+	switch p.tok.kind {
+	case tEOF:
+		return false
+	case tPunct:
+		switch p.tok.text {
+		case ")", "]", "}", ",", ";", ":", "=>", "?":
+			return false
+		}
+	}
+	return true
+}
+
+// parseArrow parses an arrow function at the current token, reporting
+// whether there is one.
+func (p *parser) parseArrow() bool {
+	t := p.tok
+	async := false
+	if t.is("async") {
+		next := p.peek()
+		if next.nl || !(next.is("(") || next.is("<") || isIdentifier(next)) {
+			return false
+		}
+		async = true
+	}
+	ok := p.try(func() {
+		if async {
+			p.next()
+		}
+		switch {
+		case isIdentifier(p.tok):
+			p.next()
+		case p.tok.is("("), p.tok.is("<"):
+			p.parseTypeParams()
+			p.parseParams(nil)
+			p.parseReturnType()
+		default:
+			p.unexpected()
+		}
+		if !p.tok.is("=>") || p.tok.nl {
+			p.unexpected()
+		}
+	})
+	if !ok {
+		return false
+	}
+	p.next()
+	if p.tok.is("{") {
+		p.parseBlock()
+	} else {
+		p.parseAssign()
+	}
+	return true
+}
+
+func (p *parser) parseConditional() {
+	p.parseBinary()
+	if p.tok.is("?") {
+		p.next()
+		p.parseAssign()
+		p.expect(":")
+		p.parseAssign()
+	}
+}
+
+func (p *parser) parseBinary() {
+	p.parseUnary()
+	for {
+		t := p.tok
+		switch {
+		case (t.is("as") || t.is("satisfies")) && !t.nl:
+			p.next()
+			if p.tok.is("const") {
+				p.next()
+			} else {
+				p.skipType()
+			}
+			p.blank(t.start, p.prev.end)
+		case t.kind == tPunct && binaryOps[t.text], t.is("in"), t.is("instanceof"):
+			p.next()
+			p.parseUnary()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) parseUnary() {
+	t := p.tok
+	switch {
+	case t.kind == tPunct && (t.text == "!" || t.text == "~" || t.text == "+" || t.text == "-" || t.text == "++" || t.text == "--"),
+		t.is("typeof"), t.is("void"), t.is("delete"):
+		p.next()
+		p.parseUnary()
+	case t.is("await") && p.startsOperand(p.peek()):
+		p.next()
+		p.parseUnary()
+	case t.is("<") && !p.jsx:
+		// <T>value
+		p.next()
+		p.skipType()
+		p.expectGreater()
+		p.blank(t.start, p.prev.end)
+		p.parseUnary()
+	default:
+		p.parseCallMember()
+		if (p.tok.is("++") || p.tok.is("--")) && !p.tok.nl {
+			p.next()
+		}
+	}
+}
+
+// startsOperand reports whether t may start the operand of await, which is an
+// identifier in scripts.
+func (p *parser) startsOperand(t token) bool {
+	switch t.kind {
+	case tName, tNumber, tString, tTemplate, tPrivateName:
+		return true
+	case tPunct:
+		return t.text == "(" || t.text == "[" || t.text == "{" || t.text == "!" || t.text == "-" ||
+			t.text == "+" || t.text == "~" || t.text == "/" || t.text == "<"
+	}
+	return false
+}
+
+func (p *parser) parseCallMember() {
+	if p.tok.is("new") {
+		p.next()
+		if p.tok.is(".") {
+			// new.target
+			p.next()
+			p.next()
+		} else {
+			p.parsePrimary()
+			for p.tok.is(".") || p.tok.is("[") {
+				p.parseMember()
+			}
+			p.parseTypeArgsOf(true)
+			if p.tok.is("(") {
+				p.parseArguments()
+			}
+		}
+	} else {
+		p.parsePrimary()
+	}
+	for {
+		switch {
+		case p.tok.is("."), p.tok.is("["):
+			p.parseMember()
+		case p.tok.is("?."):
+			p.next()
+			switch {
+			case p.tok.is("("):
+				p.parseArguments()
+			case p.tok.is("["):
+				p.next()
+				p.parseExpression()
+				p.expect("]")
+			case p.tok.is("<"):
+				p.parseTypeArgsOf(false)
+				p.parseArguments()
+			default:
+				p.next()
+			}
+		case p.tok.is("("):
+			p.parseArguments()
+		case p.tok.kind == tTemplate:
+			p.parseTemplate(p.parseExpression)
+		case p.tok.is("!") && !p.tok.nl:
+			// non-null assertion
+			p.blank(p.tok.start, p.tok.end)
+			p.next()
+		case p.tok.is("<"):
+			if !p.parseTypeArgsOf(false) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) parseMember() {
+	if p.tok.is("[") {
+		p.next()
+		p.parseExpression()
+		p.expect("]")
+		return
+	}
+	p.next()
+	if p.tok.kind != tName && p.tok.kind != tPrivateName {
+		p.unexpected()
+	}
+	p.next()
+}
+
+// parseTypeArgsOf blanks the type arguments of a call or of new, reporting
+// whether there are any. Otherwise the < is a comparison.
+func (p *parser) parseTypeArgsOf(isNew bool) bool {
+	if !p.tok.is("<") {
+		return false
+	}
+	start := p.tok.start
+	return p.try(func() {
+		p.skipTypeArgs()
+		if !p.tok.is("(") && p.tok.kind != tTemplate && !(isNew && !p.startsExpression()) {
+			p.unexpected()
+		}
+		p.blank(start, p.prev.end)
+	})
+}
+
+func (p *parser) parseArguments() {
+	p.expect("(")
+	for !p.tok.is(")") {
+		if p.tok.is("...") {
+			p.next()
+		}
+		p.parseAssign()
+		if !p.tok.is(",") {
+			break
+		}
+		p.next()
+	}
+	p.expect(")")
+}
+
+func (p *parser) parsePrimary() {
+	t := p.tok
+	switch t.kind {
+	case tName:
+		switch {
+		case t.is("function"):
+			p.parseFunction(t.start, false)
+		case t.is("class"):
+			p.parseClass()
+		case t.is("async") && p.peek().is("function") && !p.peek().nl:
+			p.next()
+			p.parseFunction(t.start, false)
+		default:
+			p.next()
+		}
+	case tNumber, tString, tPrivateName:
+		p.next()
+	case tTemplate:
+		p.parseTemplate(p.parseExpression)
+	case tPunct:
+		switch t.text {
+		case "(":
+			p.parseParenExpression()
+		case "[":
+			p.parseArrayLiteral()
+		case "{":
+			p.parseObjectLiteral()
+		case "/", "/=":
+			p.tok = p.scanRegExp(t.start)
+			p.next()
+		case "<":
+			if !p.jsx {
+				p.unexpected()
+			}
+			p.parseJSX()
+		default:
+			p.unexpected()
+		}
+	default:
+		p.unexpected()
+	}
+}
+
+// parseTemplate parses a template literal, calling inner for its
+// substitutions.
+func (p *parser) parseTemplate(inner func()) {
+	for !p.tok.templateTail() {
+		p.next()
+		inner()
+		if !p.tok.is("}") {
+			p.unexpected()
+		}
+		start := p.tok.start
+		end := p.scanTemplate(start + 1)
+		p.tok = token{kind: tTemplate, start: start, end: end, text: p.src[start:end], nl: p.tok.nl}
+	}
+	p.next()
+}
+
+func (p *parser) parseArrayLiteral() {
+	p.expect("[")
+	for !p.tok.is("]") {
+		if p.tok.is(",") {
+			p.next()
+			continue
+		}
+		if p.tok.is("...") {
+			p.next()
+		}
+		p.parseAssign()
+		if !p.tok.is(",") {
+			break
+		}
+		p.next()
+	}
+	p.expect("]")
+}
+
+func (p *parser) parseObjectLiteral() {
+	p.expect("{")
+	for !p.tok.is("}") {
+		if p.tok.is("...") {
+			p.next()
+			p.parseAssign()
+		} else {
+			if (p.tok.is("get") || p.tok.is("set") || p.tok.is("async")) && p.isModifier() {
+				p.next()
+			}
+			if p.tok.is("*") {
+				p.next()
+			}
+			p.parsePropertyKey()
+			switch {
+			case p.tok.is("("), p.tok.is("<"):
+				p.parseTypeParams()
+				p.parseParams(nil)
+				p.parseReturnType()
+				p.parseBlock()
+			case p.tok.is(":"), p.tok.is("="):
+				p.next()
+				p.parseAssign()
+			}
+		}
+		if !p.tok.is(",") {
+			break
+		}
+		p.next()
+	}
+	p.expect("}")
+}
+
+// Types. They are skipped without edits, the caller blanks them.
+
+func (p *parser) skipType() {
+	p.skipUnionType()
+	if p.tok.is("extends") && !p.tok.nl {
+		// conditional type
+		p.next()
+		p.skipUnionType()
+		p.expect("?")
+		p.skipType()
+		p.expect(":")
+		p.skipType()
+	}
+}
+
+func (p *parser) skipUnionType() {
+	if p.tok.is("|") || p.tok.is("&") {
+		p.next()
+	}
+	p.skipOperatorType()
+	for p.tok.is("|") || p.tok.is("&") {
+		p.next()
+		p.skipOperatorType()
+	}
+}
+
+func (p *parser) skipOperatorType() {
+	for (p.tok.is("keyof") || p.tok.is("unique") || p.tok.is("readonly") || p.tok.is("infer")) && p.startsType(p.peek()) {
+		infer := p.tok.is("infer")
+		p.next()
+		if infer {
+			p.next()
+			if p.tok.is("extends") && !p.tok.nl {
+				// infer U extends X, only valid in conditional types
+				p.try(func() {
+					p.next()
+					p.skipOperatorType()
+					if p.tok.is("?") {
+						p.unexpected()
+					}
+				})
+			}
+			return
+		}
+	}
+	p.skipPrimaryType()
+	for p.tok.is("[") && !p.tok.nl {
+		p.skipBalanced()
+	}
+}
+
+// startsType reports whether t may start a type.
+func (p *parser) startsType(t token) bool {
+	switch t.kind {
+	case tName, tNumber, tString, tTemplate:
+		return true
+	case tPunct:
+		return t.text == "(" || t.text == "[" || t.text == "{" || t.text == "<" || t.text == "-"
+	}
+	return false
+}
+
+func (p *parser) skipPrimaryType() {
+	t := p.tok
+	switch {
+	case t.is("("):
+		p.skipBalanced()
+		if p.tok.is("=>") {
+			p.next()
+			p.skipType()
+		}
+	case t.is("<"):
+		p.skipTypeParams()
+		p.skipFunctionType()
+	case t.is("new"), t.is("abstract") && p.peek().is("new"):
+		if t.is("abstract") {
+			p.next()
+		}
+		p.next()
+		if p.tok.is("<") {
+			p.skipTypeParams()
+		}
+		p.skipFunctionType()
+	case t.is("{"), t.is("["):
+		p.skipBalanced()
+	case t.is("typeof"):
+		p.next()
+		if p.tok.is("import") {
+			p.next()
+			p.skipBalanced()
+		} else {
+			p.next()
+		}
+		p.skipQualifiedName()
+	case t.is("import"):
+		p.next()
+		p.skipBalanced()
+		p.skipQualifiedName()
+	case t.is("-"):
+		p.next()
+		if p.tok.kind != tNumber {
+			p.unexpected()
+		}
+		p.next()
+	case t.kind == tString, t.kind == tNumber:
+		p.next()
+	case t.kind == tTemplate:
+		p.parseTemplate(p.skipType)
+	case t.kind == tName:
+		p.next()
+		if t.is("asserts") && p.tok.kind == tName && !p.tok.nl {
+			// asserts x [is T]
+			p.next()
+			if p.tok.is("is") {
+				p.next()
+				p.skipType()
+			}
+			return
+		}
+		p.skipQualifiedName()
+		if p.tok.is("is") && !p.tok.nl {
+			// type predicate
+			p.next()
+			p.skipType()
+		}
+	default:
+		p.unexpected()
+	}
+}
+
+// skipQualifiedName skips the rest of a name like a.b.C<T>.
+func (p *parser) skipQualifiedName() {
+	for p.tok.is(".") {
+		p.next()
+		p.next()
+	}
+	if p.tok.is("<") && !p.tok.nl {
+		p.skipTypeArgs()
+	}
+}
+
+func (p *parser) skipFunctionType() {
+	if !p.tok.is("(") {
+		p.unexpected()
+	}
+	p.skipBalanced()
+	p.expect("=>")
+	p.skipType()
+}
+
+func (p *parser) skipTypeArgs() {
+	p.expect("<")
+	p.skipType()
+	for p.tok.is(",") {
+		p.next()
+		p.skipType()
+	}
+	p.expectGreater()
+}
+
+func (p *parser) skipTypeParams() {
+	p.expect("<")
+	for {
+		for (p.tok.is("const") || p.tok.is("in") || p.tok.is("out")) && p.peek().kind == tName {
+			p.next()
+		}
+		if p.tok.kind != tName {
+			p.unexpected()
+		}
+		p.next()
+		if p.tok.is("extends") {
+			p.next()
+			p.skipType()
+		}
+		if p.tok.is("=") {
+			p.next()
+			p.skipType()
+		}
+		if !p.tok.is(",") {
+			break
+		}
+		p.next()
+		if p.tok.kind == tPunct && p.tok.text[0] == '>' {
+			break
+		}
+	}
+	p.expectGreater()
+}
+
+// skipBalanced skips the bracketed tokens starting at the current (, [ or {.
+func (p *parser) skipBalanced() {
+	var close string
+	switch p.tok.text {
+	case "(":
+		close = ")"
+	case "[":
+		close = "]"
+	default:
+		close = "}"
+	}
+	p.next()
+	p.skipUntil(close)
+	p.next()
+}
+
+// skipUntil skips balanced tokens up to the closing token close.
+func (p *parser) skipUntil(close string) {
+	for !p.tok.is(close) {
+		switch {
+		case p.tok.kind == tEOF:
+			p.unexpected()
+		case p.tok.is("("), p.tok.is("["), p.tok.is("{"):
+			p.skipBalanced()
+		case p.tok.kind == tTemplate:
+			p.parseTemplate(func() { p.skipUntil("}") })
+		default:
+			p.next()
+		}
+	}
+}
+
+func (p *parser) skipInterface() {
+	p.expect("interface")
+	p.next()
+	if p.tok.is("<") {
+		p.skipTypeParams()
+	}
+	if p.tok.is("extends") {
+		p.next()
+		p.skipType()
+		for p.tok.is(",") {
+			p.next()
+			p.skipType()
+		}
+	}
+	if !p.tok.is("{") {
+		p.unexpected()
+	}
+	p.skipBalanced()
+}
+
+func (p *parser) skipTypeAlias() {
+	p.expect("type")
+	p.next()
+	if p.tok.is("<") {
+		p.skipTypeParams()
+	}
+	p.expect("=")
+	p.skipType()
+	p.semicolon()
+}
+
+// skipDeclaration skips the declaration following declare. The caller strips
+// it.
+func (p *parser) skipDeclaration() {
+	t := p.tok
+	switch {
+	case t.is("var"), t.is("let"), t.is("const") && !p.peek().is("enum"):
+		p.next()
+		p.parseVarDecls()
+		p.semicolon()
+	case t.is("function"):
+		p.parseFunction(t.start, true)
+	case t.is("async"):
+		p.next()
+		p.parseFunction(t.start, true)
+	case t.is("class"):
+		p.parseClass()
+	case t.is("abstract"):
+		p.next()
+		p.parseClass()
+	case t.is("interface"):
+		p.skipInterface()
+	case t.is("type"):
+		p.skipTypeAlias()
+	case t.is("const"), t.is("enum"), t.is("namespace"), t.is("module"), t.is("global"):
+		for !p.tok.is("{") {
+			if p.tok.kind == tEOF || p.tok.is(";") {
+				// declare module "x";
+				p.semicolon()
+				return
+			}
+			p.next()
+		}
+		p.skipBalanced()
+	default:
+		p.unexpected()
+	}
+}
+
+func jsString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\u2028':
+			b.WriteString(`\u2028`)
+		case '\u2029':
+			b.WriteString(`\u2029`)
+		default:
+			if r < 0x20 {
+				b.WriteString(`\x`)
+				b.WriteByte("0123456789abcdef"[r>>4])
+				b.WriteByte("0123456789abcdef"[r&0xf])
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,180 @@
+package v8ts
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// generate applies the sorted edits to src and returns the result with its
+// source map. Every line of kept code and every generated text is mapped.
+func generate(filename, src string, edits []edit) (string, []byte) {
+	g := &generator{src: src, lines: lineStarts(src)}
+	pos := 0
+	for _, e := range edits {
+		if e.start < pos {
+			// overlapping edits are a bug, keep the source
+			continue
+		}
+		g.copy(pos, e.start)
+		g.generated(e)
+		pos = e.end
+	}
+	g.copy(pos, len(src))
+
+	m := struct {
+		Version        int      `json:"version"`
+		File           string   `json:"file"`
+		Sources        []string `json:"sources"`
+		SourcesContent []string `json:"sourcesContent"`
+		Names          []string `json:"names"`
+		Mappings       string   `json:"mappings"`
+	}{
+		Version:        3,
+		File:           strings.TrimSuffix(path.Base(filepath.ToSlash(filename)), path.Ext(filename)) + ".js",
+		Sources:        []string{filename},
+		SourcesContent: []string{src},
+		Names:          []string{},
+		Mappings:       g.mappings.String(),
+	}
+	sourceMap, _ := json.Marshal(m)
+	return g.out.String(), sourceMap
+}
+
+type generator struct {
+	src   string
+	lines []int // offsets of the line starts of src
+
+	out     strings.Builder
+	genLine int
+	genCol  int // in UTF-16 code units
+
+	mappings strings.Builder
+	// previous values of the relative segment fields
+	lastGenCol, lastLine, lastCol int
+	lineHasSegment                bool
+}
+
+// copy emits src[start:end] unchanged.
+func (g *generator) copy(start, end int) {
+	if start == end {
+		return
+	}
+	g.segment(start)
+	text := g.src[start:end]
+	for {
+		nl := strings.IndexByte(text, '\n')
+		if nl < 0 {
+			g.write(text)
+			return
+		}
+		g.write(text[:nl+1])
+		start += nl + 1
+		text = text[nl+1:]
+		if text != "" {
+			g.segment(start)
+		}
+	}
+}
+
+// generated emits the text of e, mapping it to the start of the replaced code
+// and the lines following its line breaks to the lines of the replaced code.
+func (g *generator) generated(e edit) {
+	if e.text == "" {
+		return
+	}
+	g.segment(e.start)
+	text := e.text
+	replaced := g.src[e.start:e.end]
+	srcPos := e.start
+	for {
+		nl := strings.IndexByte(text, '\n')
+		if nl < 0 {
+			g.write(text)
+			return
+		}
+		g.write(text[:nl+1])
+		text = text[nl+1:]
+		if i := strings.IndexByte(replaced, '\n'); i >= 0 {
+			srcPos += i + 1
+			replaced = replaced[i+1:]
+		}
+		if text != "" {
+			g.segment(srcPos)
+		}
+	}
+}
+
+func (g *generator) write(s string) {
+	g.out.WriteString(s)
+	if strings.HasSuffix(s, "\n") {
+		g.genLine++
+		g.genCol = 0
+		g.lastGenCol = 0
+		g.lineHasSegment = false
+		g.mappings.WriteByte(';')
+		return
+	}
+	g.genCol += utf16Len(s)
+}
+
+// segment maps the current output position to the source offset pos.
+func (g *generator) segment(pos int) {
+	line, col := position(g.src, g.lines, pos)
+	if g.lineHasSegment {
+		g.mappings.WriteByte(',')
+	}
+	g.lineHasSegment = true
+	writeVLQ(&g.mappings, g.genCol-g.lastGenCol)
+	writeVLQ(&g.mappings, 0) // the only source
+	writeVLQ(&g.mappings, line-g.lastLine)
+	writeVLQ(&g.mappings, col-g.lastCol)
+	g.lastGenCol, g.lastLine, g.lastCol = g.genCol, line, col
+}
+
+func lineStarts(src string) []int {
+	lines := []int{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// position returns the 0-based line and UTF-16 column of the offset pos.
+func position(src string, lines []int, pos int) (line, col int) {
+	line = sort.Search(len(lines), func(i int) bool { return lines[i] > pos }) - 1
+	return line, utf16Len(src[lines[line]:pos])
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+func writeVLQ(b *strings.Builder, n int) {
+	v := n << 1
+	if n < 0 {
+		v = -n<<1 | 1
+	}
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		b.WriteByte(base64Digits[digit])
+		if v == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package v8ts compiles TypeScript to javascript for V8 without a separate
+// build step. It is a pure Go transpiler in the spirit of Babel's and
+// esbuild's TypeScript support: it doesn't type-check, it removes types.
+//
+// Type annotations, interfaces, type aliases, declare statements, overloads,
+// access modifiers, non-null assertions, as and satisfies expressions, type
+// arguments and type only imports are blanked out. Enums and parameter
+// properties are rewritten to the code TypeScript emits for them, and JSX
+// elements in .tsx and .jsx files become calls of the JSX factory, by default
+// React.createElement. Namespaces and decorators are not supported.
+//
+// The output keeps every line of code on its original line and types are
+// replaced with spaces, so the line numbers of errors and stack traces match
+// the TypeScript source. The source map of the result also covers the columns
+// moved by JSX.
+package v8ts
+
+import (
+	"encoding/base64"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options configures Transpile.
+type Options struct {
+	// JSXFactory is the function JSX elements are compiled to calls of.
+	// Defaults to "React.createElement".
+	JSXFactory string
+	// JSXFragment is the element type of <></> fragments. Defaults to
+	// "React.Fragment".
+	JSXFragment string
+	// JSX forces JSX support. Otherwise JSX is only parsed in .tsx and
+	// .jsx files, as <T>value is a type assertion in .ts files.
+	JSX bool
+}
+
+func (o *Options) jsxFactory() string {
+	if o == nil || o.JSXFactory == "" {
+		return "React.createElement"
+	}
+	return o.JSXFactory
+}
+
+func (o *Options) jsxFragment() string {
+	if o == nil || o.JSXFragment == "" {
+		return "React.Fragment"
+	}
+	return o.JSXFragment
+}
+
+// Result is transpiled code.
+type Result struct {
+	Code string
+	// SourceMap is the version 3 source map of Code, embedding the source.
+	SourceMap []byte
+}
+
+// SourceMapURL returns the source map as a data: URL, e.g. for the
+// SourceMapURL of a v8worker.ScriptOrigin.
+func (r *Result) SourceMapURL() string {
+	return "data:application/json;charset=utf-8;base64," + base64.StdEncoding.EncodeToString(r.SourceMap)
+}
+
+// Error is a syntax error in the transpiled code. Line and Column start at 1.
+type Error struct {
+	Filename string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return e.Filename + ":" + strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Column) + ": " + e.Message
+}
+
+// Transpile compiles the TypeScript code of the file filename to javascript.
+// Syntax errors are returned as *Error.
+func Transpile(filename, code string, opts *Options) (res *Result, err error) {
+	ext := strings.ToLower(path.Ext(filepath.ToSlash(filename)))
+	p := &parser{
+		src:  code,
+		jsx:  ext == ".tsx" || ext == ".jsx" || opts != nil && opts.JSX,
+		opts: opts,
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			perr, ok := r.(*parseError)
+			if !ok {
+				panic(r)
+			}
+			line, col := position(code, lineStarts(code), perr.pos)
+			res, err = nil, &Error{Filename: filename, Line: line + 1, Column: col + 1, Message: perr.msg}
+		}
+	}()
+	p.parseProgram()
+
+	sort.SliceStable(p.edits, func(i, j int) bool { return p.edits[i].start < p.edits[j].start })
+	out, sourceMap := generate(filename, code, p.edits)
+	return &Result{Code: out, SourceMap: sourceMap}, nil
+}
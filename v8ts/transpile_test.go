@@ -0,0 +1,214 @@
+package v8ts
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func transpile(t *testing.T, filename, code string) string {
+	res, err := Transpile(filename, code, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(res.Code, "\n") != strings.Count(code, "\n") {
+		t.Fatalf("lines moved:\n%s", res.Code)
+	}
+	return res.Code
+}
+
+func TestTranspileTypes(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"let x: number = 1;", "let x         = 1;"},
+		{"function f<T>(a: T, b?: string): T { return a; }", "function f   (a   , b         )    { return a; }"},
+		{"const f = (a: number): number => a * 2;", "const f = (a        )         => a * 2;"},
+		{"const g = <T,>(v: T) => v;", "const g =     (v   ) => v;"},
+		{"x = y as unknown as string[];", "x = y                       ;"},
+		{"x = <any>y;", "x =      y;"},
+		{"x = a!.b!;", "x = a .b ;"},
+		{"x = f<string>(1) + a < b;", "x = f        (1) + a < b;"},
+		{"x = new Map<string, Array<number>>();", "x = new Map                       ();"},
+		{"interface A { b: string }\nlet a;", "                         \nlet a;"},
+		{"type P<T> = [T, T];", "                   "},
+		{"declare const v: string;", "                        "},
+		{"import type { A } from 'a';", "                           "},
+		{"import { type A, b } from 'a';", "import {         b } from 'a';"},
+		{"function o(a: string): void;\nfunction o(a: any) {}", "                            \nfunction o(a     ) {}"},
+		{"function t(this: Window, a: number) {}", "function t(              a        ) {}"},
+		{"if (a < b && c > d) {}", "if (a < b && c > d) {}"},
+		{"const t = `a${b as string}c`;", "const t = `a${b          }c`;"},
+		{"const r = /<T>/g;", "const r = /<T>/g;"},
+		{"for (const [k, v]: [string, number] of e) {}", "for (const [k, v]                   of e) {}"},
+	}
+	for _, test := range tests {
+		if out := transpile(t, "test.ts", test.in); out != test.out {
+			t.Fatalf("%q:\ngot  %q\nwant %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestTranspileClasses(t *testing.T) {
+	code := `abstract class A<T> extends B<T> implements I, J {
+	private readonly x: number;
+	static y: number = 1;
+	[key: string]: any;
+	abstract m(): void;
+	constructor(public a: string, b?: number) {
+		super(a);
+	}
+	get z(): number { return 1; }
+}`
+	want := `         class A    extends B                    {
+
+	static y         = 1;
+
+
+	constructor(       a        , b         ) {
+		super(a);; this.a = a;
+	}
+	get z()         { return 1; }
+}`
+	if out := trimLines(transpile(t, "test.ts", code)); out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestTranspileEnum(t *testing.T) {
+	code := "enum E {\n\tA,\n\tB = 4,\n\tC,\n\tD = \"d\",\n}"
+	want := "var E; (function (E) { var A, B, C, D;\n" +
+		"\tE[E[\"A\"] = A = 0] = \"A\";\n" +
+		"\tE[E[\"B\"] = B = 4] = \"B\";\n" +
+		"\tE[E[\"C\"] = C = E[\"B\"] + 1] = \"C\";\n" +
+		"\tE[\"D\"] = D = \"d\";\n" +
+		"})(E || (E = {}));"
+	if out := transpile(t, "test.ts", code); out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestTranspileJSX(t *testing.T) {
+	code := `const el = <div id="a" {...props} hidden>
+	Hello &amp; welcome
+	{items.map((i: string) => <Item key={i} />)}
+	<>x</>
+</div>;`
+	want := `const el = React.createElement("div", Object.assign({}, {id: "a"}, props, {hidden: true}), "Hello & welcome",
+
+items.map((i        ) => React.createElement(Item, {key: i})),
+React.createElement(React.Fragment, null, "x"))
+;`
+	if out := trimLines(transpile(t, "test.tsx", code)); out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	res, err := Transpile("test.tsx", `<a b="c"/>`, &Options{JSXFactory: "h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != `h("a", {b: "c"})` {
+		t.Fatal("bad factory", res.Code)
+	}
+}
+
+func TestTranspileErrors(t *testing.T) {
+	tests := []struct{ code, err string }{
+		{"let a = ;", "test.ts:1:9: unexpected ;"},
+		{"let a = 1;\nnamespace N {}", "test.ts:2:1: namespaces are not supported"},
+		{"let s = 'abc", "test.ts:1:9: unterminated string literal"},
+		{"f(a: number", "test.ts:1:4: expected ), got :"},
+	}
+	for _, test := range tests {
+		_, err := Transpile("test.ts", test.code, nil)
+		if err == nil || err.Error() != test.err {
+			t.Fatalf("%q: expected error %q, got %v", test.code, test.err, err)
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	code := "let a: string = <b>x</b>; let c = 1;\nlet d = 2;"
+	res, err := Transpile("test.tsx", code, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m struct {
+		Version  int
+		Sources  []string
+		Mappings string
+	}
+	if err := json.Unmarshal(res.SourceMap, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 3 || len(m.Sources) != 1 || m.Sources[0] != "test.tsx" {
+		t.Fatal("bad source map", string(res.SourceMap))
+	}
+
+	// the generated position of "let c", moved by the JSX, maps to its source
+	// position
+	genCol := strings.Index(res.Code, "let c")
+	var mapped [4]int
+	for _, s := range decodeMappings(t, m.Mappings) {
+		if s[0] == 0 && s[1] <= genCol {
+			mapped = s
+		}
+		if s[0] == 1 && s[1] == 0 && (s[2] != 1 || s[3] != 0) {
+			t.Fatal("second line is not mapped to itself", s)
+		}
+	}
+	if genCol == strings.Index(code, "let c") || mapped[2] != 0 || mapped[3]+genCol-mapped[1] != strings.Index(code, "let c") {
+		t.Fatal("bad mapping of let c", m.Mappings)
+	}
+	if !strings.HasPrefix(res.SourceMapURL(), "data:application/json;") {
+		t.Fatal("bad source map URL")
+	}
+}
+
+// trimLines removes the spaces left by stripped code at the end of lines.
+func trimLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// decodeMappings returns the segments of mappings as absolute generated line
+// and column, and source line and column.
+func decodeMappings(t *testing.T, mappings string) [][4]int {
+	var segments [][4]int
+	var srcLine, srcCol int
+	for line, group := range strings.Split(mappings, ";") {
+		genCol := 0
+		for _, segment := range strings.Split(group, ",") {
+			if segment == "" {
+				continue
+			}
+			var fields []int
+			value, shift := 0, 0
+			for _, c := range segment {
+				digit := strings.IndexRune(base64Digits, c)
+				if digit < 0 {
+					t.Fatal("bad mappings", mappings)
+				}
+				value += (digit & 31) << shift
+				shift += 5
+				if digit&32 == 0 {
+					if value&1 != 0 {
+						fields = append(fields, -(value >> 1))
+					} else {
+						fields = append(fields, value>>1)
+					}
+					value, shift = 0, 0
+				}
+			}
+			if len(fields) != 4 {
+				t.Fatal("bad segment", segment)
+			}
+			genCol += fields[0]
+			srcLine += fields[2]
+			srcCol += fields[3]
+			segments = append(segments, [4]int{line, genCol, srcLine, srcCol})
+		}
+	}
+	return segments
+}
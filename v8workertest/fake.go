@@ -0,0 +1,240 @@
+// Package v8workertest provides a scriptable fake of v8worker.Runtime for unit
+// testing host code without V8.
+//
+//	f := v8workertest.New(onSend, onSendSync)
+//	f.RespondSync("ping", "pong")
+//	f.HandleSend(func(msg string) error {
+//		f.Emit("echo: " + msg) // as if javascript called $send
+//		return nil
+//	})
+//	f.Throw("bad", "Error: bad message")
+//	runHostCode(f)
+//
+// The package only depends on v8runtime, so it builds without V8 and cgo.
+package v8workertest
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/getblank/v8worker/v8runtime"
+)
+
+// Load is a script loaded into a fake.
+type Load struct {
+	Origin v8runtime.ScriptOrigin
+	Code   string
+}
+
+// Fake is an in-memory v8runtime.Runtime. No javascript runs: Send and
+// SendSync are answered by the handlers and canned responses registered by
+// the test, and Emit and EmitSync simulate the javascript calling $send and
+// $sendSync. Every call is recorded. A Fake is safe for concurrent use.
+type Fake struct {
+	cb     func(msg string)
+	syncCB func(msg string) string
+
+	locker       sync.Mutex
+	syncReplies  map[string]string
+	exceptions   map[string]string
+	loadFailures map[string]string
+	sendHandler  func(msg string) error
+	syncHandler  func(msg string) string
+	heap         v8runtime.HeapStatistics
+
+	loads        []Load
+	sends        []string
+	syncSends    []string
+	terminations int
+}
+
+var _ v8runtime.Runtime = (*Fake)(nil)
+
+// New creates a fake whose $send and $sendSync messages, see Emit and
+// EmitSync, go to cb and syncCB like those of a worker. Either may be nil.
+func New(cb func(msg string), syncCB func(msg string) string) *Fake {
+	return &Fake{
+		cb:           cb,
+		syncCB:       syncCB,
+		syncReplies:  make(map[string]string),
+		exceptions:   make(map[string]string),
+		loadFailures: make(map[string]string),
+	}
+}
+
+// RespondSync makes SendSync(msg) return response.
+func (f *Fake) RespondSync(msg string, response string) {
+	f.locker.Lock()
+	f.syncReplies[msg] = response
+	f.locker.Unlock()
+}
+
+// HandleSync sets the function answering SendSync messages without a canned
+// response. By default they are answered with an empty string.
+func (f *Fake) HandleSync(fn func(msg string) string) {
+	f.locker.Lock()
+	f.syncHandler = fn
+	f.locker.Unlock()
+}
+
+// HandleSend sets the function called by Send, standing in for the $recv
+// callback. An error it returns is returned by Send like a javascript
+// exception.
+func (f *Fake) HandleSend(fn func(msg string) error) {
+	f.locker.Lock()
+	f.sendHandler = fn
+	f.locker.Unlock()
+}
+
+// Throw makes Send(msg) and SendSync(msg) fail as if the javascript callback
+// threw exception, e.g. "Error: bad message".
+func (f *Fake) Throw(msg string, exception string) {
+	f.locker.Lock()
+	f.exceptions[msg] = exception
+	f.locker.Unlock()
+}
+
+// ThrowOnLoad makes loading the script named scriptName fail with exception.
+func (f *Fake) ThrowOnLoad(scriptName string, exception string) {
+	f.locker.Lock()
+	f.loadFailures[scriptName] = exception
+	f.locker.Unlock()
+}
+
+// SetHeapStatistics sets the statistics returned by GetHeapStatistics.
+func (f *Fake) SetHeapStatistics(hs v8runtime.HeapStatistics) {
+	f.locker.Lock()
+	f.heap = hs
+	f.locker.Unlock()
+}
+
+// Emit simulates the javascript calling $send(msg).
+func (f *Fake) Emit(msg string) {
+	if f.cb != nil {
+		f.cb(msg)
+	}
+}
+
+// EmitSync simulates the javascript calling $sendSync(msg) and returns the
+// response of the host.
+func (f *Fake) EmitSync(msg string) string {
+	if f.syncCB == nil {
+		return ""
+	}
+	return f.syncCB(msg)
+}
+
+// Load records the script, failing if ThrowOnLoad was called for scriptName.
+func (f *Fake) Load(scriptName string, code string) error {
+	return f.LoadWithOptions(&v8runtime.ScriptOrigin{ScriptName: scriptName}, code)
+}
+
+// LoadWithOptions records the script, failing if ThrowOnLoad was called for
+// its name.
+func (f *Fake) LoadWithOptions(origin *v8runtime.ScriptOrigin, code string) error {
+	if origin == nil {
+		origin = new(v8runtime.ScriptOrigin)
+	}
+	f.locker.Lock()
+	f.loads = append(f.loads, Load{Origin: *origin, Code: code})
+	exception, ok := f.loadFailures[origin.ScriptName]
+	f.locker.Unlock()
+	if ok {
+		return exceptionError(origin.ScriptName, exception)
+	}
+	return nil
+}
+
+// Send records msg and passes it to the HandleSend function, failing if Throw
+// was called for msg.
+func (f *Fake) Send(msg string) error {
+	f.locker.Lock()
+	f.sends = append(f.sends, msg)
+	exception, ok := f.exceptions[msg]
+	handler := f.sendHandler
+	f.locker.Unlock()
+	if ok {
+		return exceptionError("", exception)
+	}
+	if handler != nil {
+		return handler(msg)
+	}
+	return nil
+}
+
+// SendSync records msg and returns its canned response, the result of the
+// HandleSync function, or "err: " and the exception set with Throw.
+func (f *Fake) SendSync(msg string) string {
+	f.locker.Lock()
+	f.syncSends = append(f.syncSends, msg)
+	exception, thrown := f.exceptions[msg]
+	reply, canned := f.syncReplies[msg]
+	handler := f.syncHandler
+	f.locker.Unlock()
+	switch {
+	case thrown:
+		return "err: " + exception
+	case canned:
+		return reply
+	case handler != nil:
+		return handler(msg)
+	}
+	return ""
+}
+
+// TerminateExecution counts the termination, see Terminations.
+func (f *Fake) TerminateExecution() {
+	f.locker.Lock()
+	f.terminations++
+	f.locker.Unlock()
+}
+
+// GetHeapStatistics returns the statistics set with SetHeapStatistics.
+func (f *Fake) GetHeapStatistics() *v8runtime.HeapStatistics {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	hs := f.heap
+	return &hs
+}
+
+// Loads returns the scripts loaded so far, in order.
+func (f *Fake) Loads() []Load {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return append([]Load(nil), f.loads...)
+}
+
+// Sends returns the messages passed to Send so far, in order.
+func (f *Fake) Sends() []string {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return append([]string(nil), f.sends...)
+}
+
+// SyncSends returns the messages passed to SendSync so far, in order.
+func (f *Fake) SyncSends() []string {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return append([]string(nil), f.syncSends...)
+}
+
+// Terminations returns the number of TerminateExecution calls.
+func (f *Fake) Terminations() int {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return f.terminations
+}
+
+// exceptionError formats exception like the errors of uncaught javascript
+// exceptions returned by a worker.
+func exceptionError(scriptName string, exception string) error {
+	msg := exception
+	if scriptName != "" {
+		msg = scriptName + "\n" + msg
+	}
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,81 @@
+package v8workertest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/getblank/v8worker/v8runtime"
+)
+
+func TestFakeSend(t *testing.T) {
+	var received []string
+	f := New(func(msg string) {
+		received = append(received, msg)
+	}, nil)
+	f.HandleSend(func(msg string) error {
+		f.Emit("echo: " + msg)
+		return nil
+	})
+	f.Throw("bad", "Error: bad message")
+
+	var rt v8runtime.Runtime = f
+	if err := rt.Send("hi"); err != nil {
+		t.Fatal(err)
+	}
+	err := rt.Send("bad")
+	if err == nil || !strings.Contains(err.Error(), "Error: bad message") {
+		t.Fatal("expected exception", err)
+	}
+	if len(received) != 1 || received[0] != "echo: hi" {
+		t.Fatal("bad $send messages", received)
+	}
+	if sends := f.Sends(); len(sends) != 2 || sends[1] != "bad" {
+		t.Fatal("bad sends", sends)
+	}
+}
+
+func TestFakeSendSync(t *testing.T) {
+	f := New(nil, func(msg string) string {
+		return "host: " + msg
+	})
+	f.RespondSync("ping", "pong")
+	f.HandleSync(strings.ToUpper)
+	f.Throw("bad", "TypeError: x is undefined")
+
+	if res := f.SendSync("ping"); res != "pong" {
+		t.Fatal("bad canned response", res)
+	}
+	if res := f.SendSync("other"); res != "OTHER" {
+		t.Fatal("bad handler response", res)
+	}
+	if res := f.SendSync("bad"); res != "err: TypeError: x is undefined" {
+		t.Fatal("bad exception response", res)
+	}
+	if res := f.EmitSync("q"); res != "host: q" {
+		t.Fatal("bad $sendSync response", res)
+	}
+}
+
+func TestFakeLoad(t *testing.T) {
+	f := New(nil, nil)
+	f.ThrowOnLoad("broken.js", "SyntaxError: Unexpected token")
+	if err := f.Load("ok.js", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.LoadWithOptions(&v8runtime.ScriptOrigin{ScriptName: "broken.js"}, "("); err == nil {
+		t.Fatal("expected load error")
+	}
+	loads := f.Loads()
+	if len(loads) != 2 || loads[0].Origin.ScriptName != "ok.js" || loads[1].Code != "(" {
+		t.Fatal("bad loads", loads)
+	}
+
+	f.TerminateExecution()
+	if f.Terminations() != 1 {
+		t.Fatal("bad terminations", f.Terminations())
+	}
+	f.SetHeapStatistics(v8runtime.HeapStatistics{UsedHeapSize: 42})
+	if f.GetHeapStatistics().UsedHeapSize != 42 {
+		t.Fatal("bad heap statistics")
+	}
+}
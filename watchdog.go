@@ -0,0 +1,195 @@
+package v8worker
+
+/*
+#include "binding.h"
+*/
+import "C"
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is the termination reason of calls stopped by the watchdog.
+var ErrTimeout = errors.New("v8worker: execution timed out")
+
+// watchdogLogSize is the number of terminated calls kept by WatchdogLog.
+const watchdogLogSize = 100
+
+// TerminatedCall describes a call terminated by the watchdog.
+type TerminatedCall struct {
+	WorkerId int
+	Call     string // e.g. "Load code.js" or "SendSync"
+	Started  time.Time
+	Deadline time.Time
+}
+
+// The watchdog is a single goroutine enforcing the timeouts of all workers.
+// In-flight calls are kept in a heap ordered by deadline.
+type watchdog struct {
+	sync.Mutex
+	calls   watchdogHeap
+	wake    chan struct{}
+	running bool
+	log     []TerminatedCall
+}
+
+type watchdogCall struct {
+	worker   *Worker
+	call     string
+	started  time.Time
+	deadline time.Time
+	index    int
+	fired    bool
+}
+
+var defaultWatchdog = &watchdog{wake: make(chan struct{}, 1)}
+
+// WatchdogLog returns the most recent calls terminated by the watchdog,
+// oldest first.
+func WatchdogLog() []TerminatedCall {
+	defaultWatchdog.Lock()
+	defer defaultWatchdog.Unlock()
+	log := make([]TerminatedCall, len(defaultWatchdog.log))
+	copy(log, defaultWatchdog.log)
+	return log
+}
+
+func (wd *watchdog) add(w *Worker, call string, timeout time.Duration) *watchdogCall {
+	now := time.Now()
+	c := &watchdogCall{
+		worker:   w,
+		call:     call,
+		started:  now,
+		deadline: now.Add(timeout),
+	}
+	wd.Lock()
+	heap.Push(&wd.calls, c)
+	if !wd.running {
+		wd.running = true
+		go wd.run()
+	}
+	first := c.index == 0
+	wd.Unlock()
+	if first {
+		select {
+		case wd.wake <- struct{}{}:
+		default:
+		}
+	}
+	return c
+}
+
+// remove reports whether the call was terminated by the watchdog.
+func (wd *watchdog) remove(c *watchdogCall) bool {
+	wd.Lock()
+	defer wd.Unlock()
+	if c.index >= 0 {
+		heap.Remove(&wd.calls, c.index)
+	}
+	return c.fired
+}
+
+func (wd *watchdog) run() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		wd.Lock()
+		now := time.Now()
+		for len(wd.calls) > 0 && !wd.calls[0].deadline.After(now) {
+			c := heap.Pop(&wd.calls).(*watchdogCall)
+			c.fired = true
+			c.worker.Terminate(ErrTimeout)
+			wd.log = append(wd.log, TerminatedCall{
+				WorkerId: c.worker.id,
+				Call:     c.call,
+				Started:  c.started,
+				Deadline: c.deadline,
+			})
+			if len(wd.log) > watchdogLogSize {
+				wd.log = wd.log[len(wd.log)-watchdogLogSize:]
+			}
+		}
+		wait := time.Hour
+		if len(wd.calls) > 0 {
+			wait = wd.calls[0].deadline.Sub(now)
+		}
+		wd.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-wd.wake:
+		}
+	}
+}
+
+type watchdogHeap []*watchdogCall
+
+func (h watchdogHeap) Len() int           { return len(h) }
+func (h watchdogHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+
+func (h watchdogHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *watchdogHeap) Push(x interface{}) {
+	c := x.(*watchdogCall)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *watchdogHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	old[n-1] = nil
+	c.index = -1
+	*h = old[:n-1]
+	return c
+}
+
+// workerCall is a call in progress in a worker, see startCall.
+type workerCall struct {
+	wd     *watchdogCall
+	nested bool // made from a callback of the worker
+}
+
+// startCall waits for the calls in progress in the worker to finish, unless
+// it is made from one of the worker's callbacks, and registers it with the
+// watchdog if the worker has a timeout. The deadline is only armed once the
+// call owns the worker: time spent queued doesn't count, and the watchdog never
+// terminates the javascript of another call. endCall must be called once the
+// results of the call are read, since they are kept in the worker.
+func (w *Worker) startCall(call string) workerCall {
+	if C.worker_is_locked(w.cWorker) {
+		// the outer call owns the worker and its deadline applies
+		return workerCall{nested: true}
+	}
+	w.callLocker.Lock()
+	if w.timeout <= 0 {
+		return workerCall{}
+	}
+	return workerCall{wd: defaultWatchdog.add(w, call, w.timeout)}
+}
+
+// endCall unregisters a call. If the watchdog fired but the call finished
+// before V8 noticed, the pending termination is cancelled so it doesn't hit
+// the next call.
+func (w *Worker) endCall(c workerCall) {
+	if c.nested {
+		return
+	}
+	if c.wd != nil && defaultWatchdog.remove(c.wd) && !bool(C.worker_last_terminated(w.cWorker)) {
+		w.cancelTermination()
+	}
+	w.callLocker.Unlock()
+}
@@ -0,0 +1,78 @@
+package v8worker
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	worker := NewWithConfig(func(msg string) {}, DiscardSendSync, &Config{Timeout: 200 * time.Millisecond})
+
+	err := worker.Load("forever.js", `
+		$recv(function(msg) { while (true) { ; } });
+		$recvSync(function(msg) { while (true) { ; } });
+		while (true) { ; }
+	`)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatal("Expected ErrTimeout from Load", err)
+	}
+	if err := worker.Send("spin"); !errors.Is(err, ErrTimeout) {
+		t.Fatal("Expected ErrTimeout from Send", err)
+	}
+	if response := worker.SendSync("spin"); response != "err: "+(&TerminatedError{Reason: ErrTimeout}).Error() {
+		t.Fatal("Expected timeout from SendSync", response)
+	}
+
+	// calls finishing in time are left alone
+	if err := worker.Load("quick.js", `$print("quick")`); err != nil {
+		t.Fatal(err)
+	}
+
+	found := 0
+	for _, call := range WatchdogLog() {
+		if call.WorkerId == worker.id {
+			found++
+		}
+	}
+	if found != 3 {
+		t.Fatal("Expected 3 terminated calls in the log", found)
+	}
+}
+
+func TestWatchdogManyWorkers(t *testing.T) {
+	done := make(chan error)
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			worker := NewWithConfig(func(msg string) {}, DiscardSendSync, &Config{Timeout: time.Duration(50+i*10) * time.Millisecond})
+			done <- worker.Load("forever.js", ` while (true) { ; } `)
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-done; !errors.Is(err, ErrTimeout) {
+			t.Fatal("Expected ErrTimeout", err)
+		}
+	}
+}
+
+func TestWatchdogQueuedCall(t *testing.T) {
+	worker := NewWithConfig(func(msg string) {}, DiscardSendSync, &Config{Timeout: 400 * time.Millisecond})
+	spin := func(name string, ms int) error {
+		return worker.Load(name, `var end = Date.now() + `+strconv.Itoa(ms)+`; while (Date.now() < end) { ; }`)
+	}
+
+	// the second call waits 150ms for the first one; only its own 300ms of
+	// javascript count against the timeout
+	running := make(chan error)
+	go func() {
+		running <- spin("running.js", 250)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if err := spin("queued.js", 300); err != nil {
+		t.Fatal("queued call was charged for waiting", err)
+	}
+	if err := <-running; err != nil {
+		t.Fatal("running call was terminated", err)
+	}
+}
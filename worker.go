@@ -9,10 +9,17 @@ package v8worker
 import "C"
 import (
 	"errors"
+	"fmt"
+	"os"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 	"unsafe"
+
+	"github.com/getblank/v8worker/v8runtime"
 )
 
 var (
@@ -27,74 +34,191 @@ var (
 	callbacksMap           = make(map[int]*callbacks)
 )
 
+// DefaultStackSize is the native stack size javascript may use when
+// Config.StackSize is not set. It matches the V8 default.
+const DefaultStackSize = 984 * 1024
+
+// ErrTerminated is the reason of terminations requested with TerminateExecution.
+var ErrTerminated = errors.New("v8worker: execution terminated")
+
+// ErrWorkerDead is returned by calls on a worker whose isolate hit a fatal error.
+var ErrWorkerDead = errors.New("v8worker: worker is dead")
+
 // To receive messages from javascript...
 type ReceiveMessageCallback func(msg string)
 
 // To send a message from javascript and synchronously return a string.
 type ReceiveSyncMessageCallback func(msg string) string
 
+// To be notified of V8 fatal errors before the process dies.
+type FatalErrorCallback func(err *FatalError)
+
 // This is a golang wrapper around a single V8 Isolate.
 type Worker struct {
 	cWorker *C.worker
+	id      int
+	timeout time.Duration
+
+	callLocker sync.Mutex // serializes calls, see startCall
+
+	terminateLocker sync.Mutex
+	terminateReason error
+
+	filesLocker sync.Mutex
+	files       []loadedFile
+
+	typescript *TypeScriptOptions
+	importMap  *ImportMap
 }
 
 // This is a wrapper for worker callbacks
 type callbacks struct {
-	cb     ReceiveMessageCallback
-	syncCB ReceiveSyncMessageCallback
+	cb      ReceiveMessageCallback
+	syncCB  ReceiveSyncMessageCallback
+	fatalCB FatalErrorCallback
+	require *requireResolver
+	json    reflect.Value // see OnJSON
+	codec   Codec
+	value   reflect.Value // see OnValue
+	rpc     *rpcPeer
 }
 
-// ScriptOrigin represents V8 class – see http://v8.paulfryzel.com/docs/master/classv8_1_1_script_origin.html
-type ScriptOrigin struct {
-	ScriptName            string
-	LineOffset            int32
-	ColumnOffset          int32
-	IsSharedCrossOrigin   bool
-	ScriptId              int32
-	IsEmbedderDebugScript bool
-	SourceMapURL          string
-	IsOpaque              bool
+// Config holds optional settings of a worker created with NewWithConfig.
+type Config struct {
+	// StackSize is the number of bytes of native stack javascript may use
+	// before a RangeError is thrown. It is capped by the stack of the OS
+	// thread running the call. Defaults to DefaultStackSize.
+	StackSize int
+	// Timeout, if set, is the wall-clock limit of every Load, Send and
+	// SendSync call. Overdue calls are terminated by the package watchdog
+	// and fail with a *TerminatedError wrapping ErrTimeout.
+	Timeout time.Duration
+	// MaxHeapSize, if set, limits the old generation of the V8 heap, in
+	// bytes. Exceeding it is a fatal OOM error, see OnFatal.
+	MaxHeapSize int
+	// TypeScript, if set, makes LoadFile and LoadFS transpile .ts, .tsx and
+	// .jsx files with these options, see LoadTypeScript. It also sets the
+	// options of LoadTypeScript.
+	TypeScript *TypeScriptOptions
+	// ImportMap, if set, remaps the specifiers of require, see EnableRequire,
+	// before they are resolved. Unmapped bare specifiers are still looked up
+	// in node_modules.
+	ImportMap *ImportMap
 }
 
-// HeapStatistics represents V8 class - see http://v8.paulfryzel.com/docs/master/classv8_1_1_heap_statistics.html
-type HeapStatistics struct {
-	TotalHeapSize           int
-	TotalHeapSizeExecutable int
-	TotalPhysicalSize       int
-	TotalAvailableSize      int
-	UsedHeapSize            int
-	HeapSizeLimit           int
-	MallocedMemory          int
-	DoesZapGarbage          int
+// FatalError describes a V8 fatal error. V8 5.0 has no separate OOM handler
+// (Isolate::SetOOMErrorHandler came later): running out of memory reaches the
+// fatal error handler too, with a message starting with oomMessagePrefix, and
+// OOM is set in that case.
+type FatalError struct {
+	Location       string
+	Message        string
+	OOM            bool
+	HeapStatistics *HeapStatistics
 }
 
+func (e *FatalError) Error() string {
+	return "v8 fatal error in " + e.Location + ": " + e.Message
+}
+
+// TerminatedError is returned by calls whose javascript execution was
+// terminated. It wraps the reason given to Terminate.
+type TerminatedError struct {
+	Reason error
+}
+
+func (e *TerminatedError) Error() string {
+	return "execution terminated: " + e.Reason.Error()
+}
+
+func (e *TerminatedError) Unwrap() error {
+	return e.Reason
+}
+
+// ScriptOrigin represents V8 class – see http://v8.paulfryzel.com/docs/master/classv8_1_1_script_origin.html
+type ScriptOrigin = v8runtime.ScriptOrigin
+
+// HeapStatistics represents V8 class - see http://v8.paulfryzel.com/docs/master/classv8_1_1_heap_statistics.html
+type HeapStatistics = v8runtime.HeapStatistics
+
 // Version return the V8 version E.G. "4.3.59"
 func Version() string {
 	return C.GoString(C.worker_version())
 }
 
+// SetFlags sets V8 command line flags, e.g. "--harmony --stack-size=500".
+// Most flags only have an effect if set before the first worker is created.
+func SetFlags(flags string) {
+	cFlags := C.CString(flags)
+	defer C.free(unsafe.Pointer(cFlags))
+	C.v8_set_flags(cFlags)
+}
+
 //export recvCb
 func recvCb(msg_s *C.char, workerId int) {
 	msg := C.GoString(msg_s)
 	callbacksMapLocker.RLock()
-	fn := callbacksMap[workerId].cb
+	cbs := callbacksMap[workerId]
 	callbacksMapLocker.RUnlock()
-	fn(msg)
+	if cbs.rpc != nil && strings.HasPrefix(msg, rpcPrefix) {
+		cbs.rpc.receive(msg[len(rpcPrefix):])
+		return
+	}
+	cbs.cb(msg)
+}
+
+// oomMessagePrefix starts the messages V8::FatalProcessOutOfMemory passes to
+// the fatal error handler, e.g. "Allocation failed - process out of memory",
+// whatever the location of the failed allocation.
+const oomMessagePrefix = "Allocation failed - "
+
+//export fatalCb
+func fatalCb(location_s *C.char, message_s *C.char, hs *C.struct_heap_statistics_s, workerId int) {
+	err := &FatalError{
+		Location:       C.GoString(location_s),
+		Message:        C.GoString(message_s),
+		HeapStatistics: newHeapStatistics(hs),
+	}
+	err.OOM = strings.HasPrefix(err.Message, oomMessagePrefix)
+	callbacksMapLocker.RLock()
+	fn := callbacksMap[workerId].fatalCB
+	callbacksMapLocker.RUnlock()
+	if fn == nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fn(err)
 }
 
 //export recvSyncCb
 func recvSyncCb(msg_s *C.char, workerId int) *C.char {
 	msg := C.GoString(msg_s)
 	callbacksMapLocker.RLock()
-	fn := callbacksMap[workerId].syncCB
+	cbs := callbacksMap[workerId]
 	callbacksMapLocker.RUnlock()
-	res := fn(msg)
+	if cbs.rpc != nil && strings.HasPrefix(msg, rpcPrefix) {
+		return C.CString(cbs.rpc.receiveSync(msg[len(rpcPrefix):]))
+	}
+	res := cbs.syncCB(msg)
 	return C.CString(res)
 }
 
 // New creates a new worker, which corresponds to a V8 isolate. A single threaded
 // standalone execution context.
 func New(cb ReceiveMessageCallback, syncCB ReceiveSyncMessageCallback) *Worker {
+	return NewWithConfig(cb, syncCB, nil)
+}
+
+// NewWithConfig creates a new worker like New with the settings of config,
+// which may be nil.
+func NewWithConfig(cb ReceiveMessageCallback, syncCB ReceiveSyncMessageCallback, config *Config) *Worker {
+	if config == nil {
+		config = new(Config)
+	}
+	stackSize := config.StackSize
+	if stackSize <= 0 {
+		stackSize = DefaultStackSize
+	}
 	id := nextWorkerId()
 
 	cbWrapper := &callbacks{
@@ -109,8 +233,8 @@ func New(cb ReceiveMessageCallback, syncCB ReceiveSyncMessageCallback) *Worker {
 		C.v8_init()
 	})
 
-	worker := &Worker{}
-	worker.cWorker = C.worker_new(C.int(id))
+	worker := &Worker{id: id, timeout: config.Timeout, typescript: config.TypeScript, importMap: config.ImportMap}
+	worker.cWorker = C.worker_new(C.int(id), C.int(stackSize), C.int((config.MaxHeapSize+(1<<20)-1)>>20))
 	runtime.SetFinalizer(worker, func(final_worker *Worker) {
 		C.worker_dispose(final_worker.cWorker)
 		callbacksMapLocker.Lock()
@@ -120,6 +244,21 @@ func New(cb ReceiveMessageCallback, syncCB ReceiveSyncMessageCallback) *Worker {
 	return worker
 }
 
+// OnFatal sets the callback called when V8 hits a fatal error or runs out of
+// memory in this worker. For most of these V8 aborts the process once the
+// callback returns; otherwise the worker is marked as dead and further calls
+// fail with ErrWorkerDead. Without a callback the error is printed to stderr.
+func (w *Worker) OnFatal(cb FatalErrorCallback) {
+	callbacksMapLocker.Lock()
+	callbacksMap[w.id].fatalCB = cb
+	callbacksMapLocker.Unlock()
+}
+
+// IsDead reports whether the worker hit a fatal error and can't run javascript anymore.
+func (w *Worker) IsDead() bool {
+	return bool(C.worker_is_dead(w.cWorker))
+}
+
 // Optional notification that the embedder is idle.
 // http://v8.paulfryzel.com/docs/master/classv8_1_1_isolate.html#aba794ed25d4fa8780b3a07c66a5e5d4a
 func (w *Worker) IdleNotificationDeadline(deadLineInSeconds float64) bool {
@@ -136,6 +275,10 @@ func (w *Worker) Load(scriptName string, code string) error {
 func (w *Worker) GetHeapStatistics() *HeapStatistics {
 	hs := C.struct_heap_statistics_s{}
 	C.worker_get_heap_statistics(w.cWorker, &hs)
+	return newHeapStatistics(&hs)
+}
+
+func newHeapStatistics(hs *C.struct_heap_statistics_s) *HeapStatistics {
 	return &HeapStatistics{
 		TotalHeapSize:           int(hs.total_heap_size),
 		TotalHeapSizeExecutable: int(hs.total_heap_size_executable),
@@ -152,27 +295,25 @@ func (w *Worker) GetHeapStatistics() *HeapStatistics {
 // origin and the contents of the file specified by the param code.
 func (w *Worker) LoadWithOptions(origin *ScriptOrigin, code string) error {
 	cCode := C.CString(code)
+	defer C.free(unsafe.Pointer(cCode))
 
-	if origin == nil {
-		origin = new(ScriptOrigin)
-	}
-	if origin.ScriptName == "" {
-		origin.ScriptName = nextScriptName()
-	}
-	cScriptName := C.CString(origin.ScriptName)
-	cLineOffset := C.int(origin.LineOffset)
-	cColumnOffset := C.int(origin.ColumnOffset)
-	cIsSharedCrossOrigin := C.bool(origin.IsSharedCrossOrigin)
-	cScriptId := C.int(origin.ScriptId)
-	cIsEmbedderDebugScript := C.bool(origin.IsEmbedderDebugScript)
-	cSourceMapURL := C.CString(origin.SourceMapURL)
-	cIsOpaque := C.bool(origin.IsOpaque)
+	o := newCScriptOrigin(origin)
+	defer o.free()
 
-	defer C.free(unsafe.Pointer(cScriptName))
-	defer C.free(unsafe.Pointer(cCode))
-	defer C.free(unsafe.Pointer(cSourceMapURL))
+	call := w.startCall("Load " + o.scriptName)
+	defer w.endCall(call)
+	r := C.worker_load(w.cWorker, cCode, o.name, o.lineOffset, o.columnOffset, o.isSharedCrossOrigin, o.scriptId, o.isEmbedderDebugScript, o.sourceMapURL, o.isOpaque)
+	return w.loadError(r)
+}
 
-	r := C.worker_load(w.cWorker, cCode, cScriptName, cLineOffset, cColumnOffset, cIsSharedCrossOrigin, cScriptId, cIsEmbedderDebugScript, cSourceMapURL, cIsOpaque)
+// loadError converts the result of a worker_load* call to an error.
+func (w *Worker) loadError(r C.int) error {
+	if w.IsDead() {
+		return ErrWorkerDead
+	}
+	if C.worker_last_terminated(w.cWorker) {
+		return w.terminatedError()
+	}
 	if r != 0 {
 		errStr := C.worker_last_exception(w.cWorker)
 		return errors.New(C.GoString(errStr))
@@ -180,6 +321,46 @@ func (w *Worker) LoadWithOptions(origin *ScriptOrigin, code string) error {
 	return nil
 }
 
+// cScriptOrigin holds the C values of a ScriptOrigin.
+type cScriptOrigin struct {
+	scriptName            string
+	name                  *C.char
+	lineOffset            C.int
+	columnOffset          C.int
+	isSharedCrossOrigin   C.bool
+	scriptId              C.int
+	isEmbedderDebugScript C.bool
+	sourceMapURL          *C.char
+	isOpaque              C.bool
+}
+
+// newCScriptOrigin converts origin, naming the script if it has no name.
+// free must be called once the values are no longer used.
+func newCScriptOrigin(origin *ScriptOrigin) *cScriptOrigin {
+	if origin == nil {
+		origin = new(ScriptOrigin)
+	}
+	if origin.ScriptName == "" {
+		origin.ScriptName = nextScriptName()
+	}
+	return &cScriptOrigin{
+		scriptName:            origin.ScriptName,
+		name:                  C.CString(origin.ScriptName),
+		lineOffset:            C.int(origin.LineOffset),
+		columnOffset:          C.int(origin.ColumnOffset),
+		isSharedCrossOrigin:   C.bool(origin.IsSharedCrossOrigin),
+		scriptId:              C.int(origin.ScriptId),
+		isEmbedderDebugScript: C.bool(origin.IsEmbedderDebugScript),
+		sourceMapURL:          C.CString(origin.SourceMapURL),
+		isOpaque:              C.bool(origin.IsOpaque),
+	}
+}
+
+func (o *cScriptOrigin) free() {
+	C.free(unsafe.Pointer(o.name))
+	C.free(unsafe.Pointer(o.sourceMapURL))
+}
+
 // LowMemoryNotification for optional notification that the system is running low on memory.
 // V8 uses these notifications to attempt to free memory.
 // http://v8.paulfryzel.com/docs/master/classv8_1_1_isolate.html#aaf446f4877e4707a93d2c406fffd9fd6
@@ -192,7 +373,15 @@ func (w *Worker) Send(msg string) error {
 	msg_s := C.CString(string(msg))
 	defer C.free(unsafe.Pointer(msg_s))
 
+	call := w.startCall("Send")
+	defer w.endCall(call)
 	r := C.worker_send(w.cWorker, msg_s)
+	if w.IsDead() {
+		return ErrWorkerDead
+	}
+	if C.worker_last_terminated(w.cWorker) {
+		return w.terminatedError()
+	}
 	if r != 0 {
 		errStr := C.worker_last_exception(w.cWorker)
 		return errors.New(C.GoString(errStr))
@@ -207,15 +396,72 @@ func (w *Worker) SendSync(msg string) string {
 	msg_s := C.CString(string(msg))
 	defer C.free(unsafe.Pointer(msg_s))
 
+	call := w.startCall("SendSync")
+	defer w.endCall(call)
 	svalue := C.worker_send_sync(w.cWorker, msg_s)
+	if C.worker_last_terminated(w.cWorker) {
+		return "err: " + w.terminatedError().Error()
+	}
 	return C.GoString(svalue)
 }
 
 // TerminateExecution terminates execution of javascript
 func (w *Worker) TerminateExecution() {
+	w.Terminate(ErrTerminated)
+}
+
+// Terminate terminates execution of javascript. The call running it returns a
+// *TerminatedError wrapping reason. Once the javascript stack has unwound the
+// worker accepts new calls again.
+func (w *Worker) Terminate(reason error) {
+	if reason == nil {
+		reason = ErrTerminated
+	}
+	w.terminateLocker.Lock()
+	w.terminateReason = reason
+	w.terminateLocker.Unlock()
 	C.worker_terminate_execution(w.cWorker)
 }
 
+// IsExecutionTerminating reports whether javascript execution is being
+// terminated and the javascript stack is still unwinding.
+func (w *Worker) IsExecutionTerminating() bool {
+	return bool(C.worker_is_execution_terminating(w.cWorker))
+}
+
+// lastTerminated reports whether the last call into V8 was terminated.
+func (w *Worker) lastTerminated() bool {
+	return bool(C.worker_last_terminated(w.cWorker))
+}
+
+// CancelTerminateExecution cancels a termination requested with Terminate or
+// TerminateExecution which hasn't stopped any javascript yet, e.g. because
+// the call it was meant for returned first. Otherwise it would terminate the
+// next call.
+func (w *Worker) CancelTerminateExecution() {
+	w.cancelTermination()
+}
+
+// cancelTermination drops a termination requested after the javascript it was
+// meant for already returned.
+func (w *Worker) cancelTermination() {
+	w.terminateLocker.Lock()
+	w.terminateReason = nil
+	w.terminateLocker.Unlock()
+	C.worker_cancel_terminate_execution(w.cWorker)
+}
+
+func (w *Worker) terminatedError() error {
+	w.terminateLocker.Lock()
+	reason := w.terminateReason
+	w.terminateReason = nil
+	w.terminateLocker.Unlock()
+	if reason == nil {
+		reason = ErrTerminated
+	}
+	return &TerminatedError{Reason: reason}
+}
+
 func nextWorkerId() int {
 	workerIdSequenceLocker.Lock()
 	seq := workerIdSequence
@@ -1,7 +1,10 @@
 package v8worker
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
 	"runtime"
 	"strings"
 	"testing"
@@ -266,3 +269,119 @@ func TestGetHeapStatistics(t *testing.T) {
 	statistics = worker.GetHeapStatistics()
 	fmt.Println("Used 3: ", statistics.UsedHeapSize)
 }
+
+func TestOnFatal(t *testing.T) {
+	worker := New(func(msg string) {}, DiscardSendSync)
+	worker.OnFatal(func(err *FatalError) {
+		t.Error("unexpected fatal error", err)
+	})
+	if err := worker.Load("code.js", `$print("alive")`); err != nil {
+		t.Fatal(err)
+	}
+	if worker.IsDead() {
+		t.Fatal("Expected live worker")
+	}
+}
+
+// TestOnFatalOOM runs itself in a child process, as V8 aborts the process
+// after reporting that it ran out of memory.
+func TestOnFatalOOM(t *testing.T) {
+	if os.Getenv("V8WORKER_OOM_CHILD") == "1" {
+		worker := NewWithConfig(func(msg string) {}, DiscardSendSync, &Config{MaxHeapSize: 16 << 20})
+		worker.OnFatal(func(err *FatalError) {
+			fmt.Printf("fatal oom=%v used=%v\n", err.OOM, err.HeapStatistics != nil && err.HeapStatistics.UsedHeapSize > 0)
+			os.Stdout.Sync()
+		})
+		worker.Load("oom.js", `var a = []; for (;;) { a.push(new Array(1e5).join("x") + a.length); }`)
+		fmt.Println("survived")
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestOnFatalOOM$")
+	cmd.Env = append(os.Environ(), "V8WORKER_OOM_CHILD=1")
+	out, err := cmd.Output()
+	if err == nil {
+		t.Fatal("Expected the child to be aborted", string(out))
+	}
+	if !strings.Contains(string(out), "fatal oom=true used=true") || strings.Contains(string(out), "survived") {
+		t.Fatal("Expected OOM fatal error callback", string(out))
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	worker := NewWithConfig(func(msg string) {}, DiscardSendSync, &Config{StackSize: 256 * 1024})
+	expected := "Maximum call stack size exceeded"
+
+	err := worker.Load("recursion.js", `
+		function recurse(n) { return recurse(n + 1) + 1; }
+		$recv(function(msg) { recurse(0); });
+		$recvSync(function(msg) { return "" + recurse(0); });
+		recurse(0);
+	`)
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatal("Expected RangeError from Load", err)
+	}
+
+	err = worker.Send("recurse")
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatal("Expected RangeError from Send", err)
+	}
+
+	response := worker.SendSync("recurse")
+	if !strings.HasPrefix(response, "err: ") || !strings.Contains(response, expected) {
+		t.Fatal("Expected RangeError from SendSync", response)
+	}
+
+	// the stack limit follows the goroutine onto other threads
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		done <- worker.Send("recurse")
+	}()
+	if err := <-done; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatal("Expected RangeError from another thread", err)
+	}
+}
+
+func TestTerminateReason(t *testing.T) {
+	worker := New(func(msg string) {}, DiscardSendSync)
+	reason := errors.New("deadline exceeded")
+
+	go func(w *Worker) {
+		time.Sleep(100 * time.Millisecond)
+		w.Terminate(reason)
+	}(worker)
+
+	err := worker.Load("forever.js", ` while (true) { ; } `)
+	if !errors.Is(err, reason) {
+		t.Fatal("Expected error wrapping reason", err)
+	}
+	if worker.IsExecutionTerminating() {
+		t.Fatal("Expected termination to be cancelled")
+	}
+	if err := worker.Load("after.js", `$print("resumed")`); err != nil {
+		t.Fatal(err)
+	}
+
+	go func(w *Worker) {
+		time.Sleep(100 * time.Millisecond)
+		w.TerminateExecution()
+	}(worker)
+	err = worker.Load("forever.js", ` while (true) { ; } `)
+	if !errors.Is(err, ErrTerminated) {
+		t.Fatal("Expected ErrTerminated", err)
+	}
+}
+
+func TestCancelTerminateExecution(t *testing.T) {
+	worker := New(func(msg string) {}, DiscardSendSync)
+
+	// a termination requested while no javascript runs would stop the next
+	// call
+	worker.TerminateExecution()
+	worker.CancelTerminateExecution()
+	if err := worker.Load("after.js", `$print("not terminated")`); err != nil {
+		t.Fatal(err)
+	}
+}